@@ -2,4 +2,4 @@ module lesiw.io/buzzybox
 
 go 1.21.0
 
-require golang.org/x/sys v0.17.0 // indirect
+require golang.org/x/sys v0.17.0
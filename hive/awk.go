@@ -7,17 +7,19 @@ import (
 	"fmt"
 	"io"
 	"math"
-	"os"
+	"math/big"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"lesiw.io/buzzybox/internal/flag"
 	"lesiw.io/buzzybox/internal/posix"
 )
 
-const awkUsage = `usage: awk [-v VAR=VAL...] [-F SEP] [-f PROGRAM_FILE | PROGRAM] [FILE...]
+const awkUsage = `usage: awk [--csv] [--gawk] [-M] [-v VAR=VAL...] [-F SEP] [-f PROGRAM_FILE | PROGRAM] [FILE...]
 
 A pattern scanning and processing language.`
 
@@ -31,6 +33,9 @@ func Awk(cmd *Cmd) (code int) {
 		prog      string
 		flags     = flag.NewFlagSet(cmd.Stderr, "awk")
 		sep       = flags.String("F", "Field separator")
+		csv       = flags.Bool("csv", "Read records and fields as CSV")
+		gawk      = flags.Bool("gawk", "Enable gawk extension functions")
+		bignum    = flags.Bool("M", "Use arbitrary-precision arithmetic")
 		progfiles = &stringlist{}
 		vars      = &stringlist{}
 	)
@@ -41,11 +46,15 @@ func Awk(cmd *Cmd) (code int) {
 		return 1
 	}
 	p := newawkp(cmd)
+	p.csv = *csv
+	p.gawk = *gawk
+	p.bignum = *bignum
+	p.extend()
 	if *sep != "" {
 		p.sym("FS").SetString(*sep)
 	}
 	for _, f := range *progfiles {
-		txt, err := os.ReadFile(f)
+		txt, err := readfile(cmd.FS, f)
 		if err != nil {
 			fmt.Fprintf(cmd.Stderr, "bad file: %s\n", f)
 			return 1
@@ -57,9 +66,9 @@ func Awk(cmd *Cmd) (code int) {
 		flags.Args = flags.Args[1:]
 	}
 	p.sym("ARGC").SetNum(float64(len(flags.Args) + 1))
-	p.sym("ARGV").SetKey("0", p.string(cmd.Args[0]))
+	p.sym("ARGV").SetKey("0", p.strnum(cmd.Args[0]))
 	for i, a := range flags.Args {
-		p.sym("ARGV").SetKey(strconv.Itoa(i+1), p.string(a))
+		p.sym("ARGV").SetKey(strconv.Itoa(i+1), p.strnum(a))
 	}
 	for _, v := range *vars {
 		varval := strings.SplitN(v, "=", 2)
@@ -67,145 +76,201 @@ func Awk(cmd *Cmd) (code int) {
 			fmt.Fprintf(cmd.Stderr, "bad variable, want VAR=VAL: %s\n", v)
 			return 1
 		}
-		var val string
-		if val, err = p.unescape(varval[1]); err != nil {
-			fmt.Fprintf(cmd.Stderr, "bad escape: %s\n", v)
-		}
-		p.sym(varval[0]).SetString(val)
+		p.setvar(varval[0], awkunescape(varval[1]))
 	}
 	if prog == "" {
 		flags.PrintUsage()
 		return 1
 	}
-	if p.tokens, err = p.lexer.lex(prog); err != nil {
+	var compiled *awkprog
+	if compiled, err = newawkprog(prog); err != nil {
 		prettyPrintError(cmd.Stderr, err)
 		return 1
 	}
-	if err := p.findblocks(); err != nil {
+	if err = p.load(compiled); err != nil {
 		prettyPrintError(cmd.Stderr, err)
 		return 1
 	}
 	if code, err = p.exec(); err != nil {
-		prettyPrintError(cmd.Stderr, err)
+		if cmd.ctx.Err() == nil {
+			prettyPrintError(cmd.Stderr, err)
+		}
 		return 1
 	}
 	return
 }
 
 type awkp struct {
+	*awkprog
 	cmd *Cmd
 
-	lexer  *lexer
-	tokens []*token
-	pos    int
-
 	filereader io.RuneScanner
 	argvoffset int
 	readfile   bool
-
+	csv        bool
+	gawk       bool   // Whether the functions in awkgawkfuncs may be called.
+	bignum     bool   // Whether arithmetic is done with math/big; see awkbig.go.
+	sep        string // Which of awkseps was assigned last.
+
+	stdin   *bufio.Reader
+	stdout  *bufio.Writer
+	linebuf bool // Whether to flush stdout after every print.
 	readers map[string]runeScanCloser
 	writers map[string]io.WriteCloser
+	regexps map[string]*regexp.Regexp
+	rsre    *regexp.Regexp // The last RS regexp and its awkprefixes.
+	rspre   *regexp.Regexp
+	rand    posix.Rand
+	seed    float64 // The seed last passed to srand.
+
+	globals []*awkcell
+	ranges  []bool // Whether each range pattern in items is active.
+	gofuncs map[string]awkbuiltin
+	externs map[string]awkbuiltin // Functions called but not defined by the program.
+
+	frames   []*awkframe
+	exitcode int
+	retval   *awkcell
+	tok      *token // Token of the last call or statement, for panics.
 
-	erefn        strset
-	stopstmt     strset
-	stopexpr     strset
-	stopexprlist strset
-	stopprint    strset
-	endstmt      strset
-
-	stmts map[string]awkeval
-	exprs []awkeval
-
-	fntok  []*token
-	frames []*awkframe
-
-	begins   []*token
-	ends     []*token
-	items    []*awkitem
-	symbols  map[string]*awkcell
-	fields   []*awkcell
-	builtins map[string]awkbuiltin
-}
-
-type awkfn struct {
-	params []*token
-	block  *token
+	symbols map[string]*awkcell
+	fields  []*awkcell
 }
 
 type awkframe struct {
-	symbols map[string]*awkcell
+	locals []*awkcell
+}
+
+type awkbuiltin func(*awkp, []*awkcell) (*awkcell, error)
+
+var awkbuiltins = map[string]awkbuiltin{
+	"atan2":   (*awkp).atan2fn,
+	"close":   (*awkp).closefn,
+	"cos":     (*awkp).cosfn,
+	"exp":     (*awkp).expfn,
+	"fflush":  (*awkp).fflushfn,
+	"gsub":    (*awkp).gsubfn,
+	"int":     (*awkp).intfn,
+	"length":  (*awkp).lengthfn,
+	"index":   (*awkp).indexfn,
+	"log":     (*awkp).logfn,
+	"match":   (*awkp).matchfn,
+	"rand":    (*awkp).randfn,
+	"sin":     (*awkp).sinfn,
+	"split":   (*awkp).splitfn,
+	"sprintf": (*awkp).sprintffn,
+	"sqrt":    (*awkp).sqrtfn,
+	"srand":   (*awkp).srandfn,
+	"sub":     (*awkp).subfn,
+	"substr":  (*awkp).substrfn,
+	"system":  (*awkp).systemfn,
+	"tolower": (*awkp).tolowerfn,
+	"toupper": (*awkp).toupperfn,
+}
+
+// Functions that are not in POSIX awk, so that POSIX programs may use their
+// names for variables and functions. They may be called with --gawk.
+var awkgawkfuncs = map[string]awkbuiltin{
+	"and":      (*awkp).andfn,
+	"asort":    (*awkp).asortfn,
+	"asorti":   (*awkp).asortifn,
+	"compl":    (*awkp).complfn,
+	"gensub":   (*awkp).gensubfn,
+	"lshift":   (*awkp).lshiftfn,
+	"mktime":   (*awkp).mktimefn,
+	"or":       (*awkp).orfn,
+	"patsplit": (*awkp).patsplitfn,
+	"rshift":   (*awkp).rshiftfn,
+	"strftime": (*awkp).strftimefn,
+	"strtonum": (*awkp).strtonumfn,
+	"systime":  (*awkp).systimefn,
+	"xor":      (*awkp).xorfn,
+}
+
+// Variables that select how records are split into fields.
+var awkseps = stringset("FS", "FIELDWIDTHS", "FPAT")
+
+// Special input file names that read from stdin.
+var awkstdin = stringset("-", "/dev/stdin", "/dev/fd/0")
+
+const awkblanks = " \t\n\r\f\v"
+
+const awktimefmt = "%a %b %e %H:%M:%S %Z %Y" // Default strftime format.
+
+const awkregexps = 128 // Maximum number of cached regular expressions.
+
+func newawkp(cmd *Cmd) *awkp {
+	p := &awkp{
+		cmd:     cmd,
+		stdout:  bufio.NewWriter(cmd.Stdout),
+		linebuf: isterminal(cmd.Stdout),
+		symbols: make(map[string]*awkcell),
+		readers: make(map[string]runeScanCloser),
+		writers: make(map[string]io.WriteCloser),
+		regexps: make(map[string]*regexp.Regexp),
+	}
+	p.seed = 1
+	p.sym("CONVFMT").SetString("%.6g")
+	p.sym("FS").SetString(" ")
+	p.sym("OFMT").SetString("%.6g")
+	p.sym("OFS").SetString(" ")
+	p.sym("ORS").SetString("\n")
+	p.sym("RS").SetString("\n")
+	p.sym("SUBSEP").SetString("\034")
+	p.sym("NF").assignhook = func() error {
+		nf := int(p.sym("NF").Num())
+		if nf >= len(p.fields) {
+			p.SetField(nf, p.Field(nf))
+		} else if nf < len(p.fields) {
+			p.fields = p.fields[:nf+1]
+		}
+		return p.ftor()
+	}
+	for name := range awkseps {
+		name := name
+		p.sym(name).assignhook = func() error { p.usesep(name); return nil }
+	}
+	for _, kv := range p.cmd.Environ() {
+		k, v, _ := strings.Cut(kv, "=")
+		p.sym("ENVIRON").Key(k).SetStrnum(v)
+	}
+	return p
 }
 
-type awkitem struct {
-	token *token
-	in    bool
+// extend sets the variables of the extensions enabled in p.
+func (p *awkp) extend() {
+	if p.gawk {
+		p.sym("FPAT").SetString("[^[:space:]]+")
+	}
+	if p.bignum {
+		p.sym("PREC").SetNum(53)
+		p.sym("ROUNDMODE").SetString("N")
+	}
 }
 
-type awkeval func(bool, strset) (*awkcell, error)
-type awkbuiltin func([]*awkcell) (*awkcell, error)
+// usesep splits records by the variable called name from now on, unless it
+// belongs to a disabled extension.
+func (p *awkp) usesep(name string) {
+	if name == "FS" || p.gawk {
+		p.sep = name
+	}
+}
 
-func newawkp(cmd *Cmd) *awkp {
-	p := &awkp{
-		cmd:          cmd,
-		symbols:      make(map[string]*awkcell),
-		erefn:        stringset("gsub", "match", "split", "sub"),
-		stopstmt:     stringset(";", "\n"),
-		stopexpr:     stringset("}", ";", ",", "\n", ")"),
-		stopexprlist: stringset("{", "}", ";", "\n", ")"),
-		stopprint:    stringset("}", ";", ",", "\n", ">", ">>", "|"),
-		endstmt:      stringset("", "{", "}", "\n", ";", "(", ")"),
-		readers:      make(map[string]runeScanCloser),
-		writers:      make(map[string]io.WriteCloser),
-	}
-	p.builtins = map[string]awkbuiltin{
-		"atan2":   p.atan2fn,
-		"close":   p.closefn,
-		"cos":     p.cosfn,
-		"exp":     p.expfn,
-		"gsub":    p.gsubfn,
-		"int":     p.intfn,
-		"length":  p.lengthfn,
-		"index":   p.indexfn,
-		"log":     p.logfn,
-		"match":   p.matchfn,
-		"rand":    p.randfn,
-		"sin":     p.sinfn,
-		"split":   p.splitfn,
-		"sprintf": p.sprintffn,
-		"sqrt":    p.sqrtfn,
-		"srand":   p.srandfn,
-		"sub":     p.subfn,
-		"substr":  p.substrfn,
-		"tolower": p.tolowerfn,
-		"toupper": p.toupperfn,
-	}
-	p.stmts = map[string]awkeval{
-		"break":    p.jumpstmt,
-		"continue": p.jumpstmt,
-		"do":       p.dostmt,
-		"delete":   p.deletestmt,
-		"exit":     p.exitstmt,
-		"for":      p.forstmt,
-		"if":       p.ifstmt,
-		"next":     p.jumpstmt,
-		"nextfile": p.jumpstmt,
-		"print":    p.printstmt,
-		"printf":   p.printfstmt,
-		"return":   p.returnstmt,
-		"while":    p.whilestmt,
-	}
-	exprFns := []func(awkeval, bool, strset) (val *awkcell, err error){
-		p.assign, p.cond, p.or, p.and, p.inarray, p.ere, p.cmp, p.concat, p.add,
-		p.multiply, p.unary, p.exp, p.prefixop, p.postfixop, p.fieldref, p.group, p.val,
-	}
-	p.exprs = make([]awkeval, len(exprFns)+1)
-	for i := len(exprFns) - 1; i >= 0; i-- {
-		p.exprs[i] = func(i int) awkeval {
-			return func(exec bool, stop strset) (*awkcell, error) {
-				return exprFns[i](p.exprs[i+1], exec, stop)
+// location returns the time zone named by TZ, or the local time zone.
+func (p *awkp) location() *time.Location {
+	for _, kv := range p.cmd.Environ() {
+		if tz, ok := strings.CutPrefix(kv, "TZ="); ok {
+			loc, err := time.LoadLocation(tz)
+			if err != nil {
+				return time.UTC
 			}
-		}(i)
+			return loc
+		}
 	}
+	return time.Local
+}
+
+func newawklexer() *lexer {
 	// '/' is ambiguous (division vs. start of regex); lex it based on the previous token.
 	ere := fnPat("ere", func(l *lexer) *token {
 		switch l.tpeek(0).kind {
@@ -228,198 +293,106 @@ func newawkp(cmd *Cmd) *awkp {
 		stPat("*"), stPat(`/`), stPat("%"), stPat("^"), stPat("**"), stPat("!"),
 		stPat(">"), stPat("<"), stPat("|"), stPat("?"), stPat(":"), stPat("~"), stPat("$"),
 		stPat("="), stPat("builtin_func", "atan2", "cos", "sin", "exp", "log", "sqrt",
-			"int", "rand", "srand", "gsub", "index", "length", "match", "split",
+			"int", "rand", "srand", "fflush", "gsub", "index", "length", "match", "split",
 			"sprintf", "sub", "substr", "tolower", "toupper", "close", "system"),
 		rePat("func_name", regexp.MustCompile(`(^[a-zA-Z_][a-zA-Z0-9_]*)\(`)),
 		rePat("name", regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*")),
 		rePat("number", regexp.MustCompile(`^[0-9]*(?:\.[0-9]+)?(?:[Ee]-?[0-9]+)?`)),
 	}
-	p.lexer = &lexer{
+	return &lexer{
 		patterns: patterns,
 		comment:  regexp.MustCompile(`^(?m)#.*$`),
 	}
-	p.sym("CONVFMT").SetString("%.6g")
-	p.sym("FS").SetString(" ")
-	p.sym("OFMT").SetString("%.6g")
-	p.sym("OFS").SetString(" ")
-	p.sym("ORS").SetString("\n")
-	p.sym("RS").SetString("\n")
-	p.sym("SUBSEP").SetString("\034")
-	p.sym("NF").assignhook = func() error {
-		nf := int(p.sym("NF").Num())
-		if nf >= len(p.fields) {
-			p.SetField(nf, p.Field(nf))
-		} else if nf < len(p.fields) {
-			p.fields = p.fields[:nf+1]
-		}
-		return p.ftor()
-	}
-	for _, kv := range p.cmd.Environ() {
-		k, v, _ := strings.Cut(kv, "=")
-		p.sym("ENVIRON").Key(k).SetString(v)
-	}
-	return p
-}
-
-func (p *awkp) findblocks() error {
-	for depth := 0; ; depth = 0 {
-		switch p.next().kind {
-		case "\n":
-			continue
-		case "{": // An item with no matching expression.
-			p.items = append(p.items, &awkitem{p.peek(-1), false})
-			depth++
-		case "begin", "end":
-			if err := p.mustmatch("{"); err != nil {
-				return err
-			}
-			if p.peek(-2).kind == "end" {
-				p.ends = append(p.ends, p.peek(0))
-			} else {
-				p.begins = append(p.begins, p.peek(0))
-			}
-			depth++
-		case "function":
-			funcname := p.peek(0)
-			if funcname.kind != "func_name" && funcname.kind != "name" {
-				return p.lexer.newTokenErrorf(funcname, "bad function name")
-			}
-			p.next()
-			params, err := p.toklistp()
-			if err != nil {
-				return err
-			}
-			if err := p.mustmatch("{"); err != nil {
-				return err
-			}
-			p.sym(funcname.name).SetFn(&awkfn{params, p.next()})
-			depth++
-		case "":
-			return nil
-		default:
-			p.items = append(p.items, &awkitem{p.peek(-1), false})
-		}
-		if ok := p.skipblock(depth); !ok {
-			return p.lexer.newTokenError(p.peek(0))
-		}
-	}
 }
 
-func (p *awkp) skipblock(depth int) bool {
-	for {
-		switch p.next().kind {
-		case "{":
-			depth++
-		case "}":
-			depth--
-			if depth <= 0 {
-				return depth == 0
-			}
-		case "":
-			return depth == 0
-		case "\n": // An item with no block.
-			if depth == 0 {
-				return true
-			}
+// load prepares p to run prog.
+func (p *awkp) load(prog *awkprog) error {
+	p.externs = make(map[string]awkbuiltin)
+	for _, c := range prog.extern {
+		fn := p.gofuncs[c.token.name]
+		if fn == nil && p.gawk {
+			fn = awkgawkfuncs[c.token.name]
 		}
-	}
-}
-
-func (p *awkp) toklistp() (vals []*token, err error) {
-	if err = p.mustmatch("("); err != nil {
-		return
-	}
-	for {
-		switch p.next().kind {
-		case "name":
-			if p.hastoken(vals, p.peek(-1)) {
-				return nil, p.lexer.newTokenErrorf(p.peek(-1), "bad parameter")
-			}
-			vals = append(vals, p.peek(-1))
-			if !p.match(",") && p.peek(0).name != ")" {
-				err = p.lexer.newTokenError(p.peek(0))
-				return
-			}
-		case ")":
-			return
-		default:
-			return nil, p.lexer.newTokenError(p.peek(-1))
+		if fn == nil {
+			return prog.lexer.newTokenErrorf(c.token, "bad function: %s", c.token.name)
 		}
+		p.externs[c.token.name] = fn
 	}
-}
-
-func (p *awkp) hastoken(toklist []*token, tok *token) bool {
-	for _, t := range toklist {
-		if t.name == tok.name {
-			return true
-		}
+	p.awkprog = prog
+	p.globals = make([]*awkcell, len(prog.globals))
+	for i, name := range prog.globals {
+		p.globals[i] = p.sym(name)
 	}
-	return false
+	p.ranges = make([]bool, len(prog.items))
+	return nil
 }
 
 func (p *awkp) exec() (code int, err error) {
-	var val *awkcell
 	defer func() {
 		if r := recover(); r != nil {
-			prettyPrintError(p.cmd.Stderr, p.lexer.newTokenErrorf(p.peek(0), "panic"))
+			if r != errBrokenPipe && p.tok != nil {
+				prettyPrintError(p.cmd.Stderr, p.lexer.newTokenErrorf(p.tok, "panic"))
+			}
 			panic(r)
 		}
 	}()
 	defer func() {
 		var terr *tokenError
 		if errors.As(err, &terr) && terr.isJump("exit") {
-			code = int(val.Num())
+			err = nil
 		} else if err != nil {
 			code = 1
+			p.closeall()
 			return
 		}
-		code, err = p.exit(code)
+		code, err = p.exit()
 	}()
 	for _, begin := range p.begins {
-		p.pos = begin.pos
-		if val, err = p.evalblock(true); err != nil {
+		if err = p.execblock(begin); err != nil {
 			return
 		}
 	}
 	if len(p.items) > 0 || len(p.ends) > 0 {
-		val, err = p.recordloop()
+		err = p.recordloop()
 	}
 	return
 }
 
-func (p *awkp) recordloop() (val *awkcell, err error) {
+func (p *awkp) recordloop() (err error) {
+	var val *awkcell
 	for {
+		if err = p.cmd.ctx.Err(); err != nil {
+			return
+		}
 		val, err = p.getline(nil, p.Field(0))
 		if val.Num() == 0 && p.argvoffset >= int(p.sym("ARGC").Num())-1 {
-			break // EOF and no more files to process.
+			return nil // EOF and no more files to process.
 		} else if val.Num() == 0 {
 			continue // EOF but still more files to process.
 		} else if err != nil {
 			return
 		}
-		if val, err = p.itemloop(); err != nil {
+		if err = p.itemloop(); err != nil {
 			return
 		}
 	}
-	return
 }
 
-func (p *awkp) itemloop() (val *awkcell, err error) {
+func (p *awkp) itemloop() (err error) {
 	var skip bool
 	for _, item := range p.items {
-		p.pos = item.token.pos
 		if skip, err = p.itemskip(item); err != nil {
 			return
 		} else if skip {
 			continue
 		}
-		val, err = p.itemblock()
+		err = p.itemblock(item)
 		var terr *tokenError
 		switch {
 		case err == nil:
 			break
 		case errors.As(err, &terr) && terr.isJump("next"):
-			return nil, nil
+			return nil
 		case errors.As(err, &terr) && terr.isJump("nextfile"):
 			if err = p.nextreader(); err != nil {
 				return
@@ -432,41 +405,44 @@ func (p *awkp) itemloop() (val *awkcell, err error) {
 }
 
 func (p *awkp) itemskip(i *awkitem) (skip bool, err error) {
-	var vals []*awkcell
-	if vals, err = p.exprlist(true, p.stopexprlist); err != nil {
-		return
-	}
-	switch len(vals) {
+	var val *awkcell
+	switch len(i.pattern) {
 	case 0:
 		// Implicit match.
 	case 1:
-		if !vals[0].Bool() {
-			skip = true
+		if val, err = p.eval(i.pattern[0]); err != nil {
+			return
 		}
+		skip = !val.Bool()
 	case 2:
-		if !i.in && vals[0].Bool() {
-			i.in = true
+		if !p.ranges[i.index] {
+			if val, err = p.eval(i.pattern[0]); err != nil {
+				return
+			}
+			p.ranges[i.index] = val.Bool()
 		}
-		if i.in && vals[1].Bool() {
-			i.in = false
-		} else if !i.in {
-			skip = true
+		if !p.ranges[i.index] {
+			return true, nil
 		}
-	default:
-		err = p.lexer.newTokenErrorf(i.token, "bad exprlist: want 0-2, got %d", len(vals))
+		if val, err = p.eval(i.pattern[1]); err != nil {
+			return
+		}
+		p.ranges[i.index] = !val.Bool()
 	}
 	return
 }
 
-func (p *awkp) itemblock() (val *awkcell, err error) {
-	if p.match("{") {
-		val, err = p.evalblock(true)
-	} else {
-		// Implicit "{ print }".
-		fmt.Fprint(p.cmd.Stdout, p.Field(0).String())
-		fmt.Fprint(p.cmd.Stdout, p.sym("ORS").String())
+func (p *awkp) itemblock(i *awkitem) error {
+	if i.body != nil {
+		return p.execblock(i.body)
 	}
-	return
+	// Implicit "{ print }".
+	_, _ = p.stdout.WriteString(p.Field(0).String())
+	_, _ = p.stdout.WriteString(p.sym("ORS").String())
+	if p.linebuf {
+		_ = p.stdout.Flush()
+	}
+	return nil
 }
 
 func (p *awkp) getline(reader io.RuneScanner, set *awkcell) (val *awkcell, err error) {
@@ -489,7 +465,7 @@ func (p *awkp) getline(reader io.RuneScanner, set *awkcell) (val *awkcell, err e
 	} else if err != nil {
 		return
 	}
-	if err = set.AssignString(record); err != nil {
+	if err = set.AssignStrnum(record); err != nil {
 		return
 	}
 	eof = len(record) == 0 && eof
@@ -527,21 +503,17 @@ func (p *awkp) nextreader() (err error) {
 			return nil
 		}
 		if name, val, ok := strings.Cut(arg, "="); ok {
-			if val, err = p.unescape(val); err != nil {
-				return err
-			}
-			p.sym(name).SetString(val)
+			p.setvar(name, awkunescape(val))
 			continue
 		}
-		if arg == "-" {
-			p.filereader = bufio.NewReader(p.cmd.Stdin)
+		if awkstdin[arg] {
+			p.filereader = p.stdinreader()
 		} else {
-			// TODO: replace with hive.FS
-			file, err := os.Open(arg)
+			file, err := p.cmd.FS.Open(arg)
 			if err != nil {
 				return fmt.Errorf("bad file '%s': %s", arg, err)
 			}
-			p.filereader = bufio.NewReader(file)
+			p.filereader = bufio.NewReader(&ctxFileReader{ctx: p.cmd.ctx, r: file})
 			p.readfile = true
 		}
 		p.sym("FILENAME").SetString(arg)
@@ -561,177 +533,337 @@ func (p *awkp) skiptorecord(reader io.RuneScanner) error {
 }
 
 func (p *awkp) readrecord(reader io.RuneScanner) (string, error) {
+	rs := []rune(p.sym("RS").String())
+	if p.csv {
+		return p.readcsv(reader)
+	} else if len(rs) == 0 {
+		record, err := p.readregex(reader, "\n\n+")
+		if err == io.EOF {
+			trimmed := strings.TrimRight(record, "\n")
+			p.sym("RT").SetString(record[len(trimmed):])
+			record = trimmed
+		}
+		return record, err
+	} else if len(rs) > 1 {
+		return p.readregex(reader, string(rs))
+	}
 	var record strings.Builder
-	var r, pr, sr rune
-	var err error
-	if p.sym("RS").String() != "" {
-		sr = []rune(p.sym("RS").String())[0]
+	for {
+		r, _, err := reader.ReadRune()
+		if err != nil {
+			p.sym("RT").SetString("")
+			return record.String(), err
+		} else if r == rs[0] {
+			p.sym("RT").SetString(string(r))
+			return record.String(), nil
+		}
+		record.WriteRune(r)
 	}
-	for ; ; pr = r {
-		r, _, err = reader.ReadRune()
+}
+
+// readcsv reads a record ending in a newline that is not within quotes.
+// A carriage return before the newline is discarded.
+func (p *awkp) readcsv(reader io.RuneScanner) (string, error) {
+	var record strings.Builder
+	var quoted, start, closed = false, true, false
+	for {
+		r, _, err := reader.ReadRune()
 		if err != nil {
+			p.sym("RT").SetString("")
 			return record.String(), err
+		} else if r == '\n' && !quoted {
+			p.sym("RT").SetString("\n")
+			return strings.TrimSuffix(record.String(), "\r"), nil
 		}
-		if sr == 0 {
-			if pr == '\n' && r != '\n' {
-				record.WriteRune(pr)
-			} else if pr == '\n' && r == '\n' {
-				return record.String(), nil
-			} else if r == '\n' {
-				continue
+		record.WriteRune(r)
+		switch {
+		case r == '"' && (quoted || start || closed):
+			// A quote opens or closes a quoted field, or is doubled within one.
+			quoted, start, closed = !quoted, false, quoted
+		case r == ',' && !quoted:
+			start, closed = true, false
+		default:
+			start, closed = false, false
+		}
+	}
+}
+
+// awkpeeker is implemented by the buffered readers records are read from.
+type awkpeeker interface {
+	Buffered() int
+	Discard(int) (int, error)
+	Peek(int) ([]byte, error)
+}
+
+// readregex reads a record terminated by a match of the regex rs.
+// Input is consumed only up to the end of the match.
+func (p *awkp) readregex(reader io.RuneScanner, rs string) (string, error) {
+	re, err := p.regex(rs)
+	if err != nil {
+		return "", fmt.Errorf("bad RS regex: %w", err)
+	}
+	br, ok := reader.(awkpeeker)
+	if !ok {
+		return "", fmt.Errorf("bad reader: %T", reader)
+	}
+	// A literal separator cannot grow, so a match at the end of the
+	// available input need not wait for more.
+	_, literal := re.LiteralPrefix()
+	var text strings.Builder
+	start := 0 // No match can begin before start.
+	for {
+		_, err = br.Peek(1) // Wait for input.
+		buf, _ := br.Peek(br.Buffered())
+		consumed := text.Len()
+		text.Write(buf)
+		s := text.String()
+		loc := re.FindStringIndex(s[start:])
+		if loc != nil && loc[0] == loc[1] {
+			loc = nil // Skip empty matches.
+			for _, l := range re.FindAllStringIndex(s[start:], -1) {
+				if l[1] > l[0] {
+					loc = l
+					break
+				}
 			}
-		} else if sr == r {
-			return record.String(), nil
 		}
-		record.WriteRune(r)
+		if loc != nil {
+			loc[0], loc[1] = loc[0]+start, loc[1]+start
+		}
+		if loc != nil && (loc[1] < len(s) || literal || err != nil) {
+			_, _ = br.Discard(max(0, loc[1]-consumed))
+			p.sym("RT").SetString(s[loc[0]:loc[1]])
+			return s[:loc[0]], nil
+		}
+		_, _ = br.Discard(len(buf))
+		if err != nil {
+			p.sym("RT").SetString("")
+			return s, err
+		}
+		if pre := p.rsprefixes(re); pre != nil {
+			start += pre.FindStringIndex(s[start:])[0]
+		}
 	}
 }
 
-func (p *awkp) exit(c int) (code int, err error) {
-	code = c
-	var val *awkcell
-loop:
+// rsprefixes returns awkprefixes of re, caching the result for the next
+// record.
+func (p *awkp) rsprefixes(re *regexp.Regexp) *regexp.Regexp {
+	if p.rsre != re {
+		p.rsre = re
+		p.rspre, _ = awkprefixes(re)
+	}
+	return p.rspre
+}
+
+func (p *awkp) exit() (code int, err error) {
 	for _, end := range p.ends {
-		p.pos = end.pos
-		val, err = p.evalblock(true)
+		err = p.execblock(end)
 		var terr *tokenError
-		switch {
-		case err == nil:
-			break
-		case errors.As(err, &terr) && terr.isJump("exit"):
-			code = int(val.Num())
+		if errors.As(err, &terr) && terr.isJump("exit") {
 			err = nil
-			break loop
-		default:
-			return
+			break
+		} else if err != nil {
+			break
+		}
+	}
+	p.closeall()
+	return p.exitcode, err
+}
+
+// flush writes any buffered output.
+func (p *awkp) flush() {
+	_ = p.stdout.Flush()
+	for _, w := range p.writers {
+		if bw, ok := w.(*bufferedWriteCloser); ok {
+			_ = bw.Flush()
 		}
 	}
+}
+
+func (p *awkp) closeall() {
+	p.flush()
 	for _, w := range p.writers {
 		_ = w.Close()
 	}
-	return
+	for _, r := range p.readers {
+		_ = r.Close()
+	}
 }
 
-func (p *awkp) evalblock(exec bool) (val *awkcell, err error) {
-	for {
-		if p.match("}") {
-			return
-		} else if p.matchnewlines() {
-			continue
-		} else if val, err = p.evalstmt(exec, p.stopstmt); err != nil {
+func (p *awkp) execblock(b *awkblock) (err error) {
+	for _, s := range b.stmts {
+		if err = p.execstmt(s); err != nil {
 			return
 		}
 	}
+	return
 }
 
-func (p *awkp) evalstmt(exec bool, stop strset) (val *awkcell, err error) {
-	for p.match("\n") {
-	}
-	if p.match("") {
-		err = p.lexer.newTokenError(p.peek(-1))
-	} else if p.match("{") {
-		val, err = p.evalblock(exec)
-	} else if _, ok := p.stmts[p.peek(0).kind]; ok {
-		val, err = p.stmts[p.next().kind](exec, stop)
-	} else if p.stopstmt[p.peek(0).kind] {
+func (p *awkp) execstmt(n awknode) (err error) {
+	var val *awkcell
+	switch n := n.(type) {
+	case nil:
 		// Empty statement.
-	} else {
-		val, err = p.expr(exec, p.stopexpr)
-	}
-	if err != nil {
-		return
-	}
-	if !p.matchstmtdelim() {
-		err = p.lexer.newTokenError(p.peek(0))
+	case *awkblock:
+		return p.execblock(n)
+	case *awkprint:
+		p.tok = n.token
+		return p.print(n)
+	case *awkif:
+		if val, err = p.eval(n.cond); err != nil {
+			return
+		} else if val.Bool() {
+			return p.execstmt(n.body)
+		} else if n.els != nil {
+			return p.execstmt(n.els)
+		}
+	case *awkwhile:
+		return p.whilestmt(n)
+	case *awkdo:
+		return p.dostmt(n)
+	case *awkfor:
+		return p.forstmt(n)
+	case *awkforin:
+		p.tok = n.token
+		return p.forinstmt(n)
+	case *awkdelete:
+		if n.index == nil {
+			p.cell(n.arr).Arr().reset()
+			break
+		}
+		var key string
+		if key, err = p.key(n.index); err != nil {
+			return
+		}
+		p.cell(n.arr).DelKey(key)
+	case *awkjump:
+		return n.err
+	case *awkexit:
+		if n.val != nil {
+			if val, err = p.eval(n.val); err != nil {
+				return
+			}
+			p.exitcode = int(val.Num())
+		}
+		return n.err
+	case *awkreturn:
+		p.retval = &awkcell{prog: p}
+		if n.val != nil {
+			if val, err = p.eval(n.val); err != nil {
+				return
+			}
+			p.retval = val
+		}
+		return n.err
+	default:
+		_, err = p.eval(n)
 	}
 	return
 }
 
-func (p *awkp) jumpstmt(exec bool, _ strset) (val *awkcell, err error) {
-	if !exec {
-		return
+// loopjump reports whether a loop body's error ends the loop.
+func (p *awkp) loopjump(err error) (done bool, _ error) {
+	var terr *tokenError
+	switch {
+	case err == nil:
+		return false, p.cmd.ctx.Err()
+	case errors.As(err, &terr) && terr.isJump("break"):
+		return true, nil
+	case errors.As(err, &terr) && terr.isJump("continue"):
+		return false, p.cmd.ctx.Err()
+	default:
+		return true, err
 	}
-	return nil, p.lexer.newJumpError(p.peek(-1))
 }
 
-func (p *awkp) deletestmt(exec bool, _ strset) (val *awkcell, err error) {
-	if err = p.mustmatch("name"); err != nil {
-		return
-	}
-	arr := p.peek(-1).name
-	if err = p.mustmatch("["); err != nil {
-		return
-	}
-	var vals []*awkcell
-	vals, err = p.exprlist(exec, p.stopexpr)
-	if err != nil {
-		return
-	}
-	if err = p.mustmatch("]"); err != nil {
-		return
+func (p *awkp) whilestmt(n *awkwhile) error {
+	for {
+		val, err := p.eval(n.cond)
+		if err != nil || !val.Bool() {
+			return err
+		}
+		if done, err := p.loopjump(p.execstmt(n.body)); done || err != nil {
+			return err
+		}
 	}
-	if !exec {
-		return
+}
+
+func (p *awkp) dostmt(n *awkdo) error {
+	for {
+		if done, err := p.loopjump(p.execstmt(n.body)); done || err != nil {
+			return err
+		}
+		val, err := p.eval(n.cond)
+		if err != nil || !val.Bool() {
+			return err
+		}
 	}
-	p.sym(arr).DelKey(p.join(vals, p.sym("SUBSEP").String()))
-	return
 }
 
-func (p *awkp) dostmt(exec bool, stop strset) (val *awkcell, err error) {
-	start := p.pos
-	var whileval *awkcell
+func (p *awkp) forstmt(n *awkfor) (err error) {
+	if err = p.execstmt(n.init); err != nil {
+		return
+	}
 	for {
-		p.pos = start
-		val, err = p.evalstmt(exec, stop)
-		var terr *tokenError
-		switch {
-		case err == nil:
-			break
-		case errors.As(err, &terr) && terr.isJump("break"):
-			err = nil
-			exec = false
-			continue
-		case errors.As(err, &terr) && terr.isJump("continue"):
-			err = nil
-			continue
-		default:
-			return
+		if n.cond != nil {
+			val, err := p.eval(n.cond)
+			if err != nil || !val.Bool() {
+				return err
+			}
 		}
-		if err = p.mustmatch("while"); err != nil {
-			return
+		if done, err := p.loopjump(p.execstmt(n.body)); done || err != nil {
+			return err
 		}
-		if whileval, err = p.exprp(exec, p.stopexpr); err != nil {
+		if err = p.execstmt(n.post); err != nil {
 			return
 		}
-		if !exec || !whileval.Bool() {
-			break
-		}
 	}
-	return
 }
 
-func (p *awkp) exitstmt(exec bool, stop strset) (val *awkcell, err error) {
-	if exec {
-		err = p.lexer.newJumpError(p.peek(-1))
+// forinstmt loops over a snapshot of the array's elements, as onetrue awk does,
+// so the body may add and delete elements.
+func (p *awkp) forinstmt(n *awkforin) error {
+	name, arr := p.cell(n.name), p.cell(n.arr).Arr()
+	cells := arr.cells()
+	var order *awkcell
+	if p.gawk {
+		order = p.sym("PROCINFO").Arr().get("sorted_in")
 	}
-	val, _ = p.expr(exec, stop)
-	if exec && val == nil {
-		val = p.num(0)
+	if order != nil && order.String() != "" && order.String() != "@unsorted" {
+		var err error
+		cells, err = p.sorted(arr, order.String())
+		if terr := (*tokenError)(nil); errors.As(err, &terr) {
+			return err
+		} else if err != nil {
+			return p.lexer.newTokenErrorf(n.token, "%s", err)
+		}
 	}
-	return
+	for _, c := range cells {
+		name.SetString(c.name)
+		if done, err := p.loopjump(p.execstmt(n.body)); done || err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (p *awkp) printstmt(exec bool, _ strset) (val *awkcell, err error) {
+func (p *awkp) print(n *awkprint) (err error) {
 	var args []*awkcell
-	args, err = p.exprlistoptp(exec, p.stopprint)
-	if err != nil {
+	if args, err = p.evallist(n.args); err != nil {
 		return
 	}
 	if len(args) == 0 {
 		args = []*awkcell{p.Field(0)}
 	}
 	var s strings.Builder
-	if exec {
+	if n.token.kind == "printf" {
+		var fmtd string
+		if fmtd, err = p.sprintf(args[0].String(), args[1:]); err != nil {
+			return p.lexer.newTokenErrorf(n.token, "%s", err)
+		}
+		s.WriteString(fmtd)
+	} else {
 		for i, v := range args {
 			if i > 0 {
 				s.WriteString(p.sym("OFS").String())
@@ -740,966 +872,563 @@ func (p *awkp) printstmt(exec bool, _ strset) (val *awkcell, err error) {
 		}
 		s.WriteString(p.sym("ORS").String())
 	}
-	if err = p.print(exec, s.String()); err != nil {
+	if n.redir == nil {
+		_, _ = p.stdout.WriteString(s.String())
+		if p.linebuf {
+			_ = p.stdout.Flush()
+		}
 		return
 	}
-	return
-}
-
-func (p *awkp) printfstmt(exec bool, _ strset) (val *awkcell, err error) {
-	var args []*awkcell
-	args, err = p.exprlistoptp(exec, p.stopprint)
-	if err != nil {
+	var w io.Writer
+	if w, err = p.writer(n.redir, n.dest); err != nil {
 		return
 	}
-	if exec && len(args) == 0 {
-		args = []*awkcell{p.Field(0)}
-	}
-	var fmtd string
-	if exec {
-		fmtd, err = p.sprintf(args[0].String(), args[1:])
-		if err != nil {
-			return
-		}
-	}
-	if err = p.print(exec, fmtd); err != nil {
-		return
-	}
-	return
-}
-
-func (p *awkp) print(exec bool, s string) (err error) {
-	var w io.Writer = p.cmd.Stdout
-	if p.matchany(">", ">>", "|") {
-		tok := p.peek(-1)
-		op := tok.kind
-		var val *awkcell
-		if val, err = p.expr(exec, p.stopexpr); err != nil || !exec {
-			return
-		}
-		w = p.writers[val.String()]
-		if w == nil {
-			if op == ">" || op == ">>" {
-				var mode int
-				if op == ">" {
-					mode = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
-				} else if op == ">>" {
-					mode = os.O_WRONLY | os.O_CREATE | os.O_APPEND
-				}
-				// TODO: replace with hive.FS
-				if w, err = os.OpenFile(val.String(), mode, 0644); err != nil {
-					return p.lexer.newTokenErrorf(tok, "bad file '%s': %s",
-						val.String(), err)
-				}
-			} else {
-				cmd := p.cmd.spawn("sh", "-c", val.String())
-				if w, err = cmd.StdinCloser(); err != nil {
-					return p.lexer.newTokenErrorf(tok, "bad command '%s': %s",
-						val.String(), err)
-				}
-				cmd.Start()
-			}
-			p.writers[val.String()] = w.(io.WriteCloser)
-		}
-	}
-	if !exec {
-		return
+	_, _ = io.WriteString(w, s.String())
+	if w == p.stdout && p.linebuf {
+		_ = p.stdout.Flush()
 	}
-	fmt.Fprint(w, s)
 	return
 }
 
-func (p *awkp) returnstmt(exec bool, stop strset) (val *awkcell, err error) {
-	if exec {
-		err = p.lexer.newJumpError(p.peek(-1))
+func (p *awkp) writer(tok *token, dest awknode) (io.Writer, error) {
+	val, err := p.eval(dest)
+	if err != nil {
+		return nil, err
 	}
-	val, _ = p.expr(exec, stop)
-	return
-}
-
-func (p *awkp) forstmt(exec bool, stop strset) (*awkcell, error) {
-	if p.match("(", "name", "in", "name", ")") {
-		return p.forstmta(exec, p.sym(p.peek(-4).name), p.sym(p.peek(-2).name))
-	} else {
-		return p.forstmtc(exec, stop)
+	name := val.String()
+	if w := p.stdwriter(name); w != nil && tok.kind != "|" {
+		return w, nil
+	} else if w := p.writers[name]; w != nil {
+		return w, nil
 	}
-}
-
-func (p *awkp) forstmta(exec bool, loopval *awkcell, arrval *awkcell) (val *awkcell, err error) {
-	pos := p.pos
-loop:
-	for i := range arrval.arrval.contents {
-		for c := arrval.arrval.contents[i]; c != nil; c = c.next {
-			p.pos = pos
-			loopval.SetString(c.name)
-			val, err = p.evalstmt(exec, p.stopstmt)
-			var terr *tokenError
-			switch {
-			case !exec:
-				return
-			case err == nil:
-				break
-			case errors.As(err, &terr) && terr.isJump("break"):
-				p.pos = pos
-				break loop
-			case errors.As(err, &terr) && terr.isJump("continue"):
-				err = nil
-			default:
-				return
-			}
+	var w io.WriteCloser
+	switch tok.kind {
+	case ">":
+		w, err = p.cmd.FS.Create(name)
+	case ">>":
+		w, err = p.cmd.FS.Append(name)
+	case "|":
+		p.flush()
+		cmd := p.cmd.spawn("sh", "-c", name)
+		if w, err = cmd.StdinCloser(); err != nil {
+			return nil, p.lexer.newTokenErrorf(tok, "bad command '%s': %s", name, err)
 		}
+		cmd.Start()
 	}
-	if pos == p.pos {
-		val, err = p.evalstmt(false, p.stopstmt)
+	if err != nil {
+		return nil, p.lexer.newTokenErrorf(tok, "bad file '%s': %s", name, err)
 	}
-	return
+	bw := newBufferedWriteCloser(w)
+	p.writers[name] = bw
+	return bw, nil
 }
 
-func (p *awkp) forstmtc(exec bool, _ strset) (val *awkcell, err error) {
-	init := true
-	for pos := p.pos; ; p.pos = pos {
-		if exec, err = p.forheader(exec, init); err != nil {
-			return
-		}
-		init = false
-		val, err = p.evalstmt(exec, p.stopstmt)
-		var terr *tokenError
-		switch {
-		case !exec:
-			return
-		case err == nil:
-			break
-		case errors.As(err, &terr) && terr.isJump("break"):
-			p.pos = pos
-			if _, err = p.forheader(false, init); err != nil {
-				return
-			}
-			return p.evalstmt(false, p.stopstmt)
-		case errors.As(err, &terr) && terr.isJump("continue"):
-			err = nil
-		default:
-			return
-		}
-		if !exec {
-			return
-		}
+// stdwriter returns the stream named by a special output file name, or nil.
+func (p *awkp) stdwriter(name string) io.Writer {
+	switch name {
+	case "-", "/dev/stdout", "/dev/fd/1":
+		return p.stdout
+	case "/dev/stderr", "/dev/fd/2":
+		return p.cmd.Stderr
 	}
+	return nil
 }
 
-func (p *awkp) forheader(exec bool, init bool) (doloop bool, err error) {
-	var val *awkcell
-	if err = p.mustmatch("("); err != nil {
-		return
-	}
-	if !p.matchfordelim() {
-		if _, err = p.evalstmt(exec && init, p.stopexpr); err != nil {
-			return
+func (p *awkp) eval(n awknode) (val *awkcell, err error) {
+	switch n := n.(type) {
+	case *awknum:
+		if p.bignum {
+			return p.bignumber(n.lit), nil
 		}
-		p.matchnewlines()
-	}
-	condpos := p.pos
-	if !p.matchfordelim() {
-		if _, err = p.expr(false, p.stopexpr); err != nil {
-			return
+		return p.num(n.val), nil
+	case *awkstr:
+		return p.string(n.val), nil
+	case *awkregex:
+		if n.implicit {
+			return p.bool(n.re.MatchString(p.Field(0).String())), nil
 		}
-		if !p.matchfordelim() {
-			return false, p.lexer.newTokenError(p.peek(0))
-		}
-	}
-	if !p.match(")") {
-		if _, err = p.evalstmt(exec && !init, p.stopexpr); err != nil {
+		val = p.string(n.token.name)
+		val.regexp = true
+		return
+	case *awkvar:
+		return p.cell(n), nil
+	case *awkindex:
+		var key string
+		if key, err = p.key(n.index); err != nil {
 			return
 		}
-		p.matchnewlines()
-		if err = p.mustmatch(")"); err != nil {
+		return p.cell(n.arr).Key(key), nil
+	case *awkfield:
+		if val, err = p.eval(n.index); err != nil {
 			return
 		}
-	}
-	stmtpos := p.pos
-	p.pos = condpos
-	if p.matchfordelim() {
-		doloop = true
-	} else {
-		if val, err = p.expr(exec, p.stopexpr); err != nil {
+		return p.Field(int(val.Num())), nil
+	case *awkassign:
+		return p.assign(n)
+	case *awkcond:
+		if val, err = p.eval(n.cond); err != nil {
 			return
-		}
-		if exec {
-			doloop = val.Bool()
-		}
-		p.matchnewlines()
-	}
-	p.pos = stmtpos
-	return
-}
-
-func (p *awkp) ifstmt(exec bool, stop strset) (val *awkcell, err error) {
-	var v, ifval *awkcell
-	ifval, err = p.exprp(exec, stop)
-	if err != nil {
-		return
-	}
-	for {
-		if val, err = p.evalstmt(exec && ifval.Bool(), p.stopstmt); err != nil {
+		} else if val.Bool() {
+			return p.eval(n.t)
+		}
+		return p.eval(n.f)
+	case *awkbinary:
+		return p.binary(n)
+	case *awkmatch:
+		return p.match(n)
+	case *awkin:
+		var key string
+		if key, err = p.key(n.index); err != nil {
 			return
 		}
-		if exec && ifval.Bool() {
-			exec = false
-		}
-		if !p.match("else") {
+		if val, err = p.eval(n.arr); err != nil {
 			return
-		} else if p.match("if") {
-			if v, err = p.exprp(exec, p.stopexpr); err != nil {
-				return
-			}
-			if exec {
-				ifval = v
-			}
-		} else {
-			return p.evalstmt(exec, p.stopstmt)
 		}
-	}
-}
-
-func (p *awkp) whilestmt(exec bool, stop strset) (val *awkcell, err error) {
-	start := p.pos
-	var whileval *awkcell
-	for {
-		p.pos = start
-		if whileval, err = p.exprp(exec, p.stopexpr); err != nil {
+		return val.HasKey(key), nil
+	case *awkunary:
+		if val, err = p.eval(n.e); err != nil {
 			return
 		}
-		val, err = p.evalstmt(exec && whileval.Bool(), stop)
-		var terr *tokenError
 		switch {
-		case err == nil:
-			break
-		case errors.As(err, &terr) && terr.isJump("break"):
-			err = nil
-			exec = false
-			continue
-		case errors.As(err, &terr) && terr.isJump("continue"):
-			err = nil
-			continue
+		case n.op.kind == "-" && p.bignum:
+			return p.arith("-", p.num(0), val)
+		case n.op.kind == "-":
+			num := -val.Num()
+			if num == 0 {
+				num = 0 // No negative zero.
+			}
+			return p.num(num), nil
+		case n.op.kind == "+":
+			return p.tonum(val), nil
 		default:
+			return p.bool(!val.Bool()), nil
+		}
+	case *awkincdec:
+		return p.incdec(n)
+	case *awkcall:
+		p.tok = n.token
+		return p.call(n)
+	case *awkbuiltincall:
+		var args []*awkcell
+		if args, err = p.evallist(n.args); err != nil {
 			return
 		}
-		if !exec || !whileval.Bool() {
-			break
+		p.tok = n.token
+		if val, err = n.fn(p, args); err != nil {
+			return nil, p.lexer.newTokenErrorf(n.token, "%s", err)
 		}
-	}
-	return
-}
-
-func (p *awkp) expr(exec bool, stop strset) (val *awkcell, err error) {
-	if val, err = p.exprs[0](exec, stop); err == nil {
-		val, err = p.exprpipe(val, exec, stop)
-	}
-	return
-}
-
-func (p *awkp) exprpipe(in *awkcell, exec bool, stop strset) (val *awkcell, err error) {
-	if stop["|"] || !p.match("|") {
-		val = in
-		return
-	}
-	tok := p.peek(-1)
-	if !p.match("getline") {
-		err = p.lexer.newTokenErrorf(tok, "bad pipe")
-		return
-	}
-	r := p.readers[in.String()]
-	if exec && r == nil {
-		cmd := p.cmd.spawn("sh", "-c", in.String())
-		var rc io.ReadCloser
-		if rc, err = cmd.StdoutCloser(); err != nil {
-			err = p.lexer.newTokenErrorf(tok, "bad command '%s': %s",
-				in.String(), err)
-			return
-		}
-		cmd.Start()
-		r = newBufferedReadCloser(rc)
-		p.readers[in.String()] = r
-	}
-	set := p.Field(0)
-	if p.match("name") {
-		set = p.sym(p.peek(-1).name)
-	}
-	return p.getline(r, set)
-}
-
-func (p *awkp) exprp(exec bool, stop strset) (val *awkcell, err error) {
-	if err = p.mustmatch("("); err != nil {
-		return
-	}
-	if val, err = p.expr(exec, stop); err != nil {
 		return
+	case *awkgetline:
+		p.tok = n.token
+		return p.getlineexpr(n)
+	case *awkgrouplist:
+		return nil, p.lexer.newTokenError(n.token)
+	default:
+		return nil, fmt.Errorf("bad node: %T", n)
 	}
-	err = p.mustmatch(")")
-	return
 }
 
-func (p *awkp) exprlist(exec bool, stop strset) (vals []*awkcell, err error) {
-	var val *awkcell
-	for !stop[p.peek(0).kind] {
-		if val, err = p.expr(exec, stop); err != nil {
-			return
-		}
-		if val != nil {
-			vals = append(vals, val)
-		}
-		if !p.match(",") {
-			return
+func (p *awkp) evallist(list []awknode) (vals []*awkcell, err error) {
+	vals = make([]*awkcell, len(list))
+	for i, n := range list {
+		if vals[i], err = p.eval(n); err != nil {
+			return nil, err
 		}
-		p.matchnewlines()
 	}
 	return
 }
 
-func (p *awkp) exprlistp(exec bool, stop strset) (vals []*awkcell, err error) {
-	if err = p.mustmatch("("); err != nil {
-		return
+func (p *awkp) key(index []awknode) (string, error) {
+	if len(index) == 1 {
+		val, err := p.eval(index[0])
+		if err != nil {
+			return "", err
+		}
+		return val.String(), nil
 	}
-	if vals, err = p.exprlist(exec, stop); err != nil {
-		return
+	vals, err := p.evallist(index)
+	if err != nil {
+		return "", err
 	}
-	return vals, p.mustmatch(")")
+	return p.join(vals, p.sym("SUBSEP").String()), nil
 }
 
-func (p *awkp) exprlistoptp(exec bool, stop strset) (vals []*awkcell, err error) {
-	pos := p.pos
-	_, err = p.exprlist(false, stop)
-	p.pos = pos
-	if err == nil {
-		return p.exprlist(exec, stop)
-	} else {
-		return p.exprlistp(exec, p.stopstmt)
+func (p *awkp) cell(v *awkvar) *awkcell {
+	if v.local < 0 {
+		return p.globals[v.global]
 	}
+	return p.frames[len(p.frames)-1].locals[v.local]
 }
 
-func (p *awkp) assign(next awkeval, exec bool, stop strset) (val *awkcell, err error) {
-	val, err = next(exec, stop)
-	if err != nil {
-		return
-	}
-	if !p.matchany("=", "-=", "+=", "*=", "/=", "%=", "^=", "**=") {
-		return
-	}
-	if exec && !val.assignable {
-		err = p.lexer.newTokenErrorf(p.peek(-1), "bad variable")
-		return
-	}
-	op := p.peek(-1)
+func (p *awkp) assign(n *awkassign) (val *awkcell, err error) {
 	var rval *awkcell
-	rval, err = p.assign(next, exec, stop) // Right associative.
-	if err != nil || !exec {
-		return
-	}
-	switch op.kind {
-	case "=":
-		val.Set(rval)
-	case "-=":
-		val.SetNum(val.Num() - rval.Num())
-	case "+=":
-		val.SetNum(val.Num() + rval.Num())
-	case "*=":
-		val.SetNum(val.Num() * rval.Num())
-	case "/=":
-		if rval.Num() == 0 {
-			err = p.lexer.newTokenErrorf(op, "bad divisor: 0")
-			return
-		}
-		val.SetNum(val.Num() / rval.Num())
-	case "%=":
-		val.SetNum(math.Mod(val.Num(), rval.Num()))
-	case "^=", "**=":
-		val.SetNum(math.Pow(val.Num(), rval.Num()))
-	}
-	return val, val.AssignHook()
-}
-
-func (p *awkp) cond(next awkeval, exec bool, stop strset) (val *awkcell, err error) {
-	val, err = next(exec, stop)
-	if err != nil {
-		return
-	}
-	if !p.match("?") {
-		return
-	}
-	var tval, fval *awkcell
-	if tval, err = p.expr(exec && val.Bool(), stop); err != nil {
+	if val, err = p.eval(n.lhs); err != nil {
 		return
 	}
-	if err = p.mustmatch(":"); err != nil {
+	if rval, err = p.eval(n.rhs); err != nil {
 		return
 	}
-	if fval, err = p.expr(exec && !val.Bool(), stop); err != nil || !exec {
-		return
+	if n.op.kind == "=" {
+		val.Set(rval)
+		return val, val.AssignHook()
 	}
-	if val.Bool() {
-		return tval, nil
-	} else {
-		return fval, nil
+	if rval, err = p.arith(strings.TrimSuffix(n.op.kind, "="), val, rval); err != nil {
+		return nil, p.lexer.newTokenErrorf(n.op, "%s", err)
 	}
+	val.SetNumber(rval)
+	return val, val.AssignHook()
 }
 
-func (p *awkp) or(next awkeval, exec bool, stop strset) (val *awkcell, err error) {
-	val, err = next(exec, stop)
-	if err != nil {
-		return
-	}
+func (p *awkp) binary(n *awkbinary) (val *awkcell, err error) {
 	var rval *awkcell
-	for p.match("||") {
-		if exec && val.Bool() {
-			exec = false // Short circuit.
-		}
-		rval, err = next(exec, stop) // Left associative.
-		if err != nil {
-			return
-		}
-		if !exec {
-			continue
-		}
-		val = p.bool(val.Bool() || rval.Bool())
-	}
-	return
-}
-
-func (p *awkp) and(next awkeval, exec bool, stop strset) (val *awkcell, err error) {
-	val, err = next(exec, stop)
-	if err != nil {
+	if val, err = p.eval(n.l); err != nil {
 		return
 	}
-	var rval *awkcell
-	for p.match("&&") {
-		if exec && !val.Bool() {
-			exec = false // Short circuit.
+	switch n.op.kind {
+	case "||":
+		if val.Bool() {
+			return p.bool(true), nil // Short circuit.
 		}
-		rval, err = next(exec, stop) // Left associative.
-		if err != nil {
+		if rval, err = p.eval(n.r); err != nil {
 			return
 		}
-		if !exec {
-			continue
+		return p.bool(rval.Bool()), nil
+	case "&&":
+		if !val.Bool() {
+			return p.bool(false), nil // Short circuit.
 		}
-		val = p.bool(rval.Bool())
-	}
-	return
-}
-
-func (p *awkp) inarray(next awkeval, exec bool, stop strset) (val *awkcell, err error) {
-	var arr *awkcell
-	var idx string
-	pos := p.pos
-	if p.match("(", "exprlist", ")", "in") {
-		var vals []*awkcell
-		p.pos = pos
-		if vals, err = p.exprlistp(exec, p.stopexprlist); err != nil {
+		if rval, err = p.eval(n.r); err != nil {
 			return
 		}
-		if exec {
-			idx = p.join(vals, p.sym("SUBSEP").String())
-		}
-		if err = p.mustmatch("in"); err != nil {
-			return
-		}
-	} else {
-		val, err = next(exec, stop) // Left associative.
-		if err != nil || !p.match("in") {
-			return
-		}
-		if exec {
-			idx = val.String()
-		}
-	}
-	arr, err = next(exec, stop)
-	if err != nil || !exec {
-		return
-	}
-	return arr.HasKey(idx), nil
-}
-
-func (p *awkp) ere(next awkeval, exec bool, stop strset) (val *awkcell, err error) {
-	var rval *awkcell
-	var m bool
-	if val, err = next(exec, stop); err != nil {
-		return
+		return p.bool(rval.Bool()), nil
 	}
-	if p.match("~") {
-		m = true
-	} else if p.match("!", "~") {
-		m = false
-	} else {
+	if rval, err = p.eval(n.r); err != nil {
 		return
 	}
-	for {
-		if p.peek(0).kind == "ere" {
-			rval = p.string(p.next().name)
-			rval.regexp = true
-		} else if rval, err = next(exec, stop); err != nil {
-			return
-		}
-		if exec {
-			var re *regexp.Regexp
-			re, err = regexp.CompilePOSIX(rval.String())
-			if err != nil {
-				return nil, p.lexer.newTokenErrorf(p.peek(0), "bad regex: %s", err)
-			}
-			r := re.MatchString(val.String())
-			if m {
-				val = p.bool(r)
-			} else {
-				val = p.bool(!r)
-			}
-		}
-		if p.match("~") {
-			m = true
-		} else if p.match("!", "~") {
-			m = false
-		} else {
-			return
-		}
-	}
-}
-
-func (p *awkp) cmp(next awkeval, exec bool, stop strset) (val *awkcell, err error) {
-	if val, err = next(exec, stop); err != nil {
-		return
+	switch n.op.kind {
+	case "<":
+		return p.bool(p.cmpvals(val, rval) < 0), nil
+	case "<=":
+		return p.bool(p.cmpvals(val, rval) <= 0), nil
+	case "==":
+		return p.bool(p.cmpvals(val, rval) == 0), nil
+	case "!=":
+		return p.bool(p.cmpvals(val, rval) != 0), nil
+	case ">=":
+		return p.bool(p.cmpvals(val, rval) >= 0), nil
+	case ">":
+		return p.bool(p.cmpvals(val, rval) > 0), nil
+	case "concat":
+		return p.string(val.String() + rval.String()), nil
 	}
-	var op string
-	var rval *awkcell
-	for !stop[p.peek(0).kind] && p.matchany("<", "<=", "==", "!=", ">=", ">") {
-		op = p.peek(-1).kind
-		if rval, err = next(exec, stop); err != nil {
-			return
-		}
-		if !exec {
-			continue
-		}
-		switch op {
-		case "<":
-			val = p.bool(p.cmpvals(val, rval) < 0)
-		case "<=":
-			val = p.bool(p.cmpvals(val, rval) <= 0)
-		case "==":
-			val = p.bool(p.cmpvals(val, rval) == 0)
-		case "!=":
-			val = p.bool(p.cmpvals(val, rval) != 0)
-		case ">=":
-			val = p.bool(p.cmpvals(val, rval) >= 0)
-		case ">":
-			val = p.bool(p.cmpvals(val, rval) > 0)
-		}
+	if val, err = p.arith(n.op.kind, val, rval); err != nil {
+		return nil, p.lexer.newTokenErrorf(n.op, "%s", err)
 	}
 	return
 }
 
-func (p *awkp) cmpvals(lval *awkcell, rval *awkcell) int {
-	if lval.IsString() || rval.IsString() {
-		return cmp.Compare(lval.String(), rval.String())
-	} else {
-		return cmp.Compare(lval.Num(), rval.Num())
-	}
-}
-
-func (p *awkp) concat(next awkeval, exec bool, stop strset) (val *awkcell, err error) {
-	val, err = next(exec, stop)
-	if err != nil {
-		return
-	}
-	for {
-		pos := p.pos
-		_, exprerr := next(false, stop)
-		p.pos = pos
-		if exprerr != nil {
-			return // Invalid expression; nothing to concatenate.
-		}
-		var rval *awkcell
-		rval, err = next(exec, stop) // Left associative, always matches.
-		if err != nil {
-			return
-		}
-		if exec {
-			val = p.string(val.String() + rval.String())
-		}
-	}
-}
-
-func (p *awkp) add(next awkeval, exec bool, stop strset) (val *awkcell, err error) {
-	val, err = next(exec, stop)
-	if err != nil {
-		return
-	}
-	var op string
-	var rval *awkcell
-	for p.matchany("+", "-") {
-		op = p.peek(-1).kind
-		rval, err = next(exec, stop)
-		if err != nil {
-			return
-		}
-		if !exec {
-			continue
-		}
-		switch op {
-		case "+":
-			val = p.num(val.Num() + rval.Num())
-		case "-":
-			val = p.num(val.Num() - rval.Num())
-		}
-	}
-	return
-}
+var errDivisor = errors.New("bad divisor: 0")
 
-func (p *awkp) multiply(next awkeval, exec bool, stop strset) (val *awkcell, err error) {
-	val, err = next(exec, stop)
-	if err != nil {
-		return
+// arith applies the arithmetic operator op to l and r.
+func (p *awkp) arith(op string, l, r *awkcell) (*awkcell, error) {
+	if p.bignum {
+		return p.bigarith(op, l, r)
 	}
-	var op *token
-	var rval *awkcell
-	for p.matchany("*", "/", "%") {
-		op = p.peek(-1)
-		rval, err = next(exec, stop)
-		if err != nil {
-			return
-		}
-		if !exec {
-			continue
-		}
-		switch op.kind {
-		case "*":
-			val = p.num(val.Num() * rval.Num())
-		case "/":
-			if rval.Num() == 0 {
-				return nil, p.lexer.newTokenErrorf(op, "bad divisor: 0")
-			}
-			val = p.num(val.Num() / rval.Num())
-		case "%":
-			val = p.num(math.Mod(val.Num(), rval.Num()))
-		}
-	}
-	return
+	return p.floatarith(op, l.Num(), r.Num())
 }
 
-func (p *awkp) unary(next awkeval, exec bool, stop strset) (val *awkcell, err error) {
-	if !p.matchany("-", "+", "!") {
-		return next(exec, stop)
-	}
-	op := p.peek(-1).kind
-	val, err = p.unary(next, exec, stop) // Right associative.
-	if err != nil || !exec {
-		return
-	}
+func (p *awkp) floatarith(op string, l, r float64) (*awkcell, error) {
 	switch op {
+	case "+":
+		return p.num(l + r), nil
 	case "-":
-		val = p.num(-1 * val.Num())
-		if val.Num() == -0 {
-			val.SetNum(0)
+		return p.num(l - r), nil
+	case "*":
+		return p.num(l * r), nil
+	case "/":
+		if r == 0 {
+			return nil, errDivisor
 		}
-	case "+":
-		val = p.num(val.Num())
-	case "!":
-		val = p.bool(!val.Bool())
+		return p.num(l / r), nil
+	case "%":
+		return p.num(math.Mod(l, r)), nil
+	default: // "^", "**"
+		return p.num(math.Pow(l, r)), nil
 	}
-	return
 }
 
-func (p *awkp) exp(next awkeval, exec bool, stop strset) (val *awkcell, err error) {
-	val, err = next(exec, stop)
-	if err != nil {
-		return
-	}
-	if !p.matchany("^", "**") {
-		return
-	}
-	var rval *awkcell
-	rval, err = p.exp(next, exec, stop) // Right associative.
-	if err != nil || !exec {
-		return
-	}
-	val = p.num(math.Pow(val.Num(), rval.Num()))
-	return
-}
-
-func (p *awkp) prefixop(next awkeval, exec bool, stop strset) (val *awkcell, err error) {
-	if !p.matchany("++", "--") {
-		return next(exec, stop)
-	}
-	sign := p.peek(-1).kind
-	val, err = next(exec, stop)
-	if !exec {
-		return
-	}
-	if !val.assignable {
-		err = p.lexer.newTokenErrorf(p.peek(0), "bad variable")
-		return
-	}
-	switch sign {
-	case "++":
-		val.SetNum(val.Num() + 1)
-	case "--":
-		val.SetNum(val.Num() - 1)
-	}
-	if val.assignhook != nil {
-		if err = val.assignhook(); err != nil {
-			return
-		}
+// tonum returns the numeric value of c.
+func (p *awkp) tonum(c *awkcell) *awkcell {
+	if p.bignum {
+		return p.bigcell(p.bigval(c))
 	}
-	return
+	return p.num(c.Num())
 }
 
-func (p *awkp) postfixop(next awkeval, exec bool, stop strset) (val *awkcell, err error) {
-	val, err = next(exec, stop)
-	if !p.matchany("++", "--") {
+func (p *awkp) regex(s string) (re *regexp.Regexp, err error) {
+	if re = p.regexps[s]; re != nil {
 		return
 	}
-	if !exec {
+	if re, err = awkcompile(s); err != nil {
 		return
 	}
-	if !val.assignable {
-		err = p.lexer.newTokenErrorf(p.peek(0), "bad variable")
-		return
-	}
-	var varval *awkcell
-	switch p.peek(-1).kind {
-	case "++":
-		varval = val
-		val = p.num(varval.Num())
-		varval.SetNum(val.Num() + 1)
-	case "--":
-		varval = val
-		val = p.num(varval.Num())
-		varval.SetNum(val.Num() - 1)
-	}
-	if varval.assignhook != nil {
-		if err = varval.assignhook(); err != nil {
-			return
+	if len(p.regexps) >= awkregexps {
+		for k := range p.regexps {
+			delete(p.regexps, k) // Evict an arbitrary entry.
+			break
 		}
 	}
+	p.regexps[s] = re
 	return
 }
 
-func (p *awkp) fieldref(next awkeval, exec bool, stop strset) (val *awkcell, err error) {
-	if !p.match("$") {
-		return next(exec, stop)
-	}
-	var i *awkcell
-	i, err = next(exec, stop)
-	if err != nil || !exec {
-		return nil, err
-	}
-	val = p.Field(int(i.Num()))
-	return
-}
-
-func (p *awkp) group(next awkeval, exec bool, stop strset) (val *awkcell, err error) {
-	if !p.match("(") {
-		return next(exec, stop)
-	}
-	val, err = p.expr(exec, p.stopexpr)
-	if err != nil {
-		return
-	}
-	err = p.mustmatch(")")
-	return
-}
-
-func (p *awkp) val(_ awkeval, exec bool, _ strset) (val *awkcell, err error) {
-	tok := p.next()
-	switch tok.kind {
-	case "number":
-		return p.numval(tok, exec)
-	case "name":
-		return p.symval(tok, exec)
-	case "string":
-		return p.strval(tok, exec)
-	case "ere":
-		return p.ereval(tok, exec)
-	case "builtin_func":
-		p.fntok = append(p.fntok, tok)
-		defer func() { p.fntok = p.fntok[:len(p.fntok)-1] }()
-		return p.builtin(tok.name, exec)
-	case "func_name":
-		p.fntok = append(p.fntok, tok)
-		defer func() { p.fntok = p.fntok[:len(p.fntok)-1] }()
-		return p.fn(tok.name, exec)
-	case "getline":
-		return p.getlinefn(exec)
-	default:
-		return nil, p.lexer.newTokenError(tok)
-	}
+// awksorts are the predefined orders for traversing an array.
+// Ties are broken by comparing indices as strings.
+var awksorts = map[string]func(a, b *awkcell) int{
+	"@ind_str_asc": func(a, b *awkcell) int { return 0 },
+	"@ind_num_asc": func(a, b *awkcell) int {
+		an, _ := awkparsenum(a.name)
+		bn, _ := awkparsenum(b.name)
+		return cmp.Compare(an, bn)
+	},
+	"@val_type_asc": func(a, b *awkcell) int {
+		if c := cmp.Compare(awktyperank(a), awktyperank(b)); c != 0 {
+			return c
+		} else if a.IsString() {
+			return cmp.Compare(a.String(), b.String())
+		}
+		return cmp.Compare(a.Num(), b.Num())
+	},
+	"@val_str_asc": func(a, b *awkcell) int {
+		return cmp.Compare(a.String(), b.String())
+	},
+	"@val_num_asc": func(a, b *awkcell) int {
+		if c := cmp.Compare(a.Num(), b.Num()); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.String(), b.String())
+	},
 }
 
-func (p *awkp) numval(tok *token, exec bool) (*awkcell, error) {
-	if !exec {
-		return nil, nil
-	}
-	num, err := strconv.ParseFloat(tok.name, 64)
-	if err != nil {
-		return nil, p.lexer.newTokenErrorf(tok, "bad number")
+func init() {
+	for name, fn := range awksorts {
+		fn := fn
+		awksorts[strings.TrimSuffix(name, "asc")+"desc"] = func(a, b *awkcell) int {
+			if c := fn(b, a); c != 0 {
+				return c
+			}
+			return cmp.Compare(b.name, a.name)
+		}
 	}
-	return p.num(num), nil
 }
 
-func (p *awkp) symval(tok *token, exec bool) (*awkcell, error) {
-	if idx, ok, err := p.symidx(exec); err != nil {
-		return nil, err
-	} else if ok {
-		return p.sym(tok.name).Key(idx), nil
+// awktyperank orders numbers before strings, as gawk does.
+func awktyperank(c *awkcell) int {
+	if c.IsString() {
+		return 1
 	}
-	return p.sym(tok.name), nil
+	return 0
 }
 
-func (p *awkp) symidx(exec bool) (string, bool, error) {
-	if p.peek(-1).kind != "name" || !p.match("[") {
-		return "", false, nil
-	}
-	vals, err := p.exprlist(exec, p.stopexprlist)
-	if err != nil {
-		return "", false, err
-	}
-	if err = p.mustmatch("]"); err != nil {
-		return "", false, err
+// sorted returns the elements of m in the named order: one of awksorts, or a
+// user function that compares two elements given as (i1, v1, i2, v2).
+func (p *awkp) sorted(m *awkmap, order string) (cells []*awkcell, err error) {
+	fn, ok := awksorts[order]
+	if f := p.funcs[order]; !ok && f != nil {
+		fn = func(a, b *awkcell) int {
+			if err != nil {
+				return 0
+			}
+			var r *awkcell
+			r, err = p.callfn(f, []*awkcell{p.strnum(a.name), a, p.strnum(b.name), b})
+			if err != nil {
+				return 0
+			}
+			return cmp.Compare(r.Num(), 0)
+		}
+	} else if !ok {
+		return nil, fmt.Errorf("bad sort order: %s", order)
 	}
-	if !exec {
-		return "", false, nil
+	cells = m.cells()
+	slices.SortFunc(cells, func(a, b *awkcell) int {
+		if c := fn(a, b); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.name, b.name)
+	})
+	return cells, err
+}
+
+func (p *awkp) cmpvals(lval *awkcell, rval *awkcell) int {
+	if lval.IsString() || rval.IsString() {
+		return cmp.Compare(lval.String(), rval.String())
+	} else if p.bignum {
+		return p.bigcmp(lval, rval)
+	} else {
+		return cmp.Compare(lval.Num(), rval.Num())
 	}
-	return p.join(vals, p.sym("SUBSEP").String()), true, nil
 }
 
-func (p *awkp) strval(tok *token, exec bool) (val *awkcell, err error) {
-	if !exec {
+func (p *awkp) match(n *awkmatch) (val *awkcell, err error) {
+	var rval *awkcell
+	if val, err = p.eval(n.l); err != nil {
 		return
 	}
-	if str, err := p.unescape(tok.name); err != nil {
-		return nil, p.lexer.newTokenErrorf(tok, err.Error())
-	} else {
-		val = p.string(str)
+	re := n.re
+	if re == nil {
+		if rval, err = p.eval(n.r); err != nil {
+			return
+		}
+		if re, err = p.regex(rval.String()); err != nil {
+			return nil, p.lexer.newTokenErrorf(n.op, "bad regex: %s", err)
+		}
 	}
-	val.string = true
-	return
+	return p.bool(re.MatchString(val.String()) != n.negate), nil
 }
 
-func (p *awkp) ereval(tok *token, exec bool) (val *awkcell, err error) {
-	if !exec {
+func (p *awkp) incdec(n *awkincdec) (val *awkcell, err error) {
+	var c *awkcell
+	if c, err = p.eval(n.e); err != nil {
 		return
 	}
-	if p.ereimplicit() {
-		return p.ererecord(tok.name)
+	val = p.tonum(c)
+	var next *awkcell
+	if next, err = p.arith(n.op.kind[:1], val, p.num(1)); err != nil {
+		return
 	}
-	val = p.string(tok.name)
-	val.regexp = true
-	return
+	c.SetNumber(next)
+	if n.prefix {
+		val = c
+	}
+	return val, c.AssignHook()
 }
 
-func (p *awkp) ereimplicit() bool {
-	// A regex is an implicit match against field 0 if:
-	//   - it is not part of a regex match expression; and
-	//   - it is not an argument to specific builtin functions (see p.erefn).
-	return len(p.fntok) == 0 || !p.erefn[p.fntok[len(p.fntok)-1].name]
+func (p *awkp) call(n *awkcall) (val *awkcell, err error) {
+	if err = p.cmd.ctx.Err(); err != nil {
+		return
+	}
+	var args []*awkcell
+	if args, err = p.evallist(n.args); err != nil {
+		return
+	}
+	if n.fn == nil {
+		if val, err = p.externs[n.token.name](p, args); err != nil {
+			return nil, p.lexer.newTokenErrorf(n.token, "%s", err)
+		}
+		return
+	}
+	return p.callfn(n.fn, args)
 }
 
-func (p *awkp) ererecord(s string) (val *awkcell, err error) {
-	var re *regexp.Regexp
-	re, err = regexp.CompilePOSIX(s)
-	if err != nil {
-		return nil, p.lexer.newTokenErrorf(p.peek(0), "bad regex: %s", err)
+func (p *awkp) callfn(fn *awkfn, args []*awkcell) (val *awkcell, err error) {
+	frame := &awkframe{locals: make([]*awkcell, len(fn.params))}
+	for i := range frame.locals {
+		frame.locals[i] = &awkcell{prog: p}
+		if i < len(args) {
+			frame.locals[i].Set(args[i])
+		}
 	}
-	val = p.bool(re.MatchString(p.Field(0).String()))
-	return
+	p.frames = append(p.frames, frame)
+	defer func() { p.frames = p.frames[:len(p.frames)-1] }()
+	err = p.execblock(fn.body)
+	var terr *tokenError
+	if errors.As(err, &terr) && terr.isJump("return") {
+		return p.retval, nil
+	} else if err != nil {
+		return
+	}
+	return &awkcell{prog: p}, nil
 }
 
-func (p *awkp) getlinefn(exec bool) (val *awkcell, err error) {
+func (p *awkp) getlineexpr(n *awkgetline) (val *awkcell, err error) {
 	var r runeScanCloser
 	set := p.Field(0)
-	if p.match("name") {
-		set = p.sym(p.peek(-1).name)
+	if n.set != nil {
+		set = p.cell(n.set)
 	}
-	if p.match("<") {
-		if val, err = p.expr(exec, p.stopexpr); err != nil || !exec {
+	if n.src != nil {
+		if val, err = p.eval(n.src); err != nil {
 			return
 		}
-		r = p.readers[val.String()]
-		if r == nil {
-			var f io.ReadCloser
-			if val.String() == "-" {
-				f = io.NopCloser(p.cmd.Stdin)
-			} else if f, err = os.Open(val.String()); err != nil {
-				// TODO: replace with hive.FS
-				err = nil
-				val = p.num(-1)
+		if r = p.readers[val.String()]; r == nil {
+			if r, err = p.openreader(n, val.String()); err != nil {
 				return
+			} else if r == nil {
+				return p.num(-1), nil
 			}
-			r = newBufferedReadCloser(f)
 			p.readers[val.String()] = r
 		}
 	}
-	if !exec {
-		return
-	}
 	return p.getline(r, set)
 }
 
-func (p *awkp) builtin(name string, exec bool) (val *awkcell, err error) {
-	var args []*awkcell
-	if p.match("(") {
-		if args, err = p.exprlist(exec, p.stopexprlist); err != nil {
-			return
-		}
-		if err = p.mustmatch(")"); err != nil {
-			return
+func (p *awkp) openreader(n *awkgetline, name string) (runeScanCloser, error) {
+	p.flush() // The source may be a file or command we have written to.
+	if n.kind == "|" {
+		cmd := p.cmd.spawn("sh", "-c", name)
+		rc, err := cmd.StdoutCloser()
+		if err != nil {
+			return nil, p.lexer.newTokenErrorf(n.token, "bad command '%s': %s",
+				name, err)
 		}
+		cmd.Start()
+		return newBufferedReadCloser(rc), nil
 	}
-	if !exec {
-		return
-	}
-	fn, ok := p.builtins[name]
-	if !ok {
-		err = p.lexer.newTokenErrorf(p.fntok[len(p.fntok)-1], "bad function")
-		return
+	if awkstdin[name] {
+		return &bufferedReadCloser{p.stdinreader(), io.NopCloser(nil)}, nil
 	}
-	val, err = fn(args)
+	f, err := p.cmd.FS.Open(name)
 	if err != nil {
-		err = p.lexer.newTokenErrorf(p.fntok[len(p.fntok)-1], err.Error())
+		return nil, nil
 	}
-	return
+	return newBufferedReadCloser(f), nil
 }
 
-func (p *awkp) fn(name string, exec bool) (val *awkcell, err error) {
-	fn := p.sym(name).fnval
-	if fn == nil {
-		return nil, p.lexer.newTokenErrorf(p.peek(-1), "bad function: %s", name)
+// stdinreader returns the reader shared by every use of stdin.
+func (p *awkp) stdinreader() *bufio.Reader {
+	if p.stdin == nil {
+		p.stdin = bufio.NewReader(&ctxReader{ctx: p.cmd.ctx, r: p.cmd.Stdin})
 	}
-	var args []*awkcell
-	if args, err = p.exprlistp(exec, p.stopexprlist); err != nil {
-		return
+	return p.stdin
+}
+
+func (p *awkp) asortfn(args []*awkcell) (val *awkcell, err error) {
+	return p.asort(args, "@val_type_asc", func(c *awkcell) *awkcell {
+		v := &awkcell{prog: p}
+		v.Set(c)
+		v.arrval = nil
+		return v
+	})
+}
+
+func (p *awkp) asortifn(args []*awkcell) (val *awkcell, err error) {
+	return p.asort(args, "@ind_str_asc", func(c *awkcell) *awkcell {
+		return p.string(c.name)
+	})
+}
+
+// asort replaces the destination array, or the source if there is none, with
+// the result of elem for each element of the source in sorted order.
+func (p *awkp) asort(args []*awkcell, order string, elem func(*awkcell) *awkcell) (*awkcell, error) {
+	if len(args) < 1 || len(args) > 3 {
+		return nil, fmt.Errorf("bad argc: want 1-3, got %d", len(args))
 	}
-	if !exec {
-		return
+	if len(args) > 2 {
+		order = args[2].String()
 	}
-	val, err = p.call(fn, args)
-	var terr *tokenError
-	if errors.As(err, &terr) && terr.token.name == "return" {
-		err = nil
+	cells, err := p.sorted(args[0].Arr(), order)
+	if err != nil {
+		return nil, err
 	}
-	return
-}
-
-func (p *awkp) call(fn *awkfn, args []*awkcell) (val *awkcell, err error) {
-	frame := &awkframe{symbols: make(map[string]*awkcell)}
-	p.frames = append(p.frames, frame)
-	defer func() { p.frames = p.frames[:len(p.frames)-1] }()
-	for i, tok := range fn.params {
-		c := &awkcell{prog: p, assignable: true}
-		if i < len(args) {
-			c.Set(args[i])
-		}
-		frame.symbols[tok.name] = c
+	dst := args[0]
+	if len(args) > 1 {
+		dst = args[1]
 	}
-	pos := p.pos
-	defer func() { p.pos = pos }()
-	p.pos = fn.block.pos
-	return p.evalblock(true)
+	dst.Arr().reset()
+	for i, c := range cells {
+		dst.SetField(i+1, elem(c))
+	}
+	return p.num(float64(len(cells))), nil
 }
 
 func (p *awkp) atan2fn(args []*awkcell) (val *awkcell, err error) {
@@ -1715,15 +1444,33 @@ func (p *awkp) closefn(args []*awkcell) (val *awkcell, err error) {
 		err = fmt.Errorf("bad argc: want 1, got %d", len(args))
 		return
 	}
-	w := p.writers[args[0].String()]
-	if w == nil {
-		return
+	name := args[0].String()
+	w, r := p.writers[name], p.readers[name]
+	if w == nil && r == nil {
+		return p.num(-1), nil
 	}
-	if err = w.Close(); err != nil {
-		return
+	var status int
+	if w != nil {
+		delete(p.writers, name)
+		status = awkstatus(w.Close())
 	}
-	w = nil
-	return
+	if r != nil {
+		delete(p.readers, name)
+		status = awkstatus(r.Close())
+	}
+	return p.num(float64(status)), nil
+}
+
+// awkstatus converts the result of closing a file or command to the value
+// returned by close: the exit status of a command, or -1 on failure.
+func awkstatus(err error) int {
+	var exit *ExitError
+	if errors.As(err, &exit) {
+		return exit.Code
+	} else if err != nil {
+		return -1
+	}
+	return 0
 }
 
 func (p *awkp) cosfn(args []*awkcell) (val *awkcell, err error) {
@@ -1743,7 +1490,7 @@ func (p *awkp) expfn(args []*awkcell) (val *awkcell, err error) {
 }
 
 func (p *awkp) lengthfn(args []*awkcell) (val *awkcell, err error) {
-	if len(args) > 2 {
+	if len(args) > 1 {
 		return nil, fmt.Errorf("bad argc: want 0-1, got %d", len(args))
 	}
 	var arg *awkcell
@@ -1782,96 +1529,388 @@ func (p *awkp) indexfn(args []*awkcell) (val *awkcell, err error) {
 			}
 		}
 	}
-	return p.num(0), nil
+	return p.num(0), nil
+}
+
+func (p *awkp) logfn(args []*awkcell) (val *awkcell, err error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("bad argc: want 1, got %d", len(args))
+	}
+	return p.num(math.Log(args[0].Num())), nil
+}
+
+func (p *awkp) matchfn(args []*awkcell) (val *awkcell, err error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("bad argc: want 2, got %d", len(args))
+	}
+	s := args[0].String()
+	pat := args[1].String()
+	var re *regexp.Regexp
+	if re, err = p.regex(pat); err != nil {
+		err = fmt.Errorf("bad regex: %s", err)
+		return
+	}
+	idx := re.FindStringIndex(s)
+	if idx != nil {
+		var start int
+		for range s[:idx[0]] {
+			start++
+		}
+		var length int
+		for range s[idx[0]:idx[1]] {
+			length++
+		}
+		p.sym("RSTART").SetNum(float64(start + 1))
+		p.sym("RLENGTH").SetNum(float64(length))
+		val = p.num(float64(start + 1))
+	} else {
+		p.sym("RSTART").SetNum(0)
+		p.sym("RLENGTH").SetNum(-1)
+		val = p.num(0)
+	}
+	return
+}
+
+// mktimefn converts a "YYYY MM DD HH MM SS [DST]" datespec to seconds since
+// the epoch, normalizing out of range values. It returns -1 on a bad datespec.
+func (p *awkp) mktimefn(args []*awkcell) (val *awkcell, err error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, fmt.Errorf("bad argc: want 1-2, got %d", len(args))
+	}
+	fields := strings.Fields(args[0].String())
+	if len(fields) < 6 || len(fields) > 7 {
+		return p.num(-1), nil
+	}
+	n := [7]int{6: -1}
+	for i := range fields {
+		if n[i], err = strconv.Atoi(fields[i]); err != nil {
+			return p.num(-1), nil
+		}
+	}
+	loc := p.location()
+	if len(args) > 1 && args[1].Bool() {
+		loc = time.UTC
+	}
+	t := time.Date(n[0], time.Month(n[1]), n[2], n[3], n[4], n[5], 0, loc)
+	if n[6] >= 0 {
+		t = awkdst(t, n[6] > 0)
+	}
+	return p.num(float64(t.Unix())), nil
+}
+
+// awkdst reads the wall clock of t as daylight saving time if dst is true, or
+// as standard time if not, like mktime with tm_isdst set. Zones that never
+// change their offset are left alone.
+func awkdst(t time.Time, dst bool) time.Time {
+	if t.IsDST() == dst {
+		return t
+	}
+	_, off := t.Zone()
+	for _, m := range []int{-6, 6} {
+		if u := t.AddDate(0, m, 0); u.IsDST() == dst {
+			_, uoff := u.Zone()
+			return t.Add(time.Duration(off-uoff) * time.Second)
+		}
+	}
+	return t
+}
+
+func (p *awkp) fflushfn(args []*awkcell) (val *awkcell, err error) {
+	if len(args) > 1 {
+		return nil, fmt.Errorf("bad argc: want 0-1, got %d", len(args))
+	} else if len(args) == 0 || args[0].String() == "" {
+		p.flush()
+		return p.num(0), nil
+	} else if p.stdwriter(args[0].String()) != nil {
+		_ = p.stdout.Flush()
+		return p.num(0), nil
+	}
+	w, ok := p.writers[args[0].String()].(*bufferedWriteCloser)
+	if !ok {
+		return p.num(-1), nil
+	} else if err := w.Flush(); err != nil {
+		return p.num(-1), nil
+	}
+	return p.num(0), nil
+}
+
+// gensubfn returns target with the matches of regexp replaced. Unlike gsub,
+// the replacement may refer to submatches as \1 through \9, and how selects
+// every match ("g") or only the Nth.
+func (p *awkp) gensubfn(args []*awkcell) (val *awkcell, err error) {
+	if len(args) < 3 || len(args) > 4 {
+		return nil, fmt.Errorf("bad argc: want 3-4, got %d", len(args))
+	}
+	var re *regexp.Regexp
+	if re, err = p.regex(args[0].String()); err != nil {
+		return nil, fmt.Errorf("bad regex: %s", err)
+	}
+	rpl := args[1].String()
+	nth := -1
+	if how := args[2].String(); how == "" || (how[0] != 'g' && how[0] != 'G') {
+		nth = max(int(args[2].Num()), 1)
+	}
+	var in string
+	if len(args) == 4 {
+		in = args[3].String()
+	} else {
+		in = p.Field(0).String()
+	}
+	var b strings.Builder
+	var last int
+	for i, m := range re.FindAllStringSubmatchIndex(in, -1) {
+		if nth > 0 && i+1 != nth {
+			continue
+		}
+		b.WriteString(in[last:m[0]])
+		for j := 0; j < len(rpl); j++ {
+			switch c := rpl[j]; {
+			case c == '&':
+				b.WriteString(in[m[0]:m[1]])
+			case c == '\\' && j+1 < len(rpl) && rpl[j+1] >= '0' && rpl[j+1] <= '9':
+				j++
+				if k := 2 * int(rpl[j]-'0'); k < len(m) && m[k] >= 0 {
+					b.WriteString(in[m[k]:m[k+1]])
+				}
+			case c == '\\' && j+1 < len(rpl) && (rpl[j+1] == '&' || rpl[j+1] == '\\'):
+				j++
+				b.WriteByte(rpl[j])
+			default:
+				b.WriteByte(c)
+			}
+		}
+		last = m[1]
+	}
+	b.WriteString(in[last:])
+	return p.string(b.String()), nil
+}
+
+func (p *awkp) gsubfn(args []*awkcell) (val *awkcell, err error) {
+	return p.substitute(args, true)
+}
+
+func (p *awkp) substitute(args []*awkcell, global bool) (val *awkcell, err error) {
+	if len(args) < 2 || len(args) > 3 {
+		return nil, fmt.Errorf("bad argc: want 2-3, got %d", len(args))
+	}
+	pat := args[0].String()
+	rpl := args[1].String()
+	var in *awkcell
+	if len(args) == 3 {
+		in = args[2]
+	} else {
+		in = p.Field(0)
+	}
+	var re *regexp.Regexp
+	if re, err = p.regex(pat); err != nil {
+		err = fmt.Errorf("bad regex: %s", err)
+		return
+	}
+	var count int
+	s := re.ReplaceAllStringFunc(in.String(), func(m string) string {
+		if count > 0 && !global {
+			return m
+		}
+		count++
+		var r strings.Builder
+		for i := 0; i < len(rpl); i++ {
+			switch {
+			case rpl[i] == '\\' && strings.HasPrefix(rpl[i+1:], "&"):
+				i++
+				r.WriteByte('&')
+			case rpl[i] == '\\' && strings.HasPrefix(rpl[i+1:], "\\&"):
+				i++
+				r.WriteByte('\\') // Followed by the matched text.
+			case rpl[i] == '&':
+				r.WriteString(m)
+			default:
+				r.WriteByte(rpl[i])
+			}
+		}
+		return r.String()
+	})
+	if count > 0 {
+		err = in.AssignString(s)
+	}
+	val = p.num(float64(count))
+	return
+}
+
+func (p *awkp) intfn(args []*awkcell) (val *awkcell, err error) {
+	if len(args) < 1 || len(args) > 1 {
+		return nil, fmt.Errorf("bad argc: want 1, got %d", len(args))
+	}
+	input := args[0]
+	if i := p.bigtrunc(input); i != nil {
+		return p.bigcell(i, nil), nil
+	}
+	return p.num(float64(int(input.Num()))), nil
+}
+
+// Bitwise operations work on integers that a float64 holds exactly.
+const awkmaxint = 1<<53 - 1
+
+func (p *awkp) andfn(args []*awkcell) (val *awkcell, err error) {
+	return p.bitwise(args, func(a, b uint64) uint64 { return a & b })
+}
+
+func (p *awkp) orfn(args []*awkcell) (val *awkcell, err error) {
+	return p.bitwise(args, func(a, b uint64) uint64 { return a | b })
+}
+
+func (p *awkp) xorfn(args []*awkcell) (val *awkcell, err error) {
+	return p.bitwise(args, func(a, b uint64) uint64 { return a ^ b })
+}
+
+func (p *awkp) bitwise(args []*awkcell, op func(a, b uint64) uint64) (val *awkcell, err error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("bad argc: want 2 or more, got %d", len(args))
+	}
+	var n, m uint64
+	if n, err = awkuint(args[0]); err != nil {
+		return
+	}
+	for _, arg := range args[1:] {
+		if m, err = awkuint(arg); err != nil {
+			return
+		}
+		n = op(n, m)
+	}
+	return p.num(float64(n & awkmaxint)), nil
+}
+
+func (p *awkp) complfn(args []*awkcell) (val *awkcell, err error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("bad argc: want 1, got %d", len(args))
+	}
+	var n uint64
+	if n, err = awkuint(args[0]); err != nil {
+		return
+	}
+	return p.num(float64(^n & awkmaxint)), nil
+}
+
+func (p *awkp) lshiftfn(args []*awkcell) (val *awkcell, err error) {
+	return p.shift(args, func(n uint64, count uint) uint64 { return n << count })
+}
+
+func (p *awkp) rshiftfn(args []*awkcell) (val *awkcell, err error) {
+	return p.shift(args, func(n uint64, count uint) uint64 { return n >> count })
+}
+
+func (p *awkp) shift(args []*awkcell, op func(uint64, uint) uint64) (val *awkcell, err error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("bad argc: want 2, got %d", len(args))
+	}
+	var n, count uint64
+	if n, err = awkuint(args[0]); err != nil {
+		return
+	}
+	if count, err = awkuint(args[1]); err != nil {
+		return
+	}
+	return p.num(float64(op(n, uint(min(count, 64))) & awkmaxint)), nil
 }
 
-func (p *awkp) logfn(args []*awkcell) (val *awkcell, err error) {
+// awkuint converts c to an integer operand for a bitwise operation.
+func awkuint(c *awkcell) (uint64, error) {
+	n := math.Trunc(c.Num())
+	if !(n >= 0 && n <= awkmaxint) {
+		return 0, fmt.Errorf("bad integer: %s", c.String())
+	}
+	return uint64(n), nil
+}
+
+func (p *awkp) strtonumfn(args []*awkcell) (val *awkcell, err error) {
 	if len(args) != 1 {
 		return nil, fmt.Errorf("bad argc: want 1, got %d", len(args))
 	}
-	return p.num(math.Log(args[0].Num())), nil
+	if args[0].numval != nil {
+		return p.num(*args[0].numval), nil
+	}
+	return p.num(awkstrtonum(args[0].String())), nil
 }
 
-func (p *awkp) matchfn(args []*awkcell) (val *awkcell, err error) {
-	if len(args) != 2 {
-		return nil, fmt.Errorf("bad argc: want 2, got %d", len(args))
-	}
-	s := args[0].String()
-	pat := args[1].String()
-	var re *regexp.Regexp
-	if re, err = regexp.CompilePOSIX(pat); err != nil {
-		err = fmt.Errorf("bad regex: %s", err)
-		return
+// awkstrtonum parses the leading number in s, which may be a hexadecimal
+// number with a 0x prefix or an octal number with a 0 prefix.
+// An octal number that contains an 8 or 9 is read as decimal.
+func awkstrtonum(s string) float64 {
+	t := strings.TrimLeft(s, awkblanks)
+	base, digits := 0, ""
+	switch {
+	case len(t) > 2 && t[0] == '0' && (t[1] == 'x' || t[1] == 'X'):
+		base, digits = 16, t[2:]
+	case len(t) > 1 && t[0] == '0':
+		base, digits = 8, t[1:]
 	}
-	idx := re.FindStringIndex(s)
-	if idx != nil {
-		var start int
-		for range s[:idx[0]] {
-			start++
+	if base != 0 {
+		end := 0
+		for end < len(digits) && awkdigit(digits[end]) < base {
+			end++
 		}
-		var length int
-		for range s[idx[0]:idx[1]] {
-			length++
+		if base == 16 || end == len(digits) || awkdigit(digits[end]) >= 10 {
+			n, _ := strconv.ParseUint(digits[:end], base, 64)
+			return float64(n)
 		}
-		p.sym("RSTART").SetNum(float64(start + 1))
-		p.sym("RLENGTH").SetNum(float64(length))
-		val = p.num(float64(start + 1))
-	} else {
-		p.sym("RSTART").SetNum(0)
-		p.sym("RLENGTH").SetNum(-1)
-		val = p.num(0)
 	}
-	return
+	n, _ := awkparsenum(s)
+	return n
 }
 
-func (p *awkp) gsubfn(args []*awkcell) (val *awkcell, err error) {
-	if len(args) < 2 || len(args) > 3 {
-		return nil, fmt.Errorf("bad argc: want 2-3, got %d", len(args))
+func awkdigit(c byte) int {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0')
+	case c >= 'a' && c <= 'f':
+		return int(c-'a') + 10
+	case c >= 'A' && c <= 'F':
+		return int(c-'A') + 10
 	}
-	pat := args[0].String()
-	rpl := args[1].String()
-	var in *awkcell
-	if len(args) == 3 {
-		in = args[2]
-	} else {
-		in = p.Field(0)
+	return 16
+}
+
+// patsplitfn splits s into the array a by the text that matches fieldpat,
+// which defaults to FPAT. The separators between fields are stored in seps:
+// seps[i] follows a[i], and seps[0] holds any leading separator.
+func (p *awkp) patsplitfn(args []*awkcell) (val *awkcell, err error) {
+	if len(args) < 2 || len(args) > 4 {
+		return nil, fmt.Errorf("bad argc: want 2-4, got %d", len(args))
+	}
+	pat := p.sym("FPAT").String()
+	if len(args) > 2 {
+		pat = args[2].String()
 	}
 	var re *regexp.Regexp
-	if re, err = regexp.CompilePOSIX(pat); err != nil {
-		err = fmt.Errorf("bad regex: %s", err)
-		return
+	if re, err = p.regex(pat); err != nil {
+		return nil, fmt.Errorf("bad regex: %s", err)
 	}
-	var count int
-	in.SetString(re.ReplaceAllStringFunc(in.String(), func(s string) string {
-		count++
-		m := re.FindString(s)
-		var r string
-		for i, c := range rpl {
-			if c == '&' && (i == 0 || rpl[i-1] != '\\') {
-				r += m
-			} else if !(c == '\\' && i < len(rpl)-1 && rpl[i+1] == '&') {
-				r += string(c)
-			}
+	s, a := args[0].String(), args[1]
+	a.Arr().reset()
+	var seps *awkcell
+	if len(args) > 3 {
+		seps = args[3]
+		seps.Arr().reset()
+	}
+	var last int
+	matches := re.FindAllStringIndex(s, -1)
+	for i, m := range matches {
+		a.SetField(i+1, p.strnum(s[m[0]:m[1]]))
+		if seps != nil && (i > 0 || m[0] > 0) {
+			seps.SetField(i, p.strnum(s[last:m[0]]))
 		}
-		return re.ReplaceAllString(s, r)
-	}))
-	val = p.num(float64(count))
-	return
-}
-
-func (p *awkp) intfn(args []*awkcell) (val *awkcell, err error) {
-	if len(args) < 1 || len(args) > 1 {
-		return nil, fmt.Errorf("bad argc: want 1, got %d", len(args))
+		last = m[1]
 	}
-	input := args[0]
-	return p.num(float64(int(input.Num()))), nil
+	if seps != nil && last < len(s) {
+		seps.SetField(len(matches), p.strnum(s[last:]))
+	}
+	return p.num(float64(len(matches))), nil
 }
 
 func (p *awkp) randfn(args []*awkcell) (val *awkcell, err error) {
 	if len(args) != 0 {
 		return nil, fmt.Errorf("bad argc: want 0, got %d", len(args))
 	}
-	return p.num(float64(posix.Random()) / float64(math.MaxInt32)), nil
+	return p.num(float64(p.rand.Random()) / float64(math.MaxInt32)), nil
 }
 
 func (p *awkp) sinfn(args []*awkcell) (val *awkcell, err error) {
@@ -1890,7 +1929,9 @@ func (p *awkp) splitfn(args []*awkcell) (val *awkcell, err error) {
 	a := args[1]
 	a.Arr().reset()
 	var fs *awkcell
-	if len(args) == 2 {
+	if len(args) == 2 && p.csv {
+		return p.num(float64(p.splitcsv(s.String(), a))), nil
+	} else if len(args) == 2 {
 		fs = p.sym("FS")
 	} else {
 		fs = args[2]
@@ -1902,6 +1943,9 @@ func (p *awkp) splitfn(args []*awkcell) (val *awkcell, err error) {
 }
 
 func (p *awkp) sprintffn(args []*awkcell) (val *awkcell, err error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("bad argc: want 1 or more, got %d", len(args))
+	}
 	var fmtd string
 	fmtd, err = p.sprintf(args[0].String(), args[1:])
 	if err != nil {
@@ -1920,52 +1964,40 @@ func (p *awkp) sqrtfn(args []*awkcell) (val *awkcell, err error) {
 }
 
 func (p *awkp) srandfn(args []*awkcell) (val *awkcell, err error) {
+	prev := p.seed
 	if len(args) == 0 {
-		posix.Srandom(int(time.Now().Unix()))
+		p.seed = float64(p.cmd.now().Unix())
 	} else if len(args) == 1 {
-		posix.Srandom(int(args[0].Num()))
+		p.seed = args[0].Num()
 	} else {
-		err = fmt.Errorf("bad argc: want 0-1, got %d", len(args))
+		return nil, fmt.Errorf("bad argc: want 0-1, got %d", len(args))
 	}
-	return p.num(1), err
+	p.rand.Srandom(int(p.seed))
+	return p.num(prev), nil
 }
 
-func (p *awkp) subfn(args []*awkcell) (val *awkcell, err error) {
-	if len(args) < 2 || len(args) > 3 {
-		return nil, fmt.Errorf("bad argc: want 2-3, got %d", len(args))
+func (p *awkp) strftimefn(args []*awkcell) (val *awkcell, err error) {
+	if len(args) > 3 {
+		return nil, fmt.Errorf("bad argc: want 0-3, got %d", len(args))
 	}
-	pat := args[0].String()
-	rpl := args[1].String()
-	var in *awkcell
-	if len(args) == 3 {
-		in = args[2]
-	} else {
-		in = p.Field(0)
+	format := awktimefmt
+	if len(args) > 0 {
+		format = args[0].String()
 	}
-	var re *regexp.Regexp
-	if re, err = regexp.CompilePOSIX(pat); err != nil {
-		err = fmt.Errorf("bad regex: %s", err)
-		return
+	t := p.cmd.now()
+	if len(args) > 1 {
+		t = time.Unix(int64(args[1].Num()), 0)
 	}
-	var count int
-	in.SetString(re.ReplaceAllStringFunc(in.String(), func(s string) string {
-		if count > 0 {
-			return s
-		}
-		count++
-		m := re.FindString(s)
-		var r string
-		for i, c := range rpl {
-			if c == '&' && (i == 0 || rpl[i-1] != '\\') {
-				r += m
-			} else if !(c == '\\' && i < len(rpl)-1 && rpl[i+1] == '&') {
-				r += string(c)
-			}
-		}
-		return re.ReplaceAllString(s, r)
-	}))
-	val = p.num(float64(count))
-	return
+	if len(args) > 2 && args[2].Bool() {
+		t = t.UTC()
+	} else {
+		t = t.In(p.location())
+	}
+	return p.string(posix.Strftime(format, t)), nil
+}
+
+func (p *awkp) subfn(args []*awkcell) (val *awkcell, err error) {
+	return p.substitute(args, false)
 }
 
 func (p *awkp) substrfn(args []*awkcell) (val *awkcell, err error) {
@@ -1995,6 +2027,22 @@ func (p *awkp) substrfn(args []*awkcell) (val *awkcell, err error) {
 	return
 }
 
+func (p *awkp) systemfn(args []*awkcell) (val *awkcell, err error) {
+	if len(args) != 1 {
+		err = fmt.Errorf("bad argc: want 1, got %d", len(args))
+		return
+	}
+	p.flush()
+	return p.num(float64(p.cmd.spawn("sh", "-c", args[0].String()).Run())), nil
+}
+
+func (p *awkp) systimefn(args []*awkcell) (val *awkcell, err error) {
+	if len(args) != 0 {
+		return nil, fmt.Errorf("bad argc: want 0, got %d", len(args))
+	}
+	return p.num(float64(p.cmd.now().Unix())), nil
+}
+
 func (p *awkp) tolowerfn(args []*awkcell) (val *awkcell, err error) {
 	if len(args) != 1 {
 		err = fmt.Errorf("bad argc: want 1, got %d", len(args))
@@ -2012,16 +2060,10 @@ func (p *awkp) toupperfn(args []*awkcell) (val *awkcell, err error) {
 }
 
 func (p *awkp) sym(s string) *awkcell {
-	if len(p.frames) > 0 {
-		tab := p.frames[len(p.frames)-1].symbols
-		if val, ok := tab[s]; ok {
-			return val
-		}
-	}
 	if val, ok := p.symbols[s]; ok {
 		return val
 	}
-	p.symbols[s] = &awkcell{prog: p, assignable: true}
+	p.symbols[s] = &awkcell{prog: p}
 	return p.symbols[s]
 }
 
@@ -2030,7 +2072,6 @@ func (p *awkp) Field(i int) *awkcell {
 		return p.fields[i]
 	}
 	c := p.string("")
-	c.assignable = true
 	if i > 0 {
 		c.assignhook = func() error { p.SetField(i, c); return p.ftor() }
 	} else {
@@ -2052,9 +2093,23 @@ func (p *awkp) SetField(i int, c *awkcell) {
 	p.fields[i].Set(c)
 }
 
-func (p *awkp) rtof() error {
+func (p *awkp) rtof() (err error) {
 	p.fields = p.fields[:1]
-	nf, err := p.split(p.Field(0), p, p.sym("FS"))
+	var nf int
+	switch record := p.Field(0).String(); {
+	case p.csv:
+		nf = p.splitcsv(record, p)
+	case p.sep == "FIELDWIDTHS":
+		nf, err = p.splitwidths(record, p.sym("FIELDWIDTHS").String(), p)
+	case p.sep == "FPAT":
+		var re *regexp.Regexp
+		if re, err = p.regex(p.sym("FPAT").String()); err != nil {
+			return fmt.Errorf("bad FPAT regex: %w", err)
+		}
+		nf = p.splitpattern(re, record, p)
+	default:
+		nf, err = p.split(p.Field(0), p, p.sym("FS"))
+	}
 	if err != nil {
 		return err
 	}
@@ -2063,89 +2118,19 @@ func (p *awkp) rtof() error {
 }
 
 func (p *awkp) ftor() error {
-	p.SetField(0, p.string(p.join(p.fields[1:], p.sym("OFS").String())))
-	return nil
-}
-
-func (p *awkp) peek(n int) *token {
-	if p.pos+n < 0 || p.pos+n > len(p.tokens)-1 {
-		return &token{}
-	}
-	return p.tokens[p.pos+n]
-}
-
-func (p *awkp) next() *token {
-	if p.pos > len(p.tokens)-1 {
-		return &token{pos: -1}
-	}
-	p.pos++
-	return p.tokens[p.pos-1]
-}
-
-func (p *awkp) match(kind ...string) bool {
-	pos := p.pos
-	defer func() { p.pos = pos }()
-	for _, k := range kind {
-		if k == "expr" {
-			if _, err := p.expr(false, p.stopexpr); err != nil {
-				return false
-			}
-		} else if k == "exprlist" {
-			if _, err := p.exprlist(false, p.stopexprlist); err != nil {
-				return false
-			}
-		} else if p.next().kind != k {
-			return false
-		}
-	}
-	pos = p.pos
-	return true
-}
-
-func (p *awkp) mustmatch(kind ...string) error {
-	for _, k := range kind {
-		if !p.match(k) {
-			return p.lexer.newTokenErrorf(p.peek(0), "want %s, got %s",
-				k, p.peek(0).kind)
-		}
-	}
+	p.SetField(0, p.strnum(p.join(p.fields[1:], p.sym("OFS").String())))
 	return nil
 }
 
-func (p *awkp) matchany(kind ...string) bool {
-	for _, k := range kind {
-		if p.match(k) {
-			return true
-		}
-	}
-	return false
-}
-
-func (p *awkp) matchstmtdelim() bool {
-	if !p.endstmt[p.peek(-1).kind] && !p.endstmt[p.peek(0).kind] {
-		return false
-	} else if p.stopstmt[p.peek(0).kind] {
-		p.next()
-	}
-	return true
-}
-
-func (p *awkp) matchfordelim() bool {
-	if !p.match(";") {
-		return false
-	}
-	p.matchnewlines()
-	return true
-}
-
-func (p *awkp) matchnewlines() (matched bool) {
-	for p.match("\n") {
-		matched = true
+// setvar assigns a variable from the command line.
+func (p *awkp) setvar(name, val string) {
+	p.sym(name).SetStrnum(val)
+	if awkseps[name] {
+		p.usesep(name)
 	}
-	return
 }
 
-func (p *awkp) unescape(s string) (string, error) {
+func awkunescape(s string) string {
 	runes := []rune(s)
 	var ret strings.Builder
 	for i := 0; i < len(runes); i++ {
@@ -2153,99 +2138,58 @@ func (p *awkp) unescape(s string) (string, error) {
 			ret.WriteRune(runes[i])
 			continue
 		}
-		i++
-		switch runes[i] {
-		case '\\':
-			ret.WriteRune('\\')
-		case 'a':
-			ret.WriteRune('\a')
-		case 'b':
-			ret.WriteRune('\b')
-		case 'f':
-			ret.WriteRune('\f')
-		case 'n':
-			ret.WriteRune('\n')
-		case 'r':
-			ret.WriteRune('\r')
-		case 't':
-			ret.WriteRune('\t')
-		case '&':
-			ret.WriteRune('&')
+		switch runes[i+1] {
+		case '\\', 'a', 'b', 'f', 'n', 'r', 't', 'v',
+			'0', '1', '2', '3', '4', '5', '6', '7':
+			var c rune
+			c, i = awkescape(runes, i)
+			ret.WriteRune(c)
+		case '"', '/', '&':
+			i++
+			ret.WriteRune(runes[i])
 		default:
-			return "", fmt.Errorf("bad escape: \\%c", runes[i])
+			// Keep unknown escapes so dynamic regexes such as "\." still work.
+			ret.WriteRune('\\')
 		}
 	}
-	return ret.String(), nil
+	return ret.String()
 }
 
-func (p *awkp) sprintf(fmtstr string, a []*awkcell) (string, error) {
-	var result strings.Builder
-	var arg int
-	format := []rune(fmtstr)
-	for i := 0; i < len(format); i++ {
-		if format[i] != '%' || i+1 >= len(format) {
-			result.WriteRune(format[i])
-			continue
-		}
-		if format[i+1] == '%' {
-			result.WriteRune('%')
-			i++
-			continue
-		}
-		if arg >= len(a) {
-			return "", fmt.Errorf("bad argc: not enough arguments")
-		}
-		strt := i
-		for {
-			i++
-			if i >= len(format) {
-				return "", fmt.Errorf("bad verb: %s", string(format[strt:i+1]))
-			}
-			if runealpha(format[i]) {
-				break
-			}
-		}
-		verb := string(format[strt : i+1])
-		if err := p.sprintfv(&result, verb, a[arg]); err != nil {
-			return "", err
+func (p *awkp) sprintf(format string, a []*awkcell) (string, error) {
+	args := make([]posix.Arg, len(a))
+	for i, c := range a {
+		args[i] = awkarg{c}
+	}
+	return posix.Sprintf(format, args)
+}
+
+// awkarg adapts an awkcell to the printf engine.
+type awkarg struct {
+	*awkcell
+}
+
+func (a awkarg) Float() float64 {
+	return a.Num()
+}
+
+// Char returns the first character of a string, or the character with the
+// code point of a number.
+func (a awkarg) Char() string {
+	if a.IsString() {
+		r, n := utf8.DecodeRuneInString(a.String())
+		if n == 0 {
+			return ""
 		}
-		arg++
+		return string(r)
 	}
-	return result.String(), nil
+	return string(rune(a.Num()))
 }
 
-func (p *awkp) sprintfv(result *strings.Builder, verb string, val *awkcell) error {
-	verbsl := []rune(verb)
-	switch verbsl[len(verbsl)-1] {
-	case 'c':
-		if val.IsString() {
-			runes := []rune(val.String())
-			if len(runes) > 0 {
-				result.WriteString(fmt.Sprintf(verb, runes[0]))
-			}
-		} else {
-			result.WriteByte(byte(val.Num()))
-		}
-	case 's':
-		result.WriteString(fmt.Sprintf(verb, val.String()))
-	case 'd', 'i':
-		result.WriteString(fmt.Sprintf(verb, int(val.Num())))
-	case 'o', 'x', 'X':
-		result.WriteString(fmt.Sprintf(verb, uint(val.Num())))
-	case 'u':
-		verbsl[len(verbsl)-1] = 'd'
-		result.WriteString(fmt.Sprintf(string(verbsl), uint(val.Num())))
-	case 'g', 'G':
-		if verb == "%g" {
-			verb = "%.6g"
-		}
-		result.WriteString(fmt.Sprintf(verb, val.Num()))
-	case 'a', 'A', 'f', 'e', 'E':
-		result.WriteString(fmt.Sprintf(verb, val.Num()))
-	default:
-		return fmt.Errorf("bad verb: %s", verb)
+func (a awkarg) Big() (*big.Int, *big.Float) {
+	if !a.prog.bignum {
+		return nil, nil
 	}
-	return nil
+	return a.prog.bigval(a.awkcell)
 }
 
 func (p *awkp) join(vals []*awkcell, by string) string {
@@ -2275,7 +2219,7 @@ func (p *awkp) split(s *awkcell, a fielder, fs *awkcell) (count int, err error)
 	} else if fs.String() == " " {
 		count = p.splitspace(s.String(), a)
 	} else if fs.regexp || len(fs.String()) > 1 {
-		if re, err := regexp.CompilePOSIX(fs.String()); err != nil {
+		if re, err := p.regex(fs.String()); err != nil {
 			return 0, fmt.Errorf("bad FS regex: %w", err)
 		} else {
 			count = p.splitregex(re, s.String(), a)
@@ -2287,10 +2231,93 @@ func (p *awkp) split(s *awkcell, a fielder, fs *awkcell) (count int, err error)
 	return
 }
 
+// splitcsv splits s into fields as described by RFC 4180.
+// Quotes around a field are removed and doubled quotes within it are undoubled.
+func (p *awkp) splitcsv(s string, a fielder) (count int) {
+	if s == "" {
+		return
+	}
+	var field strings.Builder
+	var quoted, start = false, true
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quoted && c == '"' && i+1 < len(s) && s[i+1] == '"':
+			field.WriteByte('"')
+			i++
+		case c == '"' && (quoted || start):
+			quoted = !quoted
+		case c == ',' && !quoted:
+			count++
+			a.SetField(count, p.strnum(field.String()))
+			field.Reset()
+			start = true
+			continue
+		default:
+			field.WriteByte(c)
+		}
+		start = false
+	}
+	count++
+	a.SetField(count, p.strnum(field.String()))
+	return
+}
+
+// splitwidths splits s into fields of fixed widths. Each width may be
+// preceded by a number of characters to skip and a colon, and the last
+// width may be "*" for the rest of s.
+func (p *awkp) splitwidths(s string, widths string, a fielder) (count int, err error) {
+	type span struct{ skip, width int }
+	var spans []span
+	list := strings.Fields(widths)
+	for i, w := range list {
+		var sp span
+		skip, width, ok := strings.Cut(w, ":")
+		if !ok {
+			skip, width = "0", w
+		}
+		if sp.skip, err = strconv.Atoi(skip); err != nil || sp.skip < 0 {
+			return 0, fmt.Errorf("bad FIELDWIDTHS: %s", widths)
+		}
+		if width == "*" && i == len(list)-1 {
+			sp.width = -1
+		} else if sp.width, err = strconv.Atoi(width); err != nil || sp.width < 0 {
+			return 0, fmt.Errorf("bad FIELDWIDTHS: %s", widths)
+		}
+		spans = append(spans, sp)
+	}
+	runes := []rune(s)
+	var pos int
+	for _, sp := range spans {
+		if pos += sp.skip; pos >= len(runes) {
+			break
+		}
+		end := len(runes)
+		if sp.width >= 0 {
+			end = min(pos+sp.width, end)
+		}
+		count++
+		a.SetField(count, p.strnum(string(runes[pos:end])))
+		pos = end
+	}
+	return count, nil
+}
+
+// splitpattern splits s into fields that match re.
+func (p *awkp) splitpattern(re *regexp.Regexp, s string, a fielder) (count int) {
+	if s == "" {
+		return
+	}
+	for _, f := range re.FindAllString(s, -1) {
+		count++
+		a.SetField(count, p.strnum(f))
+	}
+	return
+}
+
 func (p *awkp) splitall(s string, a fielder) (count int) {
 	for _, r := range s {
 		count++
-		a.SetField(count, p.string(string(r)))
+		a.SetField(count, p.strnum(string(r)))
 	}
 	return
 }
@@ -2301,7 +2328,7 @@ func (p *awkp) splitspace(s string, a fielder) (count int) {
 		if r == ' ' || r == '\t' || r == '\n' {
 			if field.Len() > 0 {
 				count++
-				a.SetField(count, p.string(field.String()))
+				a.SetField(count, p.strnum(field.String()))
 				field.Reset()
 			}
 			continue
@@ -2310,7 +2337,7 @@ func (p *awkp) splitspace(s string, a fielder) (count int) {
 	}
 	if field.Len() > 0 {
 		count++
-		a.SetField(count, p.string(field.String()))
+		a.SetField(count, p.strnum(field.String()))
 	}
 	return
 }
@@ -2318,7 +2345,7 @@ func (p *awkp) splitspace(s string, a fielder) (count int) {
 func (p *awkp) splitregex(re *regexp.Regexp, s string, a fielder) (count int) {
 	var f string
 	for count, f = range re.Split(s, -1) {
-		a.SetField(count+1, p.string(f))
+		a.SetField(count+1, p.strnum(f))
 	}
 	return count + 1
 }
@@ -2329,7 +2356,7 @@ func (p *awkp) splitrune(fs rune, s string, a fielder) (count int) {
 	for _, r := range s {
 		if r == fs || (rs == "" && r == '\n') {
 			count++
-			a.SetField(count, p.string(field.String()))
+			a.SetField(count, p.strnum(field.String()))
 			field.Reset()
 		} else {
 			field.WriteRune(r)
@@ -2337,7 +2364,7 @@ func (p *awkp) splitrune(fs rune, s string, a fielder) (count int) {
 	}
 	if len(s) > 0 {
 		count++
-		a.SetField(count, p.string(field.String()))
+		a.SetField(count, p.strnum(field.String()))
 	}
 	return
 }
@@ -2358,16 +2385,25 @@ func (p *awkp) string(s string) *awkcell {
 	return &awkcell{strval: &s, prog: p}
 }
 
+func (p *awkp) strnum(s string) *awkcell {
+	c := &awkcell{prog: p}
+	c.SetStrnum(s)
+	return c
+}
+
+// An awkcell holding only numval is a number and one holding only strval is
+// a string. Input that looks numeric holds both and is a numeric string.
+// A cell holding neither is uninitialized. In bignum mode, a number may also
+// hold its exact value in bigint or bigfloat.
 type awkcell struct {
 	prog       *awkp
 	numval     *float64
 	strval     *string
+	bigint     *big.Int
+	bigfloat   *big.Float
 	arrval     *awkmap
-	fnval      *awkfn
-	assignable bool
 	name       string
 	next       *awkcell
-	string     bool
 	regexp     bool
 	assignhook func() error
 }
@@ -2375,46 +2411,39 @@ type awkcell struct {
 func (c *awkcell) Num() float64 {
 	if c.numval != nil {
 		return *c.numval
-	}
-	if c.strval == nil || *c.strval == "" {
-		return 0
-	}
-	re := regexp.MustCompile(`^-?[0-9]+(?:\.[0-9]+)?`)
-	numstr := re.FindString(*c.strval)
-	numval, err := strconv.ParseFloat(numstr, 64)
-	if err != nil {
+	} else if c.strval == nil {
 		return 0
 	}
-	return numval
+	n, _ := awkparsenum(*c.strval)
+	return n
 }
 
 func (c *awkcell) IsString() bool {
-	if c.string {
-		return true
-	} else if c.numval != nil {
-		return false
-	} else if c.strval == nil {
-		return false
-	} else if _, err := strconv.ParseFloat(c.String(), 64); err == nil {
-		return false
-	} else {
-		return true
-	}
+	return c.strval != nil && c.numval == nil
 }
 
 func (c *awkcell) SetNum(n float64) {
 	c.numval = &n
 	c.strval = nil
+	c.bigint, c.bigfloat = nil, nil
+}
+
+// SetNumber sets c to the numeric value of o.
+func (c *awkcell) SetNumber(o *awkcell) {
+	c.numval, c.strval = o.numval, nil
+	c.bigint, c.bigfloat = o.bigint, o.bigfloat
 }
 
 func (c *awkcell) strconv(nconv string) string {
-	if c.strval != nil && *c.strval != "" {
+	if c.strval != nil {
 		return *c.strval
 	} else if c.numval == nil {
 		return ""
+	} else if c.bigint != nil {
+		return c.bigint.String()
 	}
 	format := c.prog.sym(nconv).String()
-	if _, frac := math.Modf(*c.numval); frac == 0 {
+	if _, frac := math.Modf(*c.numval); frac == 0 && c.bigfloat == nil {
 		format = "%.30g"
 	}
 	s, err := c.prog.sprintf(format, []*awkcell{c})
@@ -2435,6 +2464,7 @@ func (c *awkcell) OutputString() string {
 func (c *awkcell) SetString(s string) {
 	c.strval = &s
 	c.numval = nil
+	c.bigint, c.bigfloat = nil, nil
 }
 
 func (c *awkcell) AssignString(s string) error {
@@ -2442,8 +2472,27 @@ func (c *awkcell) AssignString(s string) error {
 	return c.AssignHook()
 }
 
+// SetStrnum sets c to s, which is numeric if it looks like a number.
+func (c *awkcell) SetStrnum(s string) {
+	c.strval = &s
+	c.numval = nil
+	c.bigint, c.bigfloat = nil, nil
+	if n, end := awkparsenum(s); end > 0 && strings.Trim(s[end:], awkblanks) == "" {
+		c.numval = &n
+	}
+}
+
+func (c *awkcell) AssignStrnum(s string) error {
+	c.SetStrnum(s)
+	return c.AssignHook()
+}
+
 func (c *awkcell) Bool() bool {
-	if c.numval != nil {
+	if c.bigint != nil {
+		return c.bigint.Sign() != 0
+	} else if c.bigfloat != nil {
+		return c.bigfloat.Sign() != 0
+	} else if c.numval != nil {
 		return c.Num() != 0
 	} else {
 		return c.String() != ""
@@ -2468,7 +2517,7 @@ func (c *awkcell) Arr() *awkmap {
 func (c *awkcell) Key(k string) *awkcell {
 	val := c.Arr().get(k)
 	if val == nil {
-		c.Arr().set(k, &awkcell{prog: c.prog, assignable: true})
+		c.Arr().set(k, &awkcell{prog: c.prog})
 	}
 	return c.Arr().get(k)
 }
@@ -2494,27 +2543,11 @@ func (c *awkcell) SetField(i int, o *awkcell) {
 	c.Key(strconv.Itoa(i)).Set(o)
 }
 
-func (c *awkcell) Fn() *awkfn {
-	if c.fnval == nil {
-		c.fnval = &awkfn{}
-	}
-	return c.fnval
-}
-
-func (c *awkcell) SetFn(f *awkfn) {
-	c.fnval = f
-}
-
 func (c *awkcell) Set(o *awkcell) {
-	if o.numval != nil {
-		c.SetNum(*o.numval)
-	} else {
-		c.SetString(o.String())
-	}
+	c.numval, c.strval = o.numval, o.strval
+	c.bigint, c.bigfloat = o.bigint, o.bigfloat
 	c.arrval = o.Arr()
-	c.fnval = o.Fn()
 	c.prog = o.prog
-	c.string = o.string
 	c.regexp = o.regexp
 }
 
@@ -2532,9 +2565,10 @@ type awkmap struct {
 }
 
 const (
-	awkmapinit = 50
-	awkmapfull = 2
-	awkmapgrow = 4
+	awkmapinit   = 50
+	awkmapfull   = 2 // Grow when the average chain is longer than this.
+	awkmapgrow   = 4
+	awkmapsparse = 8 // Shrink when there are this many buckets per element.
 )
 
 func (m *awkmap) get(key string) *awkcell {
@@ -2563,7 +2597,7 @@ func (m *awkmap) set(key string, val *awkcell) {
 	m.contents[hash] = val
 	m.count++
 	if m.count > m.size*awkmapfull {
-		m.rehash()
+		m.rehash(m.size * awkmapgrow)
 	}
 }
 
@@ -2577,14 +2611,17 @@ func (m *awkmap) del(key string) {
 		if c.name == key && prevc != nil {
 			prevc.next = c.next
 			m.count--
-			return
+			break
 		} else if c.name == key {
 			m.contents[hash] = c.next
 			m.count--
-			return
+			break
 		}
 		prevc = c
 	}
+	if m.size > awkmapinit && m.count < m.size/awkmapsparse {
+		m.rehash(max(m.size/awkmapgrow, awkmapinit))
+	}
 }
 
 func (m *awkmap) hash(s string) uint {
@@ -2595,8 +2632,8 @@ func (m *awkmap) hash(s string) uint {
 	return uint(val) % m.size
 }
 
-func (m *awkmap) rehash() {
-	m.size *= awkmapgrow
+func (m *awkmap) rehash(size uint) {
+	m.size = size
 	nc := make([]*awkcell, m.size)
 	for i := range m.contents {
 		for c := m.contents[i]; c != nil; {
@@ -2611,8 +2648,68 @@ func (m *awkmap) rehash() {
 	m.contents = nc
 }
 
+func (m *awkmap) cells() []*awkcell {
+	cells := make([]*awkcell, 0, m.count)
+	for _, c := range m.contents {
+		for ; c != nil; c = c.next {
+			cells = append(cells, c)
+		}
+	}
+	return cells
+}
+
+// reset empties m, keeping its buckets only if they are the initial ones.
 func (m *awkmap) reset() {
-	m.contents = nil
-	m.size = 0
+	if m.size == awkmapinit {
+		clear(m.contents)
+	} else {
+		m.contents = nil
+		m.size = 0
+	}
 	m.count = 0
 }
+
+// awkparsenum parses the longest prefix of s, after leading blanks, that is
+// a decimal number. Infinity and NaN are only recognized with a sign.
+// It returns the number and the length of the prefix, or 0 if there is none.
+func awkparsenum(s string) (float64, int) {
+	i := len(s) - len(strings.TrimLeft(s, awkblanks))
+	start := i
+	if i < len(s) && (s[i] == '+' || s[i] == '-') {
+		i++
+		for _, w := range []string{"infinity", "inf", "nan"} {
+			if len(s)-i < len(w) || !strings.EqualFold(s[i:i+len(w)], w) {
+				continue
+			} else if w == "nan" {
+				return math.NaN(), i + len(w)
+			} else if s[start] == '-' {
+				return math.Inf(-1), i + len(w)
+			}
+			return math.Inf(1), i + len(w)
+		}
+	}
+	var digits int
+	for ; i < len(s) && s[i] >= '0' && s[i] <= '9'; i++ {
+		digits++
+	}
+	if i < len(s) && s[i] == '.' {
+		for i++; i < len(s) && s[i] >= '0' && s[i] <= '9'; i++ {
+			digits++
+		}
+	}
+	if digits == 0 {
+		return 0, 0
+	}
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		j := i + 1
+		if j < len(s) && (s[j] == '+' || s[j] == '-') {
+			j++
+		}
+		if j < len(s) && s[j] >= '0' && s[j] <= '9' {
+			for i = j; i < len(s) && s[i] >= '0' && s[i] <= '9'; i++ {
+			}
+		}
+	}
+	n, _ := strconv.ParseFloat(s[start:i], 64)
+	return n, i
+}
@@ -0,0 +1,22 @@
+// Package awk compiles awk programs once and runs them from Go.
+package awk
+
+import "lesiw.io/buzzybox/hive"
+
+type (
+	// A Program is a compiled awk program.
+	// It may be run any number of times, including concurrently.
+	Program = hive.AwkProgram
+
+	// A Config configures a single run of a Program.
+	Config = hive.AwkConfig
+
+	// A Result holds the state of a Program after it has run.
+	Result = hive.AwkResult
+)
+
+// Compile compiles an awk program.
+// Errors describe the line and column at which compilation failed.
+func Compile(src string) (*Program, error) {
+	return hive.CompileAwk(src)
+}
@@ -0,0 +1,224 @@
+package awk_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"lesiw.io/buzzybox/hive/awk"
+)
+
+func TestCompileError(t *testing.T) {
+	_, err := awk.Compile("BEGIN {\n\tprint 1 +\n")
+	if err == nil {
+		t.Fatal("got nil error")
+	}
+	if want := "line 2: "; !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("got %q, want prefix %q", err, want)
+	}
+}
+
+func TestRun(t *testing.T) {
+	prog, err := awk.Compile(`
+		BEGIN { n = 0 }
+		{ sum += $2; seen[$1]++ }
+		END { print ARGV[1], pre, ENVIRON["HOME"], NR, sum; exit 3 }
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	res, err := prog.Run(context.Background(), awk.Config{
+		Stdin:  strings.NewReader("a 1\nb 2\na 3\n"),
+		Stdout: &out,
+		Vars:   map[string]string{"pre": "x"},
+		Args:   []string{"-"},
+		Env:    []string{"HOME=/nowhere"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "- x /nowhere 3 6\n"; got != want {
+		t.Errorf("stdout: got %q, want %q", got, want)
+	}
+	if res.ExitCode != 3 {
+		t.Errorf("exit code: got %d, want 3", res.ExitCode)
+	}
+	vars := map[string]any{
+		"n":     0.0,
+		"sum":   6.0,
+		"pre":   "x",
+		"seen":  map[string]any{"a": 2.0, "b": 1.0},
+		"unset": nil,
+	}
+	for name, want := range vars {
+		if got := res.Var(name); !reflect.DeepEqual(got, want) {
+			t.Errorf("Var(%q): got %#v, want %#v", name, got, want)
+		}
+	}
+}
+
+func TestRunFuncs(t *testing.T) {
+	prog, err := awk.Compile(`BEGIN {
+		print join("-", "a", 1, 2.5), twice(21), ok()
+		fail("boom")
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	_, err = prog.Run(context.Background(), awk.Config{
+		Stdout: &out,
+		Funcs: map[string]any{
+			"join": func(sep string, s ...string) string {
+				return strings.Join(s, sep)
+			},
+			"twice": func(n int) int { return 2 * n },
+			"ok":    func() bool { return true },
+			"fail":  func(s string) error { return errors.New(s) },
+		},
+	})
+	if got, want := out.String(), "a-1-2.5 42 1\n"; got != want {
+		t.Errorf("stdout: got %q, want %q", got, want)
+	}
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("err: got %v, want boom", err)
+	}
+}
+
+func TestRunFuncPanic(t *testing.T) {
+	prog, err := awk.Compile(`BEGIN { boom() }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = prog.Run(context.Background(), awk.Config{
+		Funcs: map[string]any{"boom": func() string { panic("oops") }},
+	})
+	if err == nil || !strings.Contains(err.Error(), "panic: oops") {
+		t.Errorf("err: got %v, want panic: oops", err)
+	}
+}
+
+func TestRunVarStrnum(t *testing.T) {
+	prog, err := awk.Compile(`{ n = $1; s = $2; split($0, f) }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := prog.Run(context.Background(), awk.Config{
+		Stdin: strings.NewReader("42 abc\n"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := map[string]any{
+		"n": 42.0,
+		"s": "abc",
+		"f": map[string]any{"1": 42.0, "2": "abc"},
+	}
+	for name, want := range vars {
+		if got := res.Var(name); !reflect.DeepEqual(got, want) {
+			t.Errorf("Var(%q): got %#v, want %#v", name, got, want)
+		}
+	}
+}
+
+func TestRunBignum(t *testing.T) {
+	prog, err := awk.Compile(`BEGIN { i = 2^70; f = 1/4; h = half(2^62 + 2) }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := prog.Run(context.Background(), awk.Config{
+		Bignum: true,
+		Funcs:  map[string]any{"half": func(n int64) int64 { return n / 2 }},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := res.Var("i").(*big.Int); !ok || got.Cmp(new(big.Int).Lsh(big.NewInt(1), 70)) != 0 {
+		t.Errorf("Var(\"i\"): got %#v, want 2^70", res.Var("i"))
+	}
+	if got, ok := res.Var("f").(*big.Float); !ok || got.Cmp(big.NewFloat(0.25)) != 0 {
+		t.Errorf("Var(\"f\"): got %#v, want 0.25", res.Var("f"))
+	}
+	if got, ok := res.Var("h").(*big.Int); !ok || got.Cmp(big.NewInt(1<<61+1)) != 0 {
+		t.Errorf("Var(\"h\"): got %#v, want 2^61+1", res.Var("h"))
+	}
+}
+
+func TestRunUndefinedFunc(t *testing.T) {
+	prog, err := awk.Compile(`BEGIN { print "unreachable"; nope() }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	_, err = prog.Run(context.Background(), awk.Config{Stdout: &out})
+	if err == nil || !strings.Contains(err.Error(), "bad function: nope") {
+		t.Errorf("err: got %v, want bad function", err)
+	}
+	if out.Len() > 0 {
+		t.Errorf("stdout: got %q, want empty", out.String())
+	}
+}
+
+func TestRunCanceled(t *testing.T) {
+	prog, err := awk.Compile(`BEGIN { while (1) {} }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := prog.Run(ctx, awk.Config{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("err: got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestRunParallel(t *testing.T) {
+	prog, err := awk.Compile(`NR == 1, NR == 2 { n++ } { total += $1 } END { print n, total }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 1; i <= 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var out strings.Builder
+			in := strings.Repeat(fmt.Sprintf("%d\n", i), i+2)
+			if _, err := prog.Run(context.Background(), awk.Config{
+				Stdin:  strings.NewReader(in),
+				Stdout: &out,
+			}); err != nil {
+				t.Error(err)
+			}
+			if got, want := out.String(), fmt.Sprintf("2 %d\n", i*(i+2)); got != want {
+				t.Errorf("run %d: got %q, want %q", i, got, want)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestRunClock(t *testing.T) {
+	prog, err := awk.Compile(`BEGIN { print systime(), strftime("%F %T") }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	if _, err := prog.Run(context.Background(), awk.Config{
+		Stdout: &out,
+		Env:    []string{"TZ=UTC"},
+		Gawk:   true,
+		Clock:  func() time.Time { return time.Unix(86400, 0) },
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "86400 1970-01-02 00:00:00\n"; got != want {
+		t.Errorf("stdout: got %q, want %q", got, want)
+	}
+}
@@ -2,13 +2,14 @@ package hive_test
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"lesiw.io/buzzybox/hive"
-	"lesiw.io/buzzybox/internal/posix"
 )
 
 type awkTest struct {
@@ -44,7 +45,6 @@ func (t *awkTest) err() string {
 
 func (t *awkTest) run() (stdout string, stderr string, dir string) {
 	var err error
-	posix.ResetRandom()
 
 	prog := t.prog()
 	if len(t.outfiles) > 0 {
@@ -92,6 +92,19 @@ func (t *awkTest) run() (stdout string, stderr string, dir string) {
 	return cmd.Stdout.(*strings.Builder).String(), cmd.Stderr.(*strings.Builder).String(), dir
 }
 
+// awkrun runs cmd with stdin and returns its output, failing t if cmd exits
+// with a non-zero status.
+func awkrun(t *testing.T, cmd *hive.Cmd, stdin string) string {
+	t.Helper()
+	cmd.Stdin = strings.NewReader(stdin)
+	cmd.Stdout = new(strings.Builder)
+	cmd.Stderr = new(strings.Builder)
+	if code := cmd.Run(); code != 0 {
+		t.Fatalf("exit status %d\nstderr\n---\n%s", code, cmd.Stderr)
+	}
+	return cmd.Stdout.(*strings.Builder).String()
+}
+
 func TestAwk(t *testing.T) {
 	files, err := os.ReadDir("testdata/awk")
 	if err != nil {
@@ -388,3 +401,1065 @@ func TestAwkEnvVars(t *testing.T) {
 		t.Fatalf("got %s, want %s", out, "bar")
 	}
 }
+
+func BenchmarkAwkRegex(b *testing.B) {
+	benchmarkAwk(b, `/ERROR/ { n++ } $2 ~ "^warn" { w++ }
+		{ gsub(/0/, "o"); m += match($0, "[0-9]+"); c += split($2, f, "[:]+") }
+		END { print n, w, m, c }`)
+}
+
+func BenchmarkAwkNum(b *testing.B) {
+	benchmarkAwk(b, `{ s += $3; t += substr($2, 6) } END { print s, t }`)
+}
+
+func BenchmarkAwkArray(b *testing.B) {
+	benchmarkAwk(b, `{ split($0, f); for (k in f) seen[f[k]]++; delete f; t[NR] = $1 }
+		NR % 100 == 0 { for (k in t) delete t[k] }
+		END { print length(seen) }`)
+}
+
+func benchmarkAwk(b *testing.B, prog string) {
+	var input strings.Builder
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&input, "%s warn:%d %d\n", []string{"INFO", "ERROR"}[i%2], i, i*7)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cmd := hive.Command("awk", prog)
+		cmd.Stdin = strings.NewReader(input.String())
+		cmd.Stdout = io.Discard
+		if code := cmd.Run(); code != 0 {
+			b.Fatalf("exit status %d", code)
+		}
+	}
+}
+
+func TestAwkRegex(t *testing.T) {
+	// Modeled on the one-true-awk regular expression tests: each expression
+	// must match every string in match and none in nomatch, both as a regex
+	// literal and as a dynamic regex read from data.
+	tests := []struct {
+		re      string
+		match   []string
+		nomatch []string
+	}{
+		{re: `x`, match: []string{"x", "xa", "ax"}, nomatch: []string{"y", ""}},
+		{re: `xy`, match: []string{"xy", "axyb"}, nomatch: []string{"x y", "yx"}},
+		{re: `^x`, match: []string{"x", "xy"}, nomatch: []string{"yx"}},
+		{re: `x$`, match: []string{"yx"}, nomatch: []string{"xy"}},
+		{re: `^$`, match: []string{""}, nomatch: []string{"x"}},
+		{re: `x*y`, match: []string{"y", "xy", "xxy"}, nomatch: []string{"x"}},
+		{re: `x+y`, match: []string{"xy", "xxy"}, nomatch: []string{"y"}},
+		{re: `x?y`, match: []string{"y", "xy"}, nomatch: []string{"x"}},
+		{re: `(ab)+c`, match: []string{"abc", "ababc"}, nomatch: []string{"ac", "bc"}},
+		{re: `a|b`, match: []string{"a", "b"}, nomatch: []string{"c"}},
+		{re: `^(a|b)*$`, match: []string{"", "abab"}, nomatch: []string{"abc"}},
+		{re: `[abc]`, match: []string{"a", "b"}, nomatch: []string{"d"}},
+		{re: `[^abc]`, match: []string{"d", "ad"}, nomatch: []string{"abc", ""}},
+		{re: `[a-c]x`, match: []string{"bx"}, nomatch: []string{"dx"}},
+		{re: `[]a]`, match: []string{"]", "a"}, nomatch: []string{"b"}},
+		{re: `[^]a]`, match: []string{"b"}, nomatch: []string{"]", "a", ""}},
+		{re: `[a-]`, match: []string{"-", "a"}, nomatch: []string{"b"}},
+		{re: `[\]]`, match: []string{"]"}, nomatch: []string{"a"}},
+		{re: `[[:alpha:]]+`, match: []string{"abc"}, nomatch: []string{"123"}},
+		{re: `^[[:digit:]]+$`, match: []string{"123"}, nomatch: []string{"12a"}},
+		{re: `[[:upper:][:digit:]]`, match: []string{"A", "1"}, nomatch: []string{"a"}},
+		{re: `[[.-.]]`, match: []string{"-"}, nomatch: []string{"a"}},
+		{re: `a{2}`, match: []string{"aa"}, nomatch: []string{"a"}},
+		{re: `^a{2,3}$`, match: []string{"aa", "aaa"}, nomatch: []string{"a", "aaaa"}},
+		{re: `^a{2,}$`, match: []string{"aa", "aaaa"}, nomatch: []string{"a"}},
+		{re: `^a{,2}b`, match: []string{"b", "aab"}, nomatch: []string{"aaab"}},
+		{re: `a{`, match: []string{"a{"}, nomatch: []string{"a"}},
+		{re: `a{x}`, match: []string{"a{x}"}, nomatch: []string{"ax"}},
+		{re: `^a**$`, match: []string{"", "aaa"}, nomatch: []string{"b"}},
+		{re: `*a`, match: []string{"*a"}, nomatch: []string{"a"}},
+		{re: `\.`, match: []string{"a.b"}, nomatch: []string{"ab"}},
+		{re: `a\/b`, match: []string{"a/b"}, nomatch: []string{"ab"}},
+		{re: `a\tb`, match: []string{"a\tb"}, nomatch: []string{"atb"}},
+		{re: `\101`, match: []string{"A"}, nomatch: []string{"B"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.re, func(t *testing.T) {
+			cmd := hive.Command("awk", `BEGIN { re = ENVIRON["RE"] }
+				{ print ($0 ~ /`+tt.re+`/) ($0 ~ re) }`)
+			cmd.Env = []string{"RE=" + tt.re}
+			in := strings.Join(append(tt.match, tt.nomatch...), "\n") + "\n"
+			want := strings.Repeat("11\n", len(tt.match)) +
+				strings.Repeat("00\n", len(tt.nomatch))
+			if got := awkrun(t, cmd, in); got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestAwkRegexMatch(t *testing.T) {
+	tests := []struct {
+		prog string
+		in   string
+		out  string
+	}{{
+		prog: `{ match($0, /a|ab/); print RSTART, RLENGTH }`,
+		in:   "xabc\n",
+		out:  "2 2\n",
+	}, {
+		prog: `{ match($0, "(a|ab)(c|bcd)"); print RSTART, RLENGTH }`,
+		in:   "abcd\n",
+		out:  "1 4\n",
+	}, {
+		prog: `{ gsub(/x*/, "-"); print }`,
+		in:   "abc\n",
+		out:  "-a-b-c-\n",
+	}, {
+		prog: `{ sub(/a+|a+b/, "<&>"); print }`,
+		in:   "aab\n",
+		out:  "<aab>\n",
+	}, {
+		prog: `{ sub(/b/, "[&][\\&][$1]"); print }`,
+		in:   "abc\n",
+		out:  "a[b][&][$1]c\n",
+	}, {
+		prog: `{ gsub(/o/, "0"); print $2 }`,
+		in:   "foo boo\n",
+		out:  "b00\n",
+	}, {
+		prog: `BEGIN { print ("a\nb" ~ /a.b/), ("x\ny" ~ /^y/), ("a\nb" ~ /a[^x]b/) }`,
+		out:  "1 0 1\n",
+	}, {
+		prog: `BEGIN { print ("a.b" ~ "a\.b"), ("axb" ~ "a\.b"), split("a.b.c", f, "\."),
+			("a/b" ~ "a\/b"), ("a+" ~ "a\\+") }`,
+		out: "1 0 3 1 1\n",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.prog, func(t *testing.T) {
+			cmd := hive.Command("awk", tt.prog)
+			if got := awkrun(t, cmd, tt.in); got != tt.out {
+				t.Errorf("got %q, want %q", got, tt.out)
+			}
+		})
+	}
+}
+
+func TestAwkRSLong(t *testing.T) {
+	tests := []struct {
+		name string
+		rs   string
+		in   string
+		out  string
+	}{{
+		name: "no match",
+		rs:   "[XY]+",
+		in:   strings.Repeat("a", 4<<20),
+		out:  "4194304 0\n",
+	}, {
+		name: "long record",
+		rs:   "[XY]+",
+		in:   strings.Repeat("a", 1<<20) + "XY" + "b",
+		out:  "1048576 2\n1 0\n",
+	}, {
+		name: "separator across reads",
+		rs:   "[XY]+",
+		in:   strings.Repeat("a", 4095) + "XXXX" + "b",
+		out:  "4095 4\n1 0\n",
+	}, {
+		name: "separator begins before read",
+		rs:   "ab+c",
+		in:   strings.Repeat("x", 4000) + "a" + strings.Repeat("b", 5000) + "cd",
+		out:  "4000 5002\n1 0\n",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := hive.Command("awk", "-v", "RS="+tt.rs, "{ print length($0), length(RT) }")
+			if got := awkrun(t, cmd, tt.in); got != tt.out {
+				t.Errorf("got %q, want %q", got, tt.out)
+			}
+		})
+	}
+}
+
+func TestAwkCompare(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  []string
+		in   string
+		out  string
+	}{{
+		name: "field strnum",
+		args: []string{"-F\t", `{ print ($1 < $2), ($1 == "10"), ($1 == 10) }`},
+		in:   " 10\t9\n",
+		out:  "0 0 1\n",
+	}, {
+		name: "field forms",
+		args: []string{`{ print ($1 == 1e1), ($2 == 0.5), ($3 == 5), ($4 < 2) }`},
+		in:   "1e1 .5 +5 0x1A\n",
+		out:  "1 1 1 1\n",
+	}, {
+		name: "trailing blanks",
+		args: []string{"-F,", `{ print ($1 == 10), ($2 == 10) }`},
+		in:   "10 ,10x\n",
+		out:  "1 0\n",
+	}, {
+		name: "string constant",
+		args: []string{`{ x = $1 ""; print (x < 9), ("10" < 9), (substr($1, 1) < 9) }`},
+		in:   "10\n",
+		out:  "1 1 1\n",
+	}, {
+		name: "uninitialized",
+		args: []string{`BEGIN { print (x == 0), (x == ""), length(x), x + 1 }`},
+		out:  "1 1 0 1\n",
+	}, {
+		name: "truth",
+		args: []string{`{ print ($1 ? "t" : "f"), ($2 ? "t" : "f"), ($1 "" ? "t" : "f") }`},
+		in:   "0 0.0\n",
+		out:  "f f t\n",
+	}, {
+		name: "getline var",
+		args: []string{`BEGIN { getline x; print (x < 9) }`},
+		in:   "10\n",
+		out:  "0\n",
+	}, {
+		name: "split elements",
+		args: []string{`BEGIN { split("10 abc", a); print (a[1] < 9), (a[2] < 9) }`},
+		out:  "0 0\n",
+	}, {
+		name: "argv",
+		args: []string{`BEGIN { print (ARGV[1] < 9) }`, "10"},
+		out:  "0\n",
+	}, {
+		name: "assignment",
+		args: []string{"-v", "x=10", `BEGIN { print (x < 9) }`},
+		out:  "0\n",
+	}, {
+		name: "environ",
+		args: []string{`BEGIN { print (ENVIRON["N"] < 9) }`},
+		env:  []string{"N=10"},
+		out:  "0\n",
+	}, {
+		name: "infinity",
+		args: []string{`{ print ($1 > 1000), ($2 + 0 > 1000) }`},
+		in:   "+inf inf\n",
+		out:  "1 0\n",
+	}, {
+		name: "modified field",
+		args: []string{`{ $2 = "10"; print ($2 < 9); $0 = "10 a"; print ($1 < 9) }`},
+		in:   "a b\n",
+		out:  "1\n0\n",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := hive.Command(append([]string{"awk"}, tt.args...)...)
+			cmd.Env = tt.env
+			if got := awkrun(t, cmd, tt.in); got != tt.out {
+				t.Errorf("got %q, want %q", got, tt.out)
+			}
+		})
+	}
+}
+
+func TestAwkSrand(t *testing.T) {
+	tests := []struct {
+		name string
+		prog string
+		out  string
+	}{{
+		name: "random(3)",
+		prog: `BEGIN { srand(1); printf "%.6f\n", rand() }`,
+		out:  "0.840188\n",
+	}, {
+		name: "reseed",
+		prog: `BEGIN { srand(1); a = rand(); rand(); srand(1); print (rand() == a) }`,
+		out:  "1\n",
+	}, {
+		name: "previous seed",
+		prog: `BEGIN { print srand(5), srand(3), srand() }`,
+		out:  "1 5 3\n",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := hive.Command("awk", tt.prog)
+			if got := awkrun(t, cmd, ""); got != tt.out {
+				t.Errorf("got %q, want %q", got, tt.out)
+			}
+		})
+	}
+}
+
+func TestAwkRandParallel(t *testing.T) {
+	run := func(prog string) string {
+		cmd := hive.Command("awk", prog)
+		cmd.Stdout = new(strings.Builder)
+		cmd.Stderr = new(strings.Builder)
+		if code := cmd.Run(); code != 0 {
+			t.Errorf("exit status %d\nstderr\n---\n%s", code,
+				cmd.Stderr.(*strings.Builder).String())
+		}
+		return cmd.Stdout.(*strings.Builder).String()
+	}
+	const prog = `BEGIN { srand(%d); for (i = 0; i < 100; i++) print rand() }`
+	want := make([]string, 8)
+	for i := range want {
+		want[i] = run(fmt.Sprintf(prog, i))
+	}
+	for i := range want {
+		i := i
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+			if got := run(fmt.Sprintf(prog, i)); got != want[i] {
+				t.Errorf("srand(%d): sequence differs when run in parallel", i)
+			}
+		})
+	}
+}
+
+type countWriter struct {
+	strings.Builder
+	writes int
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Builder.Write(p)
+}
+
+func TestAwkOutput(t *testing.T) {
+	tests := []struct {
+		name string
+		prog string
+		out  string
+	}{{
+		name: "system",
+		prog: `BEGIN { print "a"; system("echo b"); print "c" }`,
+		out:  "a\nb\nc\n",
+	}, {
+		name: "pipe",
+		prog: `BEGIN { print "a"; print "b" | "cat"; close("cat"); print "c" }`,
+		out:  "a\nb\nc\n",
+	}, {
+		name: "pipe at exit",
+		prog: `BEGIN { print "a"; print "b" | "cat"; print "c" }`,
+		out:  "a\nc\nb\n",
+	}, {
+		name: "getline",
+		prog: `BEGIN { print "a"; "echo b >&2; echo c" | getline x; print x }`,
+		out:  "a\nb\nc\n",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			cmd := hive.Command("awk", tt.prog)
+			cmd.Stdout = &out
+			cmd.Stderr = &out
+			if code := cmd.Run(); code != 0 {
+				t.Fatalf("exit status %d\n---\n%s", code, out.String())
+			}
+			if got := out.String(); got != tt.out {
+				t.Errorf("got %q, want %q", got, tt.out)
+			}
+		})
+	}
+}
+
+func TestAwkOutputBuffered(t *testing.T) {
+	w := new(countWriter)
+	cmd := hive.Command("awk", `BEGIN { for (i = 0; i < 1000; i++) print i }`)
+	cmd.Stdout = w
+	if code := cmd.Run(); code != 0 {
+		t.Fatalf("exit status %d", code)
+	}
+	if got := strings.Count(w.String(), "\n"); got != 1000 {
+		t.Errorf("got %d lines, want 1000", got)
+	}
+	if w.writes > 10 {
+		t.Errorf("got %d writes, want at most 10", w.writes)
+	}
+}
+
+func TestAwkClose(t *testing.T) {
+	tests := []struct {
+		name string
+		prog string
+		out  string
+	}{{
+		name: "output pipe",
+		prog: `BEGIN { print "a" | "cat"; print close("cat") }`,
+		out:  "a\n0\n",
+	}, {
+		name: "output pipe status",
+		prog: `BEGIN { print "a" | "exit 3"; print close("exit 3") }`,
+		out:  "3\n",
+	}, {
+		name: "input pipe",
+		prog: `BEGIN { c = "echo a; exit 2"; c | getline x; print x, close(c); c | getline y; print y }`,
+		out:  "a 2\na\n",
+	}, {
+		name: "not open",
+		prog: `BEGIN { print close("nope") }`,
+		out:  "-1\n",
+	}, {
+		name: "fflush",
+		prog: `BEGIN { print "a" | "cat"; r = fflush("cat") " " fflush("nope") " " fflush("") " " fflush()
+			close("cat"); print r }`,
+		out: "a\n0 -1 0 0\n",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := hive.Command("awk", tt.prog)
+			if got := awkrun(t, cmd, ""); got != tt.out {
+				t.Errorf("got %q, want %q", got, tt.out)
+			}
+		})
+	}
+}
+
+func TestAwkFflush(t *testing.T) {
+	w := new(countWriter)
+	cmd := hive.Command("awk", `BEGIN { print "a"; fflush(); print "b"; fflush(); print "c" }`)
+	cmd.Stdout = w
+	if code := cmd.Run(); code != 0 {
+		t.Fatalf("exit status %d", code)
+	}
+	if got, want := w.writes, 3; got != want {
+		t.Errorf("got %d writes, want %d", got, want)
+	}
+}
+
+func TestAwkSpecialFiles(t *testing.T) {
+	tests := []struct {
+		name  string
+		args  []string
+		stdin string
+		out   string
+		err   string
+	}{{
+		name: "stdout",
+		args: []string{`BEGIN { print "a" > "/dev/stdout"; print "b"; print "c" > "-"; print "d" > "/dev/fd/1" }`},
+		out:  "a\nb\nc\nd\n",
+	}, {
+		name: "stderr",
+		args: []string{`BEGIN { print "a" > "/dev/stderr"; close("/dev/stderr"); print "b" >> "/dev/fd/2" }`},
+		err:  "a\nb\n",
+	}, {
+		name:  "getline",
+		args:  []string{`BEGIN { getline a < "/dev/stdin"; getline b < "-"; getline c < "/dev/fd/0"; print a, b, c }`},
+		stdin: "x\ny\nz\n",
+		out:   "x y z\n",
+	}, {
+		name:  "operand",
+		args:  []string{`{ print FILENAME ": " $0 }`, "/dev/stdin"},
+		stdin: "x\n",
+		out:   "/dev/stdin: x\n",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := hive.Command(append([]string{"awk"}, tt.args...)...)
+			cmd.FS = memfs(t, nil)
+			if got := awkrun(t, cmd, tt.stdin); got != tt.out {
+				t.Errorf("stdout: got %q, want %q", got, tt.out)
+			}
+			if got := cmd.Stderr.(*strings.Builder).String(); got != tt.err {
+				t.Errorf("stderr: got %q, want %q", got, tt.err)
+			}
+		})
+	}
+}
+
+func TestAwkCSV(t *testing.T) {
+	tests := []struct {
+		name string
+		prog string
+		in   string
+		out  string
+	}{{
+		name: "fields",
+		prog: `{ print NF; for (i = 1; i <= NF; i++) print "<" $i ">" }`,
+		in:   "a,\"b,c\",\"say \"\"hi\"\"\",,\n",
+		out:  "5\n<a>\n<b,c>\n<say \"hi\">\n<>\n<>\n",
+	}, {
+		name: "multiline",
+		prog: `{ print NR ": " $2 }`,
+		in:   "1,\"two\nlines\"\n2,one\n",
+		out:  "1: two\nlines\n2: one\n",
+	}, {
+		name: "crlf",
+		prog: `{ print $2 "|" }`,
+		in:   "a,b\r\nc,\"d\r\ne\"\r\n",
+		out:  "b|\nd\r\ne|\n",
+	}, {
+		name: "inner quote",
+		prog: `{ print $1 "|" $2 }`,
+		in:   "a\"b,\"c\"d\n",
+		out:  "a\"b|cd\n",
+	}, {
+		name: "empty record",
+		prog: `{ print NF }`,
+		in:   "\n\"\"\n",
+		out:  "0\n1\n",
+	}, {
+		name: "split",
+		prog: `BEGIN { n = split("x,\"y,z\"", a); m = split("x,y", b, "y"); print n, a[2], m, b[1] }`,
+		out:  "2 y,z 2 x,\n",
+	}, {
+		name: "ignores FS",
+		prog: `BEGIN { FS = ";" } { print $2 }`,
+		in:   "a;b,c\n",
+		out:  "c\n",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := hive.Command("awk", "--csv", tt.prog)
+			if got := awkrun(t, cmd, tt.in); got != tt.out {
+				t.Errorf("got %q, want %q", got, tt.out)
+			}
+		})
+	}
+}
+
+func TestAwkFieldSplit(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		in   string
+		out  string
+	}{{
+		name: "widths",
+		args: []string{"--gawk", `BEGIN { FIELDWIDTHS = "3 2 4" } { print NF, $1, $2, $3 }`},
+		in:   "abcdefghij\n",
+		out:  "3 abc de fghi\n",
+	}, {
+		name: "widths skip",
+		args: []string{"--gawk", `BEGIN { FIELDWIDTHS = "2:3 1:*" } { print NF, $1, $2 }`},
+		in:   "xxabcyrest of it\n",
+		out:  "2 abc rest of it\n",
+	}, {
+		name: "widths short",
+		args: []string{"--gawk", `BEGIN { FIELDWIDTHS = "3 3 3" } { print NF, $1, $2 }`},
+		in:   "abcd\n",
+		out:  "2 abc d\n",
+	}, {
+		name: "widths rebuild",
+		args: []string{"--gawk", `BEGIN { FIELDWIDTHS = "2 2"; OFS = "-" } { $2 = "XY"; print; print NF }`},
+		in:   "abcd\n",
+		out:  "ab-XY\n2\n",
+	}, {
+		name: "pattern",
+		args: []string{"--gawk", `BEGIN { FPAT = "([^,]*)|(\"[^\"]+\")" } { print NF; for (i = 1; i <= NF; i++) print "<" $i ">" }`},
+		in:   "a,\"b,c\",,d\n",
+		out:  "4\n<a>\n<\"b,c\">\n<>\n<d>\n",
+	}, {
+		name: "pattern variable",
+		args: []string{"--gawk", "-v", "FPAT=[0-9]+", `{ print NF, $2 }`},
+		in:   "a1b22c333\n",
+		out:  "3 22\n",
+	}, {
+		name: "last assigned",
+		args: []string{"--gawk", `BEGIN { FPAT = "[a-z]+"; FS = "," } { print NF, $1; FIELDWIDTHS = "1"; $0 = $0; print NF, $1 }`},
+		in:   "x1,y2\n",
+		out:  "2 x1\n1 x\n",
+	}, {
+		name: "posix",
+		args: []string{`BEGIN { print "[" FPAT "]"; FIELDWIDTHS = "1"; FPAT = "[a-z]" } { print NF, $1 }`},
+		in:   "ab cd\n",
+		out:  "[]\n2 ab\n",
+	}, {
+		name: "posix variable",
+		args: []string{"-v", "FPAT=[a-z]", `{ print NF, $1 }`},
+		in:   "ab cd\n",
+		out:  "2 ab\n",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := hive.Command(append([]string{"awk"}, tt.args...)...)
+			if got := awkrun(t, cmd, tt.in); got != tt.out {
+				t.Errorf("got %q, want %q", got, tt.out)
+			}
+		})
+	}
+}
+
+func TestAwkFieldWidthsError(t *testing.T) {
+	cmd := hive.Command("awk", "--gawk", `BEGIN { FIELDWIDTHS = "2 x" } { print }`)
+	cmd.Stdin = strings.NewReader("abcd\n")
+	cmd.Stdout = new(strings.Builder)
+	cmd.Stderr = new(strings.Builder)
+	if code := cmd.Run(); code != 1 {
+		t.Errorf("exit status %d, want 1", code)
+	}
+	if got := cmd.Stderr.(*strings.Builder).String(); !strings.Contains(got, "bad FIELDWIDTHS") {
+		t.Errorf("stderr: got %q, want bad FIELDWIDTHS", got)
+	}
+}
+
+func TestAwkTime(t *testing.T) {
+	now := time.Date(2024, 3, 5, 14, 7, 9, 0, time.UTC)
+	tests := []struct {
+		name string
+		prog string
+		tz   string
+		out  string
+	}{{
+		name: "systime",
+		prog: `BEGIN { print systime() }`,
+		out:  "1709647629\n",
+	}, {
+		name: "default format",
+		prog: `BEGIN { print strftime() }`,
+		out:  "Tue Mar  5 14:07:09 UTC 2024\n",
+	}, {
+		name: "conversions",
+		prog: `BEGIN { print strftime("%A %B %C %d %D %F %g %G %h %I %j %k %l %m %M %p %r %R %s %S %T %u %U %V %w %W %x %X %y %z %%") }`,
+		out:  "Tuesday March 20 05 03/05/24 2024-03-05 24 2024 Mar 02 065 14  2 03 07 PM 02:07:09 PM 14:07 1709647629 09 14:07:09 2 09 10 2 10 03/05/24 14:07:09 24 +0000 %\n",
+	}, {
+		name: "locale",
+		prog: `BEGIN { print strftime("%c|%Ec|%Oy|%q|%") }`,
+		out:  "Tue Mar  5 14:07:09 2024|Tue Mar  5 14:07:09 2024|24|%q|%\n",
+	}, {
+		name: "week numbers",
+		prog: `BEGIN { print strftime("%U %W %V %G %g", mktime("2021 01 01 00 00 00")), strftime("%U %W %V %G %j", mktime("2024 12 30 00 00 00")) }`,
+		out:  "00 00 53 2020 20 52 53 01 2025 365\n",
+	}, {
+		name: "timestamp",
+		prog: `BEGIN { print strftime("%F %T", 0, 1) }`,
+		out:  "1970-01-01 00:00:00\n",
+	}, {
+		name: "mktime",
+		prog: `BEGIN { print mktime("2024 03 05 14 07 09"), mktime("2024 03 05 14 07 09 -1", 1) }`,
+		out:  "1709647629 1709647629\n",
+	}, {
+		name: "mktime normalizes",
+		prog: `BEGIN { print strftime("%F %T", mktime("2023 14 -1 25 61 0")) }`,
+		out:  "2024-01-31 02:01:00\n",
+	}, {
+		name: "mktime dst",
+		prog: `BEGIN { print mktime("2024 01 15 12 00 00 0") - mktime("2024 01 15 12 00 00 1"), mktime("2024 07 15 12 00 00 0") - mktime("2024 07 15 12 00 00") }`,
+		tz:   "America/New_York",
+		out:  "3600 3600\n",
+	}, {
+		name: "mktime dst without dst",
+		prog: `BEGIN { print mktime("2024 01 15 12 00 00 1") - mktime("2024 01 15 12 00 00 0") }`,
+		out:  "0\n",
+	}, {
+		name: "mktime bad",
+		prog: `BEGIN { print mktime("2024 03 05"), mktime("2024 03 05 a 0 0") }`,
+		out:  "-1 -1\n",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := hive.Command("awk", "--gawk", tt.prog)
+			cmd.Env = []string{"TZ=UTC"}
+			if tt.tz != "" {
+				cmd.Env = []string{"TZ=" + tt.tz}
+			}
+			cmd.Clock = func() time.Time { return now }
+			if got := awkrun(t, cmd, ""); got != tt.out {
+				t.Errorf("got %q, want %q", got, tt.out)
+			}
+		})
+	}
+}
+
+func TestAwkGawk(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		out  string
+	}{{
+		name: "variable",
+		args: []string{`BEGIN { systime = 5; print systime }`},
+		out:  "5\n",
+	}, {
+		name: "function",
+		args: []string{`function mktime(s) { return "t" s } BEGIN { print mktime(1) }`},
+		out:  "t1\n",
+	}, {
+		name: "function with gawk",
+		args: []string{"--gawk", `function strftime() { return "s" } BEGIN { print strftime() }`},
+		out:  "s\n",
+	}, {
+		name: "array functions",
+		args: []string{`function patsplit(s) { return "p" s } BEGIN { asort = 1; gensub = 2; print asort + gensub, patsplit(3) }`},
+		out:  "3 p3\n",
+	}, {
+		name: "bitwise",
+		args: []string{`BEGIN { or = 1; and = 3; print or, and }`},
+		out:  "1 3\n",
+	}, {
+		name: "bitwise function",
+		args: []string{`function compl(x) { return -x } BEGIN { xor = compl(2); print xor }`},
+		out:  "-2\n",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := hive.Command(append([]string{"awk"}, tt.args...)...)
+			if got := awkrun(t, cmd, ""); got != tt.out {
+				t.Errorf("got %q, want %q", got, tt.out)
+			}
+		})
+	}
+}
+
+func TestAwkGawkDisabled(t *testing.T) {
+	cmd := hive.Command("awk", `BEGIN { print systime() }`)
+	cmd.Stdout = new(strings.Builder)
+	cmd.Stderr = new(strings.Builder)
+	if code := cmd.Run(); code != 1 {
+		t.Errorf("exit status: got %d, want 1", code)
+	}
+	if got := cmd.Stderr.(*strings.Builder).String(); !strings.Contains(got, "bad function: systime") {
+		t.Errorf("stderr: got %q, want bad function: systime", got)
+	}
+}
+
+func TestAwkExtensions(t *testing.T) {
+	tests := []struct {
+		name string
+		prog string
+		in   string
+		out  string
+	}{{
+		name: "gensub backreferences",
+		prog: `{ print gensub(/([a-z]+)-([0-9]+)/, "\\2:\\1", "g") }`,
+		in:   "ab-12 cd-34\n",
+		out:  "12:ab 34:cd\n",
+	}, {
+		name: "gensub nth",
+		prog: `BEGIN { s = "a.b.c"; print gensub(/\./, "[&]", 2, s), s }`,
+		out:  "a.b[.]c a.b.c\n",
+	}, {
+		name: "gensub escapes",
+		prog: `BEGIN { print gensub("o", "\\0\\&\\\\", "G", "foo") }`,
+		out:  "fo&\\o&\\\n",
+	}, {
+		name: "gensub missing group",
+		prog: `BEGIN { print gensub(/(x)|y/, "<\\1\\5>", "g", "xy") }`,
+		out:  "<x><>\n",
+	}, {
+		name: "asort types",
+		prog: `{ a[NR] = $1 } END { n = asort(a); for (i = 1; i <= n; i++) printf "%s ", a[i]; print n }`,
+		in:   "b\n10\n9\na\n-1\n",
+		out:  "-1 9 10 a b 5\n",
+	}, {
+		name: "asort dest",
+		prog: `BEGIN { a["x"] = 3; a["y"] = 1; n = asort(a, b, "@val_num_desc"); print n, b[1], b[2], a["x"], length(a) }`,
+		out:  "2 3 1 3 2\n",
+	}, {
+		name: "asorti",
+		prog: `BEGIN { a["10"]; a["9"]; a["b"]; n = asorti(a, k); print n, k[1], k[2], k[3]; asorti(a, k, "@ind_num_asc"); print k[1], k[2], k[3] }`,
+		out:  "3 10 9 b\nb 9 10\n",
+	}, {
+		name: "patsplit",
+		prog: `BEGIN { n = patsplit("  ab, cd;e", a, /[a-z]+/, s); print n, a[1], a[2], a[3]; print "[" s[0] "][" s[1] "][" s[2] "]", (3 in s) }`,
+		out:  "3 ab cd e\n[  ][, ][;] 0\n",
+	}, {
+		name: "patsplit fpat",
+		prog: `{ print patsplit($0, a), a[2] }`,
+		in:   " x  y z \n",
+		out:  "3 y\n",
+	}, {
+		name: "length array",
+		prog: `BEGIN { print length(a); a[1]; a[2]; print length(a) }`,
+		out:  "0\n2\n",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := hive.Command("awk", "--gawk", tt.prog)
+			if got := awkrun(t, cmd, tt.in); got != tt.out {
+				t.Errorf("got %q, want %q", got, tt.out)
+			}
+		})
+	}
+}
+
+func TestAwkBitwise(t *testing.T) {
+	tests := []struct {
+		name string
+		prog string
+		out  string
+		code int
+	}{{
+		name: "and or xor",
+		prog: `BEGIN { print and(12, 10), and(15, 7, 6), or(1, 6), or(1, 2, 4), xor(5, 3) }`,
+		out:  "8 6 7 7 6\n",
+	}, {
+		name: "shift",
+		prog: `BEGIN { print lshift(1, 52), lshift(1, 53), rshift(1024, 3), rshift(1, 64) }`,
+		out:  "4503599627370496 0 128 0\n",
+	}, {
+		name: "compl",
+		prog: `BEGIN { print compl(0), compl(compl(42)) }`,
+		out:  "9007199254740991 42\n",
+	}, {
+		name: "truncate",
+		prog: `BEGIN { print and(7.9, "3.5") }`,
+		out:  "3\n",
+	}, {
+		name: "negative",
+		prog: `BEGIN { print and(-1, 1) }`,
+		code: 1,
+	}, {
+		name: "strtonum",
+		prog: `BEGIN { print strtonum("0x1F"), strtonum("0X1f"), strtonum("0755"), strtonum("018"), strtonum(" 12abc"), strtonum("0x"), strtonum(17) }`,
+		out:  "31 31 493 18 12 0 17\n",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := hive.Command("awk", "--gawk", tt.prog)
+			cmd.Stdout = new(strings.Builder)
+			cmd.Stderr = new(strings.Builder)
+			if code := cmd.Run(); code != tt.code {
+				t.Fatalf("exit status %d, want %d\nstderr\n---\n%s", code, tt.code, cmd.Stderr)
+			}
+			if got := cmd.Stdout.(*strings.Builder).String(); got != tt.out {
+				t.Errorf("got %q, want %q", got, tt.out)
+			}
+		})
+	}
+}
+
+func TestAwkBignum(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		in   string
+		out  string
+	}{{
+		name: "sum",
+		args: []string{"-M", `{ s += $1 } END { print s, s - 1, s * s }`},
+		in:   "18446744073709551615\n18446744073709551615\n",
+		out:  "36893488147419103230 36893488147419103229 1361129467683753853705924477137396432900\n",
+	}, {
+		name: "float sum",
+		args: []string{`{ s += $1 } END { print (s == 36893488147419103230) }`},
+		in:   "18446744073709551615\n18446744073709551616\n",
+		out:  "1\n",
+	}, {
+		name: "compare",
+		args: []string{"-M", `{ print ($1 < $2), ($1 == $2), ($1 + 0 == 9007199254740993) }`},
+		in:   "9007199254740993 9007199254740992\n",
+		out:  "0 0 1\n",
+	}, {
+		name: "operators",
+		args: []string{"-M", `BEGIN { x = 2^64; x++; y = x; y %= 1000; print x, y, -x, 2^100 % 7, 6/3, 7/2, int(-7.5), 2^(-2), 10 ^ 20 }`},
+		out:  "18446744073709551617 617 -18446744073709551617 2 2 3.5 -7 0.25 100000000000000000000\n",
+	}, {
+		name: "printf",
+		args: []string{"-M", `BEGIN { printf "%d %x %u %5.2f %.3e %g %s\n", 2^70, 2^64, 3^40, 1/3, 2^70, 0.1, 2^70 }`},
+		out:  "1180591620717411303424 10000000000000000 12157665459056928801  0.33 1.181e+21 0.1 1180591620717411303424\n",
+	}, {
+		name: "prec",
+		args: []string{"-M", `BEGIN { PREC = 100; CONVFMT = "%.25f"; x = 1/3 ""; PREC = "double"; y = 1/3 ""; print x; print y }`},
+		out:  "0.3333333333333333333333333\n0.3333333333333333148296163\n",
+	}, {
+		name: "roundmode",
+		args: []string{"-M", `BEGIN { PREC = 4; print 17/16 * 16; ROUNDMODE = "U"; print 17/16 * 16 }`},
+		out:  "16\n18\n",
+	}, {
+		name: "convfmt",
+		args: []string{"-M", `BEGIN { CONVFMT = "%.2f"; x = 2^80; y = 1/8; print x "", y "" }`},
+		out:  "1208925819614629174706176 0.12\n",
+	}, {
+		name: "variables",
+		args: []string{"-M", `BEGIN { print PREC, ROUNDMODE }`},
+		out:  "53 N\n",
+	}, {
+		name: "posix variables",
+		args: []string{`BEGIN { PREC++; print PREC, "[" ROUNDMODE "]" }`},
+		out:  "1 []\n",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := hive.Command(append([]string{"awk"}, tt.args...)...)
+			if got := awkrun(t, cmd, tt.in); got != tt.out {
+				t.Errorf("got %q, want %q", got, tt.out)
+			}
+		})
+	}
+}
+
+func TestAwkPrintf(t *testing.T) {
+	tests := []struct {
+		format string
+		args   string
+		out    string
+	}{
+		{`%*d`, `5, 42`, "   42"},
+		{`%-*d|`, `5, 42`, "42   |"},
+		{`%*d`, `-5, 42`, "42   "},
+		{`%.*f`, `2, 3.14159`, "3.14"},
+		{`%*.*f`, `8, 3, 3.14159`, "   3.142"},
+		{`%.*d`, `-1, 7`, "7"},
+		{`%+d % d %+d`, `5, 5, -5`, "+5  5 -5"},
+		{`%05d`, `-42`, "-0042"},
+		{`%-05d|`, `42`, "42   |"},
+		{`%.3d`, `7`, "007"},
+		{`%08.3d`, `7`, "     007"},
+		{`%.0d|`, `0`, "|"},
+		{`%i`, `-12`, "-12"},
+		{`%d %d`, `"3.9abc", ""`, "3 0"},
+		{`%d`, `1e30`, "1000000000000000019884624838656"},
+		{`%d %5.1f`, `-log(0), log(0)`, "inf  -inf"},
+		{`%u %x %o`, `-1, -1, 8`, "18446744073709551615 ffffffffffffffff 10"},
+		{`%#o %#x %#X %#x`, `8, 255, 255, 0`, "010 0xff 0XFF 0"},
+		{`%lu %ld %hd`, `3, 4, 5`, "3 4 5"},
+		{`%+.3e`, `"12345.678"`, "+1.235e+04"},
+		{`%E`, `0.000123`, "1.230000E-04"},
+		{`%#.0f %#.0e`, `3, 3`, "3. 3.e+00"},
+		{`%g %g %g %g`, `100000, 1000000, 0.0001, 0.00001`, "100000 1e+06 0.0001 1e-05"},
+		{`%#g %#.3g`, `1.5, 100`, "1.50000 100."},
+		{`%G %.0g`, `1e-10, 123`, "1E-10 1e+02"},
+		{`%.2f`, `-0.001`, "-0.00"},
+		{`% 08.2f`, `3.14159`, " 0003.14"},
+		{`%+08.2e`, `-3.14159`, "-3.14e+00"},
+		{`%a %A %.2a`, `1.5, -0.25, 1`, "0x1.8p+0 -0X1P-2 0x1.00p+0"},
+		{`%5.1f%%`, `99.44`, " 99.4%"},
+		{`%5%|`, ``, "%|"},
+		{`%10.4s|`, `"abcdefg"`, "      abcd|"},
+		{`%-4s|%.2s|`, `"é", "héllo"`, "é   |hé|"},
+		{`%c%c%c`, `42, 227, 9786`, "*ã☺"},
+		{`%c%c|%3c`, `"ébc", "", "x"`, "é|  x"},
+		{`%c`, `"65" + 0`, "A"},
+		{`%s %s`, `"a", "b", "extra"`, "a b"},
+		{`100%`, ``, "100%"},
+	}
+	for _, tt := range tests {
+		prog := fmt.Sprintf("BEGIN { printf %q%s }", tt.format, strings.TrimRight(", "+tt.args, ", "))
+		t.Run(tt.format, func(t *testing.T) {
+			cmd := hive.Command("awk", prog)
+			if got := awkrun(t, cmd, ""); got != tt.out {
+				t.Errorf("%s: got %q, want %q", prog, got, tt.out)
+			}
+		})
+	}
+}
+
+func TestAwkPrintfError(t *testing.T) {
+	tests := []struct {
+		prog string
+		err  string
+	}{
+		{`BEGIN { printf "%d %d", 1 }`, "bad argc: not enough arguments"},
+		{`BEGIN { printf "%*d", 1 }`, "bad argc: not enough arguments"},
+		{`BEGIN { printf "%k", 1 }`, "bad verb: %k"},
+		{`BEGIN { x = sprintf() }`, "bad argc"},
+	}
+	for _, tt := range tests {
+		cmd := hive.Command("awk", tt.prog)
+		cmd.Stdout = new(strings.Builder)
+		cmd.Stderr = new(strings.Builder)
+		if code := cmd.Run(); code != 1 {
+			t.Errorf("%s: exit status %d, want 1", tt.prog, code)
+		}
+		if got := cmd.Stderr.(*strings.Builder).String(); !strings.Contains(got, tt.err) {
+			t.Errorf("%s: stderr: got %q, want %q", tt.prog, got, tt.err)
+		}
+	}
+}
+
+func TestAwkSortedIn(t *testing.T) {
+	const data = `BEGIN { a["x"] = "ccc"; a["y"] = "a"; a["z"] = "bb"; a[10] = 5; a[9] = 50 }`
+	tests := []struct {
+		name  string
+		prog  string
+		out   string
+		posix bool
+	}{{
+		name: "index string",
+		prog: `BEGIN { PROCINFO["sorted_in"] = "@ind_str_asc"; for (k in a) printf "%s ", k }`,
+		out:  "10 9 x y z ",
+	}, {
+		name: "index number",
+		prog: `BEGIN { PROCINFO["sorted_in"] = "@ind_num_desc"; for (k in a) printf "%s ", k }`,
+		out:  "10 9 z y x ",
+	}, {
+		name: "value type",
+		prog: `BEGIN { PROCINFO["sorted_in"] = "@val_type_asc"; for (k in a) printf "%s ", a[k] }`,
+		out:  "5 50 a bb ccc ",
+	}, {
+		name: "value string",
+		prog: `BEGIN { PROCINFO["sorted_in"] = "@val_str_desc"; for (k in a) printf "%s ", a[k] }`,
+		out:  "ccc bb a 50 5 ",
+	}, {
+		name: "user function",
+		prog: `function bylen(i1, v1, i2, v2) { return length(v1) - length(v2) }
+			BEGIN { PROCINFO["sorted_in"] = "bylen"; for (k in a) printf "%s ", k }`,
+		out: "10 y 9 z x ",
+	}, {
+		name: "asort function",
+		prog: `function rev(i1, v1, i2, v2) { return v1 < v2 ? 1 : v1 > v2 ? -1 : 0 }
+			BEGIN { n = asort(a, b, "rev"); printf "%s %s ", b[1], b[n] }`,
+		out: "ccc 5 ",
+	}, {
+		name: "unsorted",
+		prog: `BEGIN { PROCINFO["sorted_in"] = "@ind_num_asc"; PROCINFO["sorted_in"] = "@unsorted"; for (k in a) n++; printf "%d ", n }`,
+		out:  "5 ",
+	}, {
+		name:  "posix",
+		prog:  `BEGIN { PROCINFO["sorted_in"] = "nope"; for (k in a) n++; printf "%d ", n }`,
+		out:   "5 ",
+		posix: true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := hive.Command("awk", "--gawk", data+"\n"+tt.prog)
+			if tt.posix {
+				cmd = hive.Command("awk", data+"\n"+tt.prog)
+			}
+			if got := awkrun(t, cmd, ""); got != tt.out {
+				t.Errorf("got %q, want %q", got, tt.out)
+			}
+		})
+	}
+}
+
+func TestAwkSortedInError(t *testing.T) {
+	cmd := hive.Command("awk", "--gawk", `BEGIN { a[1]; PROCINFO["sorted_in"] = "nope"; for (k in a) print k }`)
+	cmd.Stdout = new(strings.Builder)
+	cmd.Stderr = new(strings.Builder)
+	if code := cmd.Run(); code != 1 {
+		t.Errorf("exit status %d, want 1", code)
+	}
+	if got := cmd.Stderr.(*strings.Builder).String(); !strings.Contains(got, "bad sort order: nope") {
+		t.Errorf("stderr: got %q, want bad sort order", got)
+	}
+}
+
+func TestAwkDelete(t *testing.T) {
+	tests := []struct {
+		name string
+		prog string
+		out  string
+	}{{
+		name: "array",
+		prog: `BEGIN { a[1]; a[2]; delete a; print length(a), (1 in a); a[3]; print length(a) }`,
+		out:  "0 0\n1\n",
+	}, {
+		name: "parameter",
+		prog: `function clear(arr) { delete arr } BEGIN { a[1]; clear(a); print length(a) }`,
+		out:  "0\n",
+	}, {
+		name: "split empty",
+		prog: `BEGIN { split("x y z", a); print split("", a), length(a) }`,
+		out:  "0 0\n",
+	}, {
+		name: "element",
+		prog: `BEGIN { a[1]; a[2]; delete a[1]; print length(a), (1 in a), (2 in a) }`,
+		out:  "1 0 1\n",
+	}, {
+		name: "shrink",
+		prog: `BEGIN { for (i = 0; i < 10000; i++) a[i] = i; for (i = 0; i < 9990; i++) delete a[i];
+			for (k in a) s += a[k]; print length(a), s }`,
+		out: "10 99945\n",
+	}, {
+		name: "delete while looping",
+		prog: `BEGIN { for (i = 0; i < 1000; i++) a[i]; for (k in a) { delete a[k]; n++ }; print n, length(a) }`,
+		out:  "1000 0\n",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := hive.Command("awk", tt.prog)
+			if got := awkrun(t, cmd, ""); got != tt.out {
+				t.Errorf("got %q, want %q", got, tt.out)
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package hive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An AwkProgram is a compiled awk program.
+// It may be run any number of times, including concurrently.
+type AwkProgram struct {
+	prog *awkprog
+}
+
+// AwkConfig configures a single run of an AwkProgram.
+type AwkConfig struct {
+	Stdin  io.Reader // Defaults to an empty reader.
+	Stdout io.Writer // Defaults to io.Discard.
+	Stderr io.Writer // Defaults to io.Discard.
+	FS     FS        // Defaults to OSFS.
+
+	// Vars are assigned before the BEGIN actions, like awk -v.
+	Vars map[string]string
+	// Args are the operands, available to the program as ARGV[1] onward.
+	Args []string
+	// Env is the environment, in the form "key=value".
+	// If Env is nil, the environment of the current process is used.
+	Env []string
+	// CSV reads records and fields as CSV, like awk --csv.
+	CSV bool
+	// Gawk enables the gawk extension functions, like awk --gawk.
+	Gawk bool
+	// Bignum does arithmetic with arbitrary precision, like awk -M.
+	Bignum bool
+	// Clock is used by systime, strftime and srand. Defaults to time.Now.
+	Clock func() time.Time
+
+	// Funcs are Go functions callable from the program by name.
+	// Parameters and results may be strings, bools, integers or floats.
+	// A function may also return an error, alone or after its result.
+	// A panic in a function is returned as an error.
+	Funcs map[string]any
+}
+
+// An AwkResult holds the state of a program after it has run.
+type AwkResult struct {
+	ExitCode int
+	p        *awkp
+}
+
+var awkerrtype = reflect.TypeOf((*error)(nil)).Elem()
+
+// CompileAwk compiles an awk program.
+func CompileAwk(src string) (*AwkProgram, error) {
+	prog, err := newawkprog(src)
+	if err != nil {
+		return nil, awkerror(err)
+	}
+	return &AwkProgram{prog}, nil
+}
+
+// Run runs the program until it exits or ctx is done.
+func (prog *AwkProgram) Run(ctx context.Context, cfg AwkConfig) (*AwkResult, error) {
+	cmd := CommandContext(ctx, "awk")
+	cmd.Stdin = cfg.Stdin
+	if cmd.Stdin == nil {
+		cmd.Stdin = strings.NewReader("")
+	}
+	cmd.Stdout = cfg.Stdout
+	if cmd.Stdout == nil {
+		cmd.Stdout = io.Discard
+	}
+	cmd.Stderr = cfg.Stderr
+	if cmd.Stderr == nil {
+		cmd.Stderr = io.Discard
+	}
+	if cfg.FS != nil {
+		cmd.FS = cfg.FS
+	}
+	cmd.Env = cfg.Env
+	cmd.Clock = cfg.Clock
+	p := newawkp(cmd)
+	p.csv = cfg.CSV
+	p.gawk = cfg.Gawk
+	p.bignum = cfg.Bignum
+	p.extend()
+	p.gofuncs = make(map[string]awkbuiltin)
+	for name, fn := range cfg.Funcs {
+		f, err := awkgofunc(fn)
+		if err != nil {
+			return nil, fmt.Errorf("bad function: %s: %w", name, err)
+		}
+		p.gofuncs[name] = f
+	}
+	if err := p.load(prog.prog); err != nil {
+		return nil, awkerror(err)
+	}
+	p.sym("ARGC").SetNum(float64(len(cfg.Args) + 1))
+	p.sym("ARGV").SetKey("0", p.strnum("awk"))
+	for i, a := range cfg.Args {
+		p.sym("ARGV").SetKey(strconv.Itoa(i+1), p.strnum(a))
+	}
+	for name, val := range cfg.Vars {
+		p.setvar(name, val)
+	}
+	var err error
+	cmd.fn = func(*Cmd) (code int) {
+		code, err = p.exec()
+		return
+	}
+	cmd.Run()
+	if ctxerr := ctx.Err(); ctxerr != nil && (err != nil || cmd.ExitCode != 0) {
+		return nil, ctxerr
+	} else if err != nil {
+		return nil, awkerror(err)
+	}
+	return &AwkResult{ExitCode: cmd.ExitCode, p: p}, nil
+}
+
+// Var returns the final value of a global variable: a float64 for a number
+// or numeric string, a string for a string, a map[string]any for an array,
+// or nil if unset. With Bignum, an exact number is a *big.Int if it is an
+// integer and a *big.Float otherwise.
+func (r *AwkResult) Var(name string) any {
+	c, ok := r.p.symbols[name]
+	if !ok {
+		return nil
+	}
+	return awkgovalue(c)
+}
+
+func awkgovalue(c *awkcell) any {
+	switch {
+	case c.bigint != nil:
+		return new(big.Int).Set(c.bigint)
+	case c.bigfloat != nil:
+		return new(big.Float).Copy(c.bigfloat)
+	case c.numval != nil:
+		return *c.numval
+	case c.strval != nil:
+		return *c.strval
+	case c.arrval != nil && c.arrval.count > 0:
+		m := make(map[string]any, c.arrval.count)
+		for _, e := range c.arrval.contents {
+			for ; e != nil; e = e.next {
+				m[e.name] = awkgovalue(e)
+			}
+		}
+		return m
+	}
+	return nil
+}
+
+// awkerror converts a compile or runtime error to one that describes
+// where in the program it occurred.
+func awkerror(err error) error {
+	if pe, ok := err.(prettyError); ok {
+		return errors.New(pe.Pretty())
+	}
+	return err
+}
+
+func awkgofunc(fn any) (awkbuiltin, error) {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func {
+		return nil, fmt.Errorf("not a function: %T", fn)
+	}
+	t := v.Type()
+	fixed := t.NumIn()
+	if t.IsVariadic() {
+		fixed--
+		if !awkgotype(t.In(fixed).Elem()) {
+			return nil, fmt.Errorf("bad parameter type: %s", t.In(fixed))
+		}
+	}
+	for i := 0; i < fixed; i++ {
+		if !awkgotype(t.In(i)) {
+			return nil, fmt.Errorf("bad parameter type: %s", t.In(i))
+		}
+	}
+	switch {
+	case t.NumOut() == 0:
+	case t.NumOut() == 1 && (awkgotype(t.Out(0)) || t.Out(0) == awkerrtype):
+	case t.NumOut() == 2 && awkgotype(t.Out(0)) && t.Out(1) == awkerrtype:
+	default:
+		return nil, fmt.Errorf("bad result type: %s", t)
+	}
+	return func(p *awkp, args []*awkcell) (val *awkcell, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				val, err = nil, fmt.Errorf("panic: %v", r)
+			}
+		}()
+		if len(args) > fixed && !t.IsVariadic() {
+			return nil, fmt.Errorf("bad argc: want %d, got %d", fixed, len(args))
+		}
+		in := make([]reflect.Value, max(fixed, len(args)))
+		for i := range in {
+			switch {
+			case i >= len(args):
+				in[i] = reflect.Zero(t.In(i))
+			case i >= fixed:
+				in[i] = awkgoarg(args[i], t.In(fixed).Elem())
+			default:
+				in[i] = awkgoarg(args[i], t.In(i))
+			}
+		}
+		out := v.Call(in)
+		if len(out) > 0 && out[len(out)-1].Type() == awkerrtype {
+			if err, _ := out[len(out)-1].Interface().(error); err != nil {
+				return nil, err
+			}
+			out = out[:len(out)-1]
+		}
+		if len(out) == 0 {
+			return &awkcell{prog: p}, nil
+		}
+		switch r := out[0]; r.Kind() {
+		case reflect.String:
+			return p.string(r.String()), nil
+		case reflect.Bool:
+			return p.bool(r.Bool()), nil
+		case reflect.Float32, reflect.Float64:
+			return p.num(r.Float()), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if p.bignum {
+				return p.bigcell(new(big.Int).SetUint64(r.Uint()), nil), nil
+			}
+			return p.num(float64(r.Uint())), nil
+		default:
+			if p.bignum {
+				return p.bigcell(big.NewInt(r.Int()), nil), nil
+			}
+			return p.num(float64(r.Int())), nil
+		}
+	}, nil
+}
+
+func awkgotype(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+func awkgoarg(c *awkcell, t reflect.Type) reflect.Value {
+	switch t.Kind() {
+	case reflect.String:
+		return reflect.ValueOf(c.String()).Convert(t)
+	case reflect.Bool:
+		return reflect.ValueOf(c.Bool()).Convert(t)
+	case reflect.Float32, reflect.Float64:
+		return reflect.ValueOf(c.Num()).Convert(t)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if c.bigint != nil && c.bigint.IsUint64() {
+			return reflect.ValueOf(c.bigint.Uint64()).Convert(t)
+		}
+		return reflect.ValueOf(uint64(c.Num())).Convert(t)
+	default:
+		if c.bigint != nil && c.bigint.IsInt64() {
+			return reflect.ValueOf(c.bigint.Int64()).Convert(t)
+		}
+		return reflect.ValueOf(int64(c.Num())).Convert(t)
+	}
+}
@@ -0,0 +1,239 @@
+package hive
+
+import (
+	"cmp"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// In bignum mode (awk -M), integers are exact and other numbers have PREC
+// bits of precision, rounded as ROUNDMODE says. A number cell holds its exact
+// value in bigint or bigfloat, and an approximation in numval for the
+// builtins that need no more precision than a float64.
+
+var awkprecs = map[string]uint{
+	"half":   11,
+	"single": 24,
+	"double": 53,
+	"quad":   113,
+	"oct":    237,
+}
+
+var awkroundmodes = map[string]big.RoundingMode{
+	"N": big.ToNearestEven,
+	"Z": big.ToZero,
+	"U": big.ToPositiveInf,
+	"D": big.ToNegativeInf,
+	"A": big.AwayFromZero,
+}
+
+// newfloat returns a zero float with the precision and rounding mode set by
+// PREC and ROUNDMODE.
+func (p *awkp) newfloat() *big.Float {
+	prec, ok := awkprecs[strings.ToLower(p.sym("PREC").String())]
+	if !ok {
+		prec = uint(min(max(p.sym("PREC").Num(), 1), big.MaxPrec))
+	}
+	mode, ok := awkroundmodes[strings.ToUpper(p.sym("ROUNDMODE").String())]
+	if !ok {
+		mode = big.ToNearestEven
+	}
+	return new(big.Float).SetPrec(prec).SetMode(mode)
+}
+
+// bigcell returns a number holding i, or f if i is nil.
+// A float with an integral value is stored as an integer.
+// If both are nil, the number is NaN.
+func (p *awkp) bigcell(i *big.Int, f *big.Float) *awkcell {
+	if i == nil && f != nil && f.IsInt() {
+		i, _ = f.Int(nil)
+	}
+	var n float64
+	switch {
+	case i != nil:
+		n, _ = new(big.Float).SetInt(i).Float64()
+		return &awkcell{numval: &n, bigint: i, prog: p}
+	case f != nil:
+		n, _ = f.Float64()
+		return &awkcell{numval: &n, bigfloat: f, prog: p}
+	}
+	return p.num(math.NaN())
+}
+
+// bignumber returns the number written as the literal s.
+func (p *awkp) bignumber(s string) *awkcell {
+	return p.bigcell(p.bigparse(s))
+}
+
+// bigval returns the exact numeric value of c: an integer if it is integral,
+// a float if it is not, or neither if it is NaN.
+func (p *awkp) bigval(c *awkcell) (*big.Int, *big.Float) {
+	switch {
+	case c.bigint != nil:
+		return c.bigint, nil
+	case c.bigfloat != nil:
+		return nil, c.bigfloat
+	case c.strval != nil:
+		return p.bigparse(*c.strval)
+	case c.numval != nil:
+		return bigfromfloat(*c.numval)
+	}
+	return new(big.Int), nil
+}
+
+// bigparse parses the leading number in s, like awkparsenum.
+func (p *awkp) bigparse(s string) (*big.Int, *big.Float) {
+	n, end := awkparsenum(s)
+	if end == 0 {
+		return new(big.Int), nil
+	} else if math.IsNaN(n) || math.IsInf(n, 0) {
+		return bigfromfloat(n)
+	}
+	lit := strings.TrimLeft(s[:end], awkblanks)
+	if i, ok := new(big.Int).SetString(lit, 10); ok {
+		return i, nil
+	}
+	f, _, err := p.newfloat().Parse(lit, 10)
+	if err != nil {
+		return bigfromfloat(n)
+	} else if f.IsInt() {
+		i, _ := f.Int(nil)
+		return i, nil
+	}
+	return nil, f
+}
+
+func bigfromfloat(n float64) (*big.Int, *big.Float) {
+	if math.IsNaN(n) {
+		return nil, nil
+	}
+	f := new(big.Float).SetFloat64(n)
+	if f.IsInt() {
+		i, _ := f.Int(nil)
+		return i, nil
+	}
+	return nil, f
+}
+
+// bigfloatval returns c as a float in bignum mode, or nil if it is NaN.
+func (p *awkp) bigfloatval(c *awkcell) *big.Float {
+	if !p.bignum {
+		return nil
+	}
+	i, f := p.bigval(c)
+	if i != nil {
+		return new(big.Float).SetInt(i)
+	}
+	return f
+}
+
+// bigtrunc returns c truncated to an integer in bignum mode,
+// or nil if it is not finite.
+func (p *awkp) bigtrunc(c *awkcell) *big.Int {
+	if !p.bignum {
+		return nil
+	}
+	i, f := p.bigval(c)
+	if i == nil && f != nil && !f.IsInf() {
+		i, _ = f.Int(nil)
+	}
+	return i
+}
+
+func (p *awkp) bigcmp(l, r *awkcell) int {
+	li, lf := p.bigval(l)
+	ri, rf := p.bigval(r)
+	switch {
+	case (li == nil && lf == nil) || (ri == nil && rf == nil):
+		return cmp.Compare(l.Num(), r.Num())
+	case li != nil && ri != nil:
+		return li.Cmp(ri)
+	}
+	return p.bigfloatval(l).Cmp(p.bigfloatval(r))
+}
+
+func (p *awkp) bigarith(op string, l, r *awkcell) (val *awkcell, err error) {
+	li, lf := p.bigval(l)
+	ri, rf := p.bigval(r)
+	if (li == nil && lf == nil) || (ri == nil && rf == nil) {
+		return p.floatarith(op, l.Num(), r.Num())
+	}
+	if li != nil && ri != nil {
+		switch {
+		case op == "+":
+			return p.bigcell(new(big.Int).Add(li, ri), nil), nil
+		case op == "-":
+			return p.bigcell(new(big.Int).Sub(li, ri), nil), nil
+		case op == "*":
+			return p.bigcell(new(big.Int).Mul(li, ri), nil), nil
+		case (op == "/" || op == "%") && ri.Sign() == 0:
+			return nil, errDivisor
+		case op == "%":
+			return p.bigcell(new(big.Int).Rem(li, ri), nil), nil
+		case op == "/":
+			q, m := new(big.Int).QuoRem(li, ri, new(big.Int))
+			if m.Sign() == 0 {
+				return p.bigcell(q, nil), nil
+			}
+		case ri.Sign() >= 0: // "^", "**"
+			return p.bigcell(new(big.Int).Exp(li, ri, nil), nil), nil
+		}
+	}
+	x, y := p.bigfloatval(l), p.bigfloatval(r)
+	defer func() {
+		// Operations such as Inf-Inf have no result; NaN cannot be a big.Float.
+		if r := recover(); r != nil {
+			if _, ok := r.(big.ErrNaN); !ok {
+				panic(r)
+			}
+			val, err = p.num(math.NaN()), nil
+		}
+	}()
+	z := p.newfloat()
+	switch op {
+	case "+":
+		z.Add(x, y)
+	case "-":
+		z.Sub(x, y)
+	case "*":
+		z.Mul(x, y)
+	case "/":
+		if y.Sign() == 0 {
+			return nil, errDivisor
+		}
+		z.Quo(x, y)
+	case "%":
+		if y.Sign() == 0 {
+			return nil, errDivisor
+		} else if x.IsInf() || y.IsInf() {
+			return p.floatarith(op, l.Num(), r.Num())
+		}
+		q, _ := p.newfloat().Quo(x, y).Int(nil)
+		z.Sub(x, p.newfloat().Mul(new(big.Float).SetInt(q), y))
+	default: // "^", "**"
+		if ri == nil {
+			// Fractional powers have no more than float64 precision.
+			return p.bigcell(bigfromfloat(math.Pow(l.Num(), r.Num()))), nil
+		}
+		z = p.bigpow(x, ri)
+	}
+	return p.bigcell(nil, z), nil
+}
+
+// bigpow returns x to the power n by repeated squaring.
+func (p *awkp) bigpow(x *big.Float, n *big.Int) *big.Float {
+	z := p.newfloat().SetInt64(1)
+	b := p.newfloat().Set(x)
+	e := new(big.Int).Abs(n)
+	for i := 0; i < e.BitLen(); i++ {
+		if e.Bit(i) == 1 {
+			z.Mul(z, b)
+		}
+		b.Mul(b, b)
+	}
+	if n.Sign() < 0 {
+		z.Quo(p.newfloat().SetInt64(1), z)
+	}
+	return z
+}
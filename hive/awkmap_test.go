@@ -0,0 +1,100 @@
+package hive
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestAwkmapShrink(t *testing.T) {
+	var m awkmap
+	for i := 0; i < 10000; i++ {
+		m.set(strconv.Itoa(i), &awkcell{})
+	}
+	grown := m.size
+	for i := 0; i < 9990; i++ {
+		m.del(strconv.Itoa(i))
+	}
+	if m.count != 10 || m.size != awkmapinit {
+		t.Errorf("got count %d, size %d (from %d), want count 10, size %d",
+			m.count, m.size, grown, awkmapinit)
+	}
+	for i := 9990; i < 10000; i++ {
+		if m.get(strconv.Itoa(i)) == nil {
+			t.Errorf("lost key %d", i)
+		}
+	}
+}
+
+type awkgomap map[string]*awkcell
+
+func (m awkgomap) get(key string) *awkcell      { return m[key] }
+func (m awkgomap) set(key string, val *awkcell) { m[key] = val }
+func (m awkgomap) del(key string)               { delete(m, key) }
+func (m awkgomap) reset()                       { clear(m) }
+
+type awkmapper interface {
+	get(string) *awkcell
+	set(string, *awkcell)
+	del(string)
+	reset()
+}
+
+// BenchmarkAwkmap compares awkmap with a Go map on the access patterns of
+// typical awk programs.
+func BenchmarkAwkmap(b *testing.B) {
+	keys := make([]string, 100000)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	impls := []struct {
+		name   string
+		newmap func() awkmapper
+	}{
+		{"awkmap", func() awkmapper { return &awkmap{} }},
+		{"gomap", func() awkmapper { return awkgomap{} }},
+	}
+	for _, impl := range impls {
+		b.Run("count/"+impl.name, func(b *testing.B) {
+			// seen[$1]++ over many records with few distinct keys.
+			for i := 0; i < b.N; i++ {
+				m := impl.newmap()
+				for j := range keys {
+					k := keys[j%16]
+					if c := m.get(k); c == nil {
+						m.set(k, &awkcell{})
+					}
+				}
+			}
+		})
+		b.Run("insert/"+impl.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				m := impl.newmap()
+				for _, k := range keys {
+					m.set(k, &awkcell{})
+				}
+			}
+		})
+		b.Run("churn/"+impl.name, func(b *testing.B) {
+			// Fill a large table, then delete from it.
+			for i := 0; i < b.N; i++ {
+				m := impl.newmap()
+				for _, k := range keys {
+					m.set(k, &awkcell{})
+				}
+				for _, k := range keys {
+					m.del(k)
+				}
+			}
+		})
+		b.Run("scratch/"+impl.name, func(b *testing.B) {
+			// split($0, f) or delete f for every record.
+			m := impl.newmap()
+			for i := 0; i < b.N; i++ {
+				for _, k := range keys[:10] {
+					m.set(k, &awkcell{})
+				}
+				m.reset()
+			}
+		})
+	}
+}
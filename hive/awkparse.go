@@ -0,0 +1,969 @@
+package hive
+
+import (
+	"regexp"
+	"strconv"
+)
+
+// An awkprog is a compiled awk program.
+// It is never modified after compilation, so it may be shared between runs.
+type awkprog struct {
+	lexer       *lexer
+	begins      []*awkblock
+	ends        []*awkblock
+	items       []*awkitem
+	funcs       map[string]*awkfn
+	globals     []string   // Global variable names, indexed by awkvar.global.
+	extern      []*awkcall // Calls to functions not defined by the program.
+	globalindex map[string]int
+}
+
+type awkparser struct {
+	prog   *awkprog
+	lexer  *lexer
+	tokens []*token
+	pos    int
+
+	fntok  []*token
+	params []*token
+	calls  []*awkcall
+}
+
+type awknode interface{}
+
+type (
+	awkitem struct {
+		token   *token
+		pattern []awknode
+		body    *awkblock
+		index   int // Index of the item in awkprog.items.
+	}
+	awkfn struct {
+		name   *token
+		params []*token
+		body   *awkblock
+	}
+	awkblock struct {
+		stmts []awknode
+	}
+	awkprint struct {
+		token *token
+		args  []awknode
+		redir *token
+		dest  awknode
+	}
+	awkif struct {
+		cond awknode
+		body awknode
+		els  awknode
+	}
+	awkwhile struct {
+		cond awknode
+		body awknode
+	}
+	awkdo struct {
+		body awknode
+		cond awknode
+	}
+	awkfor struct {
+		init awknode
+		cond awknode
+		post awknode
+		body awknode
+	}
+	awkforin struct {
+		token *token
+		name  *awkvar
+		arr   *awkvar
+		body  awknode
+	}
+	awkdelete struct {
+		arr   *awkvar
+		index []awknode
+	}
+	awkjump struct {
+		err *tokenError
+	}
+	awkexit struct {
+		err *tokenError
+		val awknode
+	}
+	awkreturn struct {
+		err *tokenError
+		val awknode
+	}
+	awknum struct {
+		val float64
+		lit string
+	}
+	awkstr struct {
+		val string
+	}
+	awkregex struct {
+		token    *token
+		re       *regexp.Regexp
+		implicit bool
+	}
+	awkvar struct {
+		name   string
+		global int
+		local  int
+	}
+	awkindex struct {
+		arr   *awkvar
+		index []awknode
+	}
+	awkfield struct {
+		index awknode
+	}
+	awkgrouplist struct {
+		token *token
+		list  []awknode
+	}
+	awkassign struct {
+		op  *token
+		lhs awknode
+		rhs awknode
+	}
+	awkcond struct {
+		cond awknode
+		t    awknode
+		f    awknode
+	}
+	awkbinary struct {
+		op *token
+		l  awknode
+		r  awknode
+	}
+	awkmatch struct {
+		op     *token
+		negate bool
+		l      awknode
+		r      awknode
+		re     *regexp.Regexp
+	}
+	awkin struct {
+		index []awknode
+		arr   awknode
+	}
+	awkunary struct {
+		op *token
+		e  awknode
+	}
+	awkincdec struct {
+		op     *token
+		prefix bool
+		e      awknode
+	}
+	awkcall struct {
+		token *token
+		fn    *awkfn
+		args  []awknode
+	}
+	awkbuiltincall struct {
+		token *token
+		fn    awkbuiltin
+		args  []awknode
+	}
+	awkgetline struct {
+		token *token
+		kind  string
+		src   awknode
+		set   *awkvar
+	}
+)
+
+var (
+	awkerefn        = stringset("gensub", "gsub", "match", "patsplit", "split", "sub")
+	awkstopstmt     = stringset(";", "\n")
+	awkstopexpr     = stringset("}", ";", ",", "\n", ")")
+	awkstopexprlist = stringset("{", "}", ";", "\n", ")")
+	awkstopprint    = stringset("}", ";", ",", "\n", ">", ">>", "|")
+	awkendstmt      = stringset("", "{", "}", "\n", ";", "(", ")")
+	awkassignops    = []string{"=", "-=", "+=", "*=", "/=", "%=", "^=", "**="}
+)
+
+func newawkprog(src string) (*awkprog, error) {
+	p := &awkprog{
+		lexer:       newawklexer(),
+		funcs:       make(map[string]*awkfn),
+		globalindex: make(map[string]int),
+	}
+	tokens, err := p.lexer.lex(src)
+	if err != nil {
+		return nil, err
+	}
+	return p, p.compile(tokens)
+}
+
+func (p *awkprog) compile(tokens []*token) error {
+	a := &awkparser{prog: p, lexer: p.lexer, tokens: tokens}
+	for {
+		switch tok := a.next(); tok.kind {
+		case "\n", ";":
+			continue
+		case "":
+			return a.resolve()
+		case "begin", "end":
+			if err := a.mustmatch("{"); err != nil {
+				return err
+			}
+			body, err := a.block()
+			if err != nil {
+				return err
+			}
+			if tok.kind == "begin" {
+				p.begins = append(p.begins, body)
+			} else {
+				p.ends = append(p.ends, body)
+			}
+		case "function":
+			if err := a.function(); err != nil {
+				return err
+			}
+		default:
+			a.pos--
+			if err := a.item(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (a *awkparser) resolve() error {
+	for _, c := range a.calls {
+		if c.fn = a.prog.funcs[c.token.name]; c.fn == nil {
+			a.prog.extern = append(a.prog.extern, c)
+		}
+	}
+	return nil
+}
+
+func (a *awkparser) function() (err error) {
+	name := a.peek(0)
+	if name.kind != "func_name" && name.kind != "name" {
+		return a.lexer.newTokenErrorf(name, "bad function name")
+	}
+	a.next()
+	fn := &awkfn{name: name}
+	if fn.params, err = a.toklistp(); err != nil {
+		return
+	}
+	if err = a.mustmatch("{"); err != nil {
+		return
+	}
+	a.params = fn.params
+	defer func() { a.params = nil }()
+	if fn.body, err = a.block(); err != nil {
+		return
+	}
+	a.prog.funcs[name.name] = fn
+	return
+}
+
+func (a *awkparser) toklistp() (vals []*token, err error) {
+	if err = a.mustmatch("("); err != nil {
+		return
+	}
+	for {
+		switch a.next().kind {
+		case "name":
+			for _, v := range vals {
+				if v.name == a.peek(-1).name {
+					return nil, a.lexer.newTokenErrorf(a.peek(-1), "bad parameter")
+				}
+			}
+			vals = append(vals, a.peek(-1))
+			if !a.match(",") && a.peek(0).kind != ")" {
+				return nil, a.lexer.newTokenError(a.peek(0))
+			}
+		case ")":
+			return
+		default:
+			return nil, a.lexer.newTokenError(a.peek(-1))
+		}
+	}
+}
+
+func (a *awkparser) item() (err error) {
+	item := &awkitem{token: a.peek(0)}
+	if !a.match("{") {
+		if item.pattern, err = a.exprlist(awkstopexprlist); err != nil {
+			return
+		}
+		if len(item.pattern) == 0 || len(item.pattern) > 2 {
+			return a.lexer.newTokenErrorf(item.token, "bad exprlist: want 1-2, got %d",
+				len(item.pattern))
+		}
+		if !a.match("{") {
+			if !awkendstmt[a.peek(0).kind] {
+				return a.lexer.newTokenError(a.peek(0))
+			}
+			item.index = len(a.prog.items)
+			a.prog.items = append(a.prog.items, item)
+			return
+		}
+	}
+	item.body, err = a.block()
+	item.index = len(a.prog.items)
+	a.prog.items = append(a.prog.items, item)
+	return
+}
+
+func (a *awkparser) block() (*awkblock, error) {
+	b := &awkblock{}
+	for {
+		if a.match("}") {
+			return b, nil
+		} else if a.matchnewlines() {
+			continue
+		}
+		s, err := a.stmt(awkstopstmt)
+		if err != nil {
+			return nil, err
+		}
+		if s != nil {
+			b.stmts = append(b.stmts, s)
+		}
+	}
+}
+
+func (a *awkparser) stmt(stop strset) (s awknode, err error) {
+	a.matchnewlines()
+	tok := a.peek(0)
+	switch tok.kind {
+	case "":
+		return nil, a.lexer.newTokenError(tok)
+	case "{":
+		a.next()
+		s, err = a.block()
+	case "break", "continue", "next", "nextfile":
+		a.next()
+		s = &awkjump{a.lexer.newJumpError(tok)}
+	case "delete":
+		a.next()
+		s, err = a.deletestmt()
+	case "do":
+		a.next()
+		s, err = a.dostmt(stop)
+	case "exit":
+		a.next()
+		n := &awkexit{err: a.lexer.newJumpError(tok)}
+		n.val, err = a.expropt(stop)
+		s = n
+	case "for":
+		a.next()
+		s, err = a.forstmt()
+	case "if":
+		a.next()
+		s, err = a.ifstmt(stop)
+	case "print", "printf":
+		a.next()
+		s, err = a.printstmt(tok)
+	case "return":
+		a.next()
+		n := &awkreturn{err: a.lexer.newJumpError(tok)}
+		n.val, err = a.expropt(stop)
+		s = n
+	case "while":
+		a.next()
+		s, err = a.whilestmt(stop)
+	default:
+		if awkstopstmt[tok.kind] {
+			break // Empty statement.
+		}
+		s, err = a.expr(awkstopexpr)
+	}
+	if err != nil {
+		return
+	}
+	if !a.matchstmtdelim() {
+		err = a.lexer.newTokenError(a.peek(0))
+	}
+	return
+}
+
+func (a *awkparser) expropt(stop strset) (awknode, error) {
+	switch a.peek(0).kind {
+	case "", "\n", ";", "}":
+		return nil, nil
+	}
+	return a.expr(stop)
+}
+
+func (a *awkparser) deletestmt() (s awknode, err error) {
+	if err = a.mustmatch("name"); err != nil {
+		return
+	}
+	n := &awkdelete{arr: a.variable(a.peek(-1))}
+	if !a.match("[") {
+		return n, nil // Delete the whole array.
+	}
+	if n.index, err = a.exprlist(awkstopexpr); err != nil {
+		return
+	}
+	return n, a.mustmatch("]")
+}
+
+func (a *awkparser) dostmt(stop strset) (s awknode, err error) {
+	n := &awkdo{}
+	if n.body, err = a.stmt(stop); err != nil {
+		return
+	}
+	if err = a.mustmatch("while"); err != nil {
+		return
+	}
+	n.cond, err = a.exprp(awkstopexpr)
+	return n, err
+}
+
+func (a *awkparser) forstmt() (s awknode, err error) {
+	if a.match("(", "name", "in", "name", ")") {
+		n := &awkforin{token: a.peek(-2), name: a.variable(a.peek(-4)), arr: a.variable(a.peek(-2))}
+		n.body, err = a.stmt(awkstopstmt)
+		return n, err
+	}
+	n := &awkfor{}
+	if err = a.mustmatch("("); err != nil {
+		return
+	}
+	if !a.matchfordelim() {
+		if n.init, err = a.stmt(awkstopexpr); err != nil {
+			return
+		}
+		a.matchnewlines()
+	}
+	if !a.matchfordelim() {
+		if n.cond, err = a.expr(awkstopexpr); err != nil {
+			return
+		}
+		if !a.matchfordelim() {
+			return nil, a.lexer.newTokenError(a.peek(0))
+		}
+	}
+	if !a.match(")") {
+		if n.post, err = a.stmt(awkstopexpr); err != nil {
+			return
+		}
+		a.matchnewlines()
+		if err = a.mustmatch(")"); err != nil {
+			return
+		}
+	}
+	n.body, err = a.stmt(awkstopstmt)
+	return n, err
+}
+
+func (a *awkparser) ifstmt(stop strset) (s awknode, err error) {
+	n := &awkif{}
+	if n.cond, err = a.exprp(stop); err != nil {
+		return
+	}
+	if n.body, err = a.stmt(awkstopstmt); err != nil {
+		return
+	}
+	if !a.match("else") {
+		return n, nil
+	} else if a.match("if") {
+		n.els, err = a.ifstmt(awkstopexpr)
+	} else {
+		n.els, err = a.stmt(awkstopstmt)
+	}
+	return n, err
+}
+
+func (a *awkparser) whilestmt(stop strset) (s awknode, err error) {
+	n := &awkwhile{}
+	if n.cond, err = a.exprp(awkstopexpr); err != nil {
+		return
+	}
+	n.body, err = a.stmt(stop)
+	return n, err
+}
+
+func (a *awkparser) printstmt(tok *token) (s awknode, err error) {
+	n := &awkprint{token: tok}
+	if n.args, err = a.exprlist(awkstopprint); err != nil {
+		return
+	}
+	if len(n.args) == 1 {
+		if g, ok := n.args[0].(*awkgrouplist); ok {
+			n.args = g.list
+		}
+	}
+	if a.matchany(">", ">>", "|") {
+		n.redir = a.peek(-1)
+		n.dest, err = a.expr(awkstopexpr)
+	}
+	return n, err
+}
+
+func (a *awkparser) expr(stop strset) (e awknode, err error) {
+	if e, err = a.assign(stop); err != nil {
+		return
+	}
+	if stop["|"] || !a.match("|") {
+		return
+	}
+	tok := a.peek(-1)
+	if !a.match("getline") {
+		return nil, a.lexer.newTokenErrorf(tok, "bad pipe")
+	}
+	n := &awkgetline{token: tok, kind: "|", src: e}
+	if a.match("name") {
+		n.set = a.variable(a.peek(-1))
+	}
+	return n, nil
+}
+
+func (a *awkparser) exprp(stop strset) (e awknode, err error) {
+	if err = a.mustmatch("("); err != nil {
+		return
+	}
+	if e, err = a.expr(stop); err != nil {
+		return
+	}
+	return e, a.mustmatch(")")
+}
+
+func (a *awkparser) exprlist(stop strset) (list []awknode, err error) {
+	for !stop[a.peek(0).kind] {
+		var e awknode
+		if e, err = a.expr(stop); err != nil {
+			return
+		}
+		list = append(list, e)
+		if !a.match(",") {
+			return
+		}
+		a.matchnewlines()
+	}
+	return
+}
+
+func (a *awkparser) assign(stop strset) (e awknode, err error) {
+	if e, err = a.cond(stop); err != nil {
+		return
+	}
+	if !a.matchany(awkassignops...) {
+		return
+	}
+	n := &awkassign{op: a.peek(-1), lhs: e}
+	if !awklvalue(e) {
+		return nil, a.lexer.newTokenErrorf(n.op, "bad variable")
+	}
+	n.rhs, err = a.assign(stop) // Right associative.
+	return n, err
+}
+
+func awklvalue(e awknode) bool {
+	switch e.(type) {
+	case *awkvar, *awkindex, *awkfield:
+		return true
+	}
+	return false
+}
+
+func (a *awkparser) cond(stop strset) (e awknode, err error) {
+	if e, err = a.or(stop); err != nil {
+		return
+	}
+	if !a.match("?") {
+		return
+	}
+	n := &awkcond{cond: e}
+	if n.t, err = a.expr(stop); err != nil {
+		return
+	}
+	if err = a.mustmatch(":"); err != nil {
+		return
+	}
+	n.f, err = a.expr(stop)
+	return n, err
+}
+
+func (a *awkparser) or(stop strset) (e awknode, err error) {
+	if e, err = a.and(stop); err != nil {
+		return
+	}
+	for a.match("||") {
+		n := &awkbinary{op: a.peek(-1), l: e}
+		if n.r, err = a.and(stop); err != nil { // Left associative.
+			return
+		}
+		e = n
+	}
+	return
+}
+
+func (a *awkparser) and(stop strset) (e awknode, err error) {
+	if e, err = a.inarray(stop); err != nil {
+		return
+	}
+	for a.match("&&") {
+		n := &awkbinary{op: a.peek(-1), l: e}
+		if n.r, err = a.inarray(stop); err != nil { // Left associative.
+			return
+		}
+		e = n
+	}
+	return
+}
+
+func (a *awkparser) inarray(stop strset) (e awknode, err error) {
+	if e, err = a.ere(stop); err != nil || !a.match("in") {
+		return
+	}
+	n := &awkin{index: []awknode{e}}
+	if g, ok := e.(*awkgrouplist); ok {
+		n.index = g.list
+	}
+	n.arr, err = a.ere(stop)
+	return n, err
+}
+
+func (a *awkparser) ere(stop strset) (e awknode, err error) {
+	if e, err = a.cmp(stop); err != nil {
+		return
+	}
+	for {
+		n := &awkmatch{l: e}
+		if a.match("~") {
+			n.op = a.peek(-1)
+		} else if a.match("!", "~") {
+			n.op = a.peek(-1)
+			n.negate = true
+		} else {
+			return
+		}
+		if a.peek(0).kind == "ere" {
+			tok := a.next()
+			if n.re, err = awkcompile(tok.name); err != nil {
+				return nil, a.lexer.newTokenErrorf(tok, "bad regex: %s", err)
+			}
+		} else if n.r, err = a.cmp(stop); err != nil {
+			return
+		}
+		e = n
+	}
+}
+
+func (a *awkparser) cmp(stop strset) (e awknode, err error) {
+	if e, err = a.concat(stop); err != nil {
+		return
+	}
+	for !stop[a.peek(0).kind] && a.matchany("<", "<=", "==", "!=", ">=", ">") {
+		n := &awkbinary{op: a.peek(-1), l: e}
+		if n.r, err = a.concat(stop); err != nil {
+			return
+		}
+		e = n
+	}
+	return
+}
+
+func (a *awkparser) concat(stop strset) (e awknode, err error) {
+	if e, err = a.add(stop); err != nil {
+		return
+	}
+	for {
+		pos := a.pos
+		r, rerr := a.add(stop)
+		if rerr != nil {
+			a.pos = pos
+			return // Invalid expression; nothing to concatenate.
+		}
+		e = &awkbinary{op: &token{kind: "concat"}, l: e, r: r} // Left associative.
+	}
+}
+
+func (a *awkparser) add(stop strset) (e awknode, err error) {
+	if e, err = a.multiply(stop); err != nil {
+		return
+	}
+	for a.matchany("+", "-") {
+		n := &awkbinary{op: a.peek(-1), l: e}
+		if n.r, err = a.multiply(stop); err != nil {
+			return
+		}
+		e = n
+	}
+	return
+}
+
+func (a *awkparser) multiply(stop strset) (e awknode, err error) {
+	if e, err = a.unary(stop); err != nil {
+		return
+	}
+	for a.matchany("*", "/", "%") {
+		n := &awkbinary{op: a.peek(-1), l: e}
+		if n.r, err = a.unary(stop); err != nil {
+			return
+		}
+		e = n
+	}
+	return
+}
+
+func (a *awkparser) unary(stop strset) (e awknode, err error) {
+	if !a.matchany("-", "+", "!") {
+		return a.exp(stop)
+	}
+	n := &awkunary{op: a.peek(-1)}
+	n.e, err = a.unary(stop) // Right associative.
+	return n, err
+}
+
+func (a *awkparser) exp(stop strset) (e awknode, err error) {
+	if e, err = a.prefixop(stop); err != nil {
+		return
+	}
+	if !a.matchany("^", "**") {
+		return
+	}
+	n := &awkbinary{op: a.peek(-1), l: e}
+	n.r, err = a.exp(stop) // Right associative.
+	return n, err
+}
+
+func (a *awkparser) prefixop(stop strset) (e awknode, err error) {
+	if !a.matchany("++", "--") {
+		return a.postfixop(stop)
+	}
+	n := &awkincdec{op: a.peek(-1), prefix: true}
+	if n.e, err = a.postfixop(stop); err != nil {
+		return
+	}
+	if !awklvalue(n.e) {
+		return nil, a.lexer.newTokenErrorf(n.op, "bad variable")
+	}
+	return n, nil
+}
+
+func (a *awkparser) postfixop(stop strset) (e awknode, err error) {
+	if e, err = a.fieldref(stop); err != nil || !a.matchany("++", "--") {
+		return
+	}
+	n := &awkincdec{op: a.peek(-1), e: e}
+	if !awklvalue(e) {
+		return nil, a.lexer.newTokenErrorf(n.op, "bad variable")
+	}
+	return n, nil
+}
+
+func (a *awkparser) fieldref(stop strset) (e awknode, err error) {
+	if !a.match("$") {
+		return a.group(stop)
+	}
+	n := &awkfield{}
+	n.index, err = a.group(stop)
+	return n, err
+}
+
+func (a *awkparser) group(stop strset) (e awknode, err error) {
+	if !a.match("(") {
+		return a.val()
+	}
+	tok := a.peek(-1)
+	var list []awknode
+	if list, err = a.exprlist(awkstopexprlist); err != nil {
+		return
+	}
+	if err = a.mustmatch(")"); err != nil {
+		return
+	}
+	switch {
+	case len(list) == 0:
+		return nil, a.lexer.newTokenError(a.peek(-1))
+	case len(list) == 1:
+		return list[0], nil
+	case a.peek(0).kind == "in" || awkstopprint[a.peek(0).kind]:
+		// A grouping such as (a, b) is only valid before "in" or as print arguments.
+		return &awkgrouplist{token: tok, list: list}, nil
+	default:
+		return nil, a.lexer.newTokenError(a.peek(0))
+	}
+}
+
+func (a *awkparser) val() (e awknode, err error) {
+	tok := a.next()
+	switch tok.kind {
+	case "number":
+		num, err := strconv.ParseFloat(tok.name, 64)
+		if err != nil {
+			return nil, a.lexer.newTokenErrorf(tok, "bad number")
+		}
+		return &awknum{num, tok.name}, nil
+	case "name":
+		return a.symval(tok)
+	case "string":
+		return &awkstr{awkunescape(tok.name)}, nil
+	case "ere":
+		n := &awkregex{token: tok, implicit: a.ereimplicit()}
+		if n.re, err = awkcompile(tok.name); err != nil {
+			return nil, a.lexer.newTokenErrorf(tok, "bad regex: %s", err)
+		}
+		return n, nil
+	case "builtin_func":
+		a.fntok = append(a.fntok, tok)
+		defer func() { a.fntok = a.fntok[:len(a.fntok)-1] }()
+		return a.builtin(tok)
+	case "func_name":
+		a.fntok = append(a.fntok, tok)
+		defer func() { a.fntok = a.fntok[:len(a.fntok)-1] }()
+		return a.call(tok)
+	case "getline":
+		return a.getline(tok)
+	default:
+		return nil, a.lexer.newTokenError(tok)
+	}
+}
+
+func (a *awkparser) ereimplicit() bool {
+	// A regex is an implicit match against field 0 if:
+	//   - it is not part of a regex match expression; and
+	//   - it is not an argument to specific builtin functions (see awkerefn).
+	return len(a.fntok) == 0 || !awkerefn[a.fntok[len(a.fntok)-1].name]
+}
+
+func (a *awkparser) symval(tok *token) (e awknode, err error) {
+	v := a.variable(tok)
+	if !a.match("[") {
+		return v, nil
+	}
+	n := &awkindex{arr: v}
+	if n.index, err = a.exprlist(awkstopexprlist); err != nil {
+		return
+	}
+	return n, a.mustmatch("]")
+}
+
+func (a *awkparser) variable(tok *token) *awkvar {
+	for i, param := range a.params {
+		if param.name == tok.name {
+			return &awkvar{name: tok.name, local: i}
+		}
+	}
+	return &awkvar{name: tok.name, global: a.prog.global(tok.name), local: -1}
+}
+
+func (p *awkprog) global(name string) int {
+	if i, ok := p.globalindex[name]; ok {
+		return i
+	}
+	p.globalindex[name] = len(p.globals)
+	p.globals = append(p.globals, name)
+	return p.globalindex[name]
+}
+
+func (a *awkparser) builtin(tok *token) (e awknode, err error) {
+	n := &awkbuiltincall{token: tok, fn: awkbuiltins[tok.name]}
+	if n.fn == nil {
+		return nil, a.lexer.newTokenErrorf(tok, "bad function")
+	}
+	if a.match("(") {
+		if n.args, err = a.exprlist(awkstopexprlist); err != nil {
+			return
+		}
+		if err = a.mustmatch(")"); err != nil {
+			return
+		}
+	}
+	return n, nil
+}
+
+func (a *awkparser) call(tok *token) (e awknode, err error) {
+	n := &awkcall{token: tok}
+	if err = a.mustmatch("("); err != nil {
+		return
+	}
+	if n.args, err = a.exprlist(awkstopexprlist); err != nil {
+		return
+	}
+	if err = a.mustmatch(")"); err != nil {
+		return
+	}
+	a.calls = append(a.calls, n)
+	return n, nil
+}
+
+func (a *awkparser) getline(tok *token) (e awknode, err error) {
+	n := &awkgetline{token: tok}
+	if a.match("name") {
+		n.set = a.variable(a.peek(-1))
+	}
+	if a.match("<") {
+		n.kind = "<"
+		n.src, err = a.expr(awkstopexpr)
+	}
+	return n, err
+}
+
+func (a *awkparser) peek(n int) *token {
+	if a.pos+n < 0 || a.pos+n > len(a.tokens)-1 {
+		return &token{}
+	}
+	return a.tokens[a.pos+n]
+}
+
+func (a *awkparser) next() *token {
+	if a.pos > len(a.tokens)-1 {
+		return &token{pos: -1}
+	}
+	a.pos++
+	return a.tokens[a.pos-1]
+}
+
+func (a *awkparser) match(kind ...string) bool {
+	pos := a.pos
+	for _, k := range kind {
+		if a.next().kind != k {
+			a.pos = pos
+			return false
+		}
+	}
+	return true
+}
+
+func (a *awkparser) mustmatch(kind ...string) error {
+	for _, k := range kind {
+		if !a.match(k) {
+			return a.lexer.newTokenErrorf(a.peek(0), "want %s, got %s",
+				k, a.peek(0).kind)
+		}
+	}
+	return nil
+}
+
+func (a *awkparser) matchany(kind ...string) bool {
+	for _, k := range kind {
+		if a.match(k) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *awkparser) matchstmtdelim() bool {
+	if !awkendstmt[a.peek(-1).kind] && !awkendstmt[a.peek(0).kind] {
+		return false
+	} else if awkstopstmt[a.peek(0).kind] {
+		a.next()
+	}
+	return true
+}
+
+func (a *awkparser) matchfordelim() bool {
+	if !a.match(";") {
+		return false
+	}
+	a.matchnewlines()
+	return true
+}
+
+func (a *awkparser) matchnewlines() (matched bool) {
+	for a.match("\n") {
+		matched = true
+	}
+	return
+}
@@ -0,0 +1,313 @@
+package hive
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+)
+
+var awkclasses = stringset("alnum", "alpha", "blank", "cntrl", "digit", "graph",
+	"lower", "print", "punct", "space", "upper", "xdigit")
+
+// awkcompile compiles an awk extended regular expression.
+//
+// The expression is translated to Go syntax and matched leftmost-longest.
+// Unlike regexp.CompilePOSIX, ^ and $ anchor to the whole string and
+// . and bracket expressions match newlines.
+func awkcompile(s string) (*regexp.Regexp, error) {
+	t, err := awkere(s)
+	if err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(t)
+	if err != nil {
+		return nil, err
+	}
+	re.Longest()
+	return re, nil
+}
+
+func awkere(s string) (string, error) {
+	var (
+		out    strings.Builder
+		runes  = []rune(s)
+		groups []int
+		atom   = -1 // Start of the last atom in out, or -1 if none.
+		repeat bool // Whether the last atom is already repeated.
+	)
+	out.WriteString("(?s)")
+	quantify := func(op string) {
+		if repeat {
+			t := out.String()
+			out.Reset()
+			out.WriteString(t[:atom] + "(?:" + t[atom:] + ")")
+		}
+		out.WriteString(op)
+		repeat = true
+	}
+	literal := func(r rune) {
+		atom, repeat = out.Len(), false
+		out.WriteString(regexp.QuoteMeta(string(r)))
+	}
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; r {
+		case '\\':
+			var c rune
+			c, i = awkescape(runes, i)
+			literal(c)
+		case '[':
+			class, n, err := awkbracket(runes[i:])
+			if err != nil {
+				return "", err
+			}
+			atom, repeat = out.Len(), false
+			out.WriteString(class)
+			i += n - 1
+		case '(':
+			groups = append(groups, out.Len())
+			out.WriteRune(r)
+			atom = -1
+		case ')':
+			if len(groups) == 0 {
+				literal(r)
+				break
+			}
+			out.WriteRune(r)
+			atom, repeat = groups[len(groups)-1], false
+			groups = groups[:len(groups)-1]
+		case '|', '^', '$':
+			out.WriteRune(r)
+			atom = -1
+		case '.':
+			atom, repeat = out.Len(), false
+			out.WriteRune(r)
+		case '*', '+', '?':
+			if atom < 0 {
+				literal(r) // Nothing to repeat.
+				break
+			}
+			quantify(string(r))
+		case '{':
+			op, n := awkinterval(runes[i:])
+			if n == 0 || atom < 0 {
+				literal(r)
+				break
+			}
+			quantify(op)
+			i += n - 1
+		default:
+			literal(r)
+		}
+	}
+	if len(groups) > 0 {
+		return "", fmt.Errorf("missing closing )")
+	}
+	return out.String(), nil
+}
+
+// awkescape returns the rune escaped at runes[i] and the index of its last rune.
+func awkescape(runes []rune, i int) (rune, int) {
+	if i+1 >= len(runes) {
+		return '\\', i
+	}
+	i++
+	switch runes[i] {
+	case 'a':
+		return '\a', i
+	case 'b':
+		return '\b', i
+	case 'f':
+		return '\f', i
+	case 'n':
+		return '\n', i
+	case 'r':
+		return '\r', i
+	case 't':
+		return '\t', i
+	case 'v':
+		return '\v', i
+	}
+	if runes[i] < '0' || runes[i] > '7' {
+		return runes[i], i
+	}
+	var c rune
+	for n := 0; n < 3 && i < len(runes) && runes[i] >= '0' && runes[i] <= '7'; n++ {
+		c = c*8 + runes[i] - '0'
+		i++
+	}
+	return c, i - 1
+}
+
+// awkbracket translates the bracket expression at the start of runes.
+// It returns the Go character class and the number of runes consumed.
+func awkbracket(runes []rune) (string, int, error) {
+	var class strings.Builder
+	class.WriteRune('[')
+	i := 1
+	if i < len(runes) && runes[i] == '^' {
+		class.WriteRune('^')
+		i++
+	}
+	for first := true; ; first = false {
+		if i >= len(runes) {
+			return "", 0, fmt.Errorf("missing closing ]")
+		} else if runes[i] == ']' && !first {
+			class.WriteRune(']')
+			return class.String(), i + 1, nil
+		}
+		lo, n, err := awkbracketelem(runes[i:])
+		if err != nil {
+			return "", 0, err
+		} else if lo < 0 {
+			class.WriteString(string(runes[i : i+n])) // Character class.
+			i += n
+			continue
+		}
+		i += n
+		if i+1 >= len(runes) || runes[i] != '-' || runes[i+1] == ']' {
+			class.WriteString(awkclassrune(lo))
+			continue
+		}
+		hi, n, err := awkbracketelem(runes[i+1:])
+		if err != nil {
+			return "", 0, err
+		} else if hi < lo {
+			return "", 0, fmt.Errorf("bad range: %s",
+				string(runes[i-1:i+1+n]))
+		}
+		class.WriteString(awkclassrune(lo) + "-" + awkclassrune(hi))
+		i += n + 1
+	}
+}
+
+// awkbracketelem parses one element of a bracket expression.
+// It returns -1 for a character class such as [:alpha:].
+func awkbracketelem(runes []rune) (rune, int, error) {
+	if runes[0] == '\\' {
+		r, i := awkescape(runes, 0)
+		return r, i + 1, nil
+	} else if runes[0] != '[' || len(runes) < 2 || !strings.ContainsRune(":.=", runes[1]) {
+		return runes[0], 1, nil
+	}
+	delim := runes[1]
+	for j := 2; j+1 < len(runes); j++ {
+		if runes[j] != delim || runes[j+1] != ']' {
+			continue
+		}
+		name := string(runes[2:j])
+		switch {
+		case delim == ':' && awkclasses[name]:
+			return -1, j + 2, nil
+		case delim == ':':
+			return 0, 0, fmt.Errorf("bad character class: %s", name)
+		case j == 3:
+			return runes[2], j + 2, nil // Collating symbol or equivalence class.
+		default:
+			return 0, 0, fmt.Errorf("bad collating element: %s", name)
+		}
+	}
+	return 0, 0, fmt.Errorf("missing closing %c]", delim)
+}
+
+func awkclassrune(r rune) string {
+	if strings.ContainsRune(`\]-[^`, r) {
+		return `\` + string(r)
+	}
+	return string(r)
+}
+
+// awkinterval parses the interval expression at the start of runes.
+// It returns the Go repetition operator and the number of runes consumed,
+// or zero if runes does not start with a valid interval.
+func awkinterval(runes []rune) (string, int) {
+	var lo, hi string
+	var comma bool
+	for i := 1; i < len(runes); i++ {
+		switch r := runes[i]; {
+		case r >= '0' && r <= '9' && comma:
+			hi += string(r)
+		case r >= '0' && r <= '9':
+			lo += string(r)
+		case r == ',' && !comma:
+			comma = true
+		case r == '}' && (lo != "" || hi != ""):
+			if lo == "" {
+				lo = "0"
+			}
+			if comma {
+				return "{" + lo + "," + hi + "}", i + 1
+			}
+			return "{" + lo + "}", i + 1
+		default:
+			return "", 0
+		}
+	}
+	return "", 0
+}
+
+// awkprefixes returns a regexp that matches every prefix of a match of re at
+// the end of the text. Its leftmost match is the earliest point at which a
+// match of re could begin if the text were longer.
+func awkprefixes(re *regexp.Regexp) (*regexp.Regexp, error) {
+	t, err := syntax.Parse(re.String(), syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+	end := &syntax.Regexp{Op: syntax.OpEndText}
+	t = &syntax.Regexp{Op: syntax.OpConcat, Sub: []*syntax.Regexp{awkprefixre(t.Simplify()), end}}
+	return regexp.Compile(t.String())
+}
+
+// awkprefixre returns an expression that matches the prefixes of matches of
+// t. It may match more, which only makes awkprefixes match earlier.
+func awkprefixre(t *syntax.Regexp) *syntax.Regexp {
+	quest := func(t *syntax.Regexp) *syntax.Regexp {
+		return &syntax.Regexp{Op: syntax.OpQuest, Flags: t.Flags, Sub: []*syntax.Regexp{t}}
+	}
+	concat := func(sub ...*syntax.Regexp) *syntax.Regexp {
+		return &syntax.Regexp{Op: syntax.OpConcat, Flags: t.Flags, Sub: sub}
+	}
+	switch t.Op {
+	case syntax.OpNoMatch, syntax.OpEmptyMatch:
+		return t
+	case syntax.OpLiteral:
+		// The prefixes of abc are (a(b(c)?)?)?.
+		var e *syntax.Regexp
+		for i := len(t.Rune) - 1; i >= 0; i-- {
+			r := &syntax.Regexp{Op: syntax.OpLiteral, Flags: t.Flags, Rune: t.Rune[i : i+1]}
+			if e != nil {
+				r = concat(r, e)
+			}
+			e = quest(r)
+		}
+		return e
+	case syntax.OpCharClass, syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return quest(t)
+	case syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText, syntax.OpEndText,
+		syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+		return &syntax.Regexp{Op: syntax.OpEmptyMatch}
+	case syntax.OpCapture, syntax.OpQuest:
+		return awkprefixre(t.Sub[0])
+	case syntax.OpStar, syntax.OpPlus:
+		star := &syntax.Regexp{Op: syntax.OpStar, Flags: t.Flags, Sub: t.Sub}
+		return concat(star, awkprefixre(t.Sub[0]))
+	case syntax.OpConcat:
+		// A prefix of xy is a prefix of x, or x followed by a prefix of y.
+		e := awkprefixre(t.Sub[len(t.Sub)-1])
+		for i := len(t.Sub) - 2; i >= 0; i-- {
+			e = &syntax.Regexp{Op: syntax.OpAlternate, Sub: []*syntax.Regexp{
+				awkprefixre(t.Sub[i]), concat(t.Sub[i], e),
+			}}
+		}
+		return e
+	case syntax.OpAlternate:
+		e := &syntax.Regexp{Op: syntax.OpAlternate}
+		for _, sub := range t.Sub {
+			e.Sub = append(e.Sub, awkprefixre(sub))
+		}
+		return e
+	}
+	// Any text might begin a match.
+	return &syntax.Regexp{Op: syntax.OpStar, Sub: []*syntax.Regexp{{Op: syntax.OpAnyChar}}}
+}
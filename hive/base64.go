@@ -4,7 +4,6 @@ import (
 	"encoding/base64"
 	"fmt"
 	"io"
-	"os"
 
 	"lesiw.io/buzzybox/internal/bbio"
 	"lesiw.io/buzzybox/internal/flag"
@@ -30,12 +29,13 @@ func Base64(cmd *Cmd) int {
 	var err error
 	switch len(flags.Args) {
 	case 0:
-		file = cmd.Stdin
+		file = &ctxReader{ctx: cmd.ctx, r: cmd.Stdin}
 	case 1:
-		if file, err = os.Open(flags.Args[0]); err != nil {
+		if file, err = cmd.FS.Open(flags.Args[0]); err != nil {
 			fmt.Fprintln(cmd.Stderr, err)
 			return 1
 		}
+		file = &ctxFileReader{ctx: cmd.ctx, r: file}
 	default:
 		flags.PrintError("bad argc: want 0 or 1")
 		return 1
@@ -46,7 +46,9 @@ func Base64(cmd *Cmd) int {
 	if *decode {
 		_, err := io.Copy(cmd.Stdout, base64.NewDecoder(base64.StdEncoding, file))
 		if err != nil {
-			fmt.Fprintln(cmd.Stderr, err)
+			if cmd.ctx.Err() == nil {
+				fmt.Fprintln(cmd.Stderr, err)
+			}
 			return 1
 		}
 	} else {
@@ -56,7 +58,9 @@ func Base64(cmd *Cmd) int {
 		_ = encoder.Close()
 		fmt.Fprintln(cmd.Stdout)
 		if err != nil {
-			fmt.Fprintln(cmd.Stderr, err)
+			if cmd.ctx.Err() == nil {
+				fmt.Fprintln(cmd.Stderr, err)
+			}
 			return 1
 		}
 	}
@@ -0,0 +1,11 @@
+//go:build windows || tinygo
+// +build windows tinygo
+
+package hive
+
+import "os"
+
+// brokenpipe reports whether a process was killed by SIGPIPE.
+func brokenpipe(ps *os.ProcessState) bool {
+	return false
+}
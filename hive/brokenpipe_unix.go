@@ -0,0 +1,15 @@
+//go:build !windows && !tinygo
+// +build !windows,!tinygo
+
+package hive
+
+import (
+	"os"
+	"syscall"
+)
+
+// brokenpipe reports whether a process was killed by SIGPIPE.
+func brokenpipe(ps *os.ProcessState) bool {
+	ws, ok := ps.Sys().(syscall.WaitStatus)
+	return ok && ws.Signaled() && ws.Signal() == syscall.SIGPIPE
+}
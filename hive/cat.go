@@ -4,7 +4,6 @@ import (
 	"bufio"
 	"fmt"
 	"io"
-	"os"
 
 	"lesiw.io/buzzybox/internal/flag"
 )
@@ -35,21 +34,23 @@ func Cat(cmd *Cmd) int {
 		files = []string{"-"}
 	}
 	var r io.Reader
-	var file *os.File
+	var file File
 	for _, f := range files {
 		file = nil
 		if f == "-" {
-			r = cmd.Stdin
+			r = &ctxReader{ctx: cmd.ctx, r: cmd.Stdin}
 		} else {
-			file, err = os.Open(f)
+			file, err = cmd.FS.Open(f)
 			if err != nil {
 				fmt.Fprintf(cmd.Stderr, "bad file: %v\n", err)
 				return 1
 			}
-			r = file
+			r = &ctxFileReader{ctx: cmd.ctx, r: file}
 		}
 		if _, err := io.Copy(w, r); err != nil {
-			fmt.Fprintf(cmd.Stderr, "bad file: %v\n", err)
+			if cmd.ctx.Err() == nil {
+				fmt.Fprintf(cmd.Stderr, "bad file: %v\n", err)
+			}
 			return 1
 		}
 		if file != nil {
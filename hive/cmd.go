@@ -1,14 +1,19 @@
 package hive
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type Cmd struct {
@@ -17,27 +22,52 @@ type Cmd struct {
 	Parent   *Cmd
 	ExitCode int
 	Fallback bool
+	FS       FS
+	Clock    func() time.Time // If nil, time.Now is used.
+	ctx      context.Context
+	cancel   context.CancelCauseFunc
 	code     chan int
+	done     chan struct{}
+	closers  []io.Closer
+	fn       CmdFunc
 }
 type CmdFunc func(*Cmd) int
 type cmdTable struct {
+	mu   sync.Mutex
 	next atomic.Uint64
-	cmd  []*Cmd
+	cmd  map[int]*Cmd
 }
 
+const (
+	ExitCanceled   = 130 // 128+SIGINT
+	ExitKilled     = 137 // 128+SIGKILL
+	ExitBrokenPipe = 141 // 128+SIGPIPE
+	ExitTimeout    = 143 // 128+SIGTERM
+)
+
+var errKilled = errors.New("killed")
+
 var procs cmdTable
 var Bees = map[string]CmdFunc{}
 
 func Command(argv ...string) *Cmd {
+	return CommandContext(context.Background(), argv...)
+}
+
+func CommandContext(ctx context.Context, argv ...string) *Cmd {
+	if ctx == nil {
+		panic("nil Context")
+	}
 	c := &Cmd{}
+	c.ctx, c.cancel = context.WithCancelCause(ctx)
 	c.Path = argv[0]
 	c.Args = argv
 	c.Stdin = os.Stdin
 	c.Stdout = os.Stdout
 	c.Stderr = os.Stderr
+	c.FS = OSFS{}
 	c.Id = int(procs.next.Add(1))
-	c.code = make(chan int)
-	procs.cmd = append(procs.cmd, c)
+	c.code = make(chan int, 1)
 	return c
 }
 
@@ -49,6 +79,45 @@ func CmdList() (cmds []string) {
 	return
 }
 
+// Processes returns the running commands, ordered by Id. A command run by
+// the host system is listed until it is waited for.
+func Processes() []*Cmd {
+	procs.mu.Lock()
+	defer procs.mu.Unlock()
+	cmds := make([]*Cmd, 0, len(procs.cmd))
+	for _, c := range procs.cmd {
+		cmds = append(cmds, c)
+	}
+	sort.Slice(cmds, func(i, j int) bool { return cmds[i].Id < cmds[j].Id })
+	return cmds
+}
+
+// FindProcess returns the running command with the given Id, or nil.
+func FindProcess(id int) *Cmd {
+	procs.mu.Lock()
+	defer procs.mu.Unlock()
+	return procs.cmd[id]
+}
+
+func (t *cmdTable) add(c *Cmd) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cmd == nil {
+		t.cmd = make(map[int]*Cmd)
+	}
+	t.cmd[c.Id] = c
+}
+
+func (t *cmdTable) remove(c *Cmd) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.cmd, c.Id)
+}
+
+func (c *Cmd) Context() context.Context {
+	return c.ctx
+}
+
 func (c *Cmd) Default() int {
 	// TODO: word wrap
 	fmt.Fprintf(c.Stderr, "Usage: buzzybox [command]\nCommands: %s\n",
@@ -69,11 +138,25 @@ func (c *Cmd) Run() int {
 	return c.ExitCode
 }
 
+// Kill stops the command and every command it spawned.
+// The command exits with status ExitKilled.
+func (c *Cmd) Kill() {
+	c.cancel(errKilled)
+}
+
 func (c *Cmd) Start() {
+	if err := context.Cause(c.ctx); err != nil {
+		c.start(func(*Cmd) int { return ctxcode(err) })
+		return
+	}
+	if c.fn != nil {
+		c.start(c.fn)
+		return
+	}
 	cmd := filepath.Base(c.Path)
 	if name, _, _ := strings.Cut(cmd, "."); name == "buzzybox" {
 		if len(c.Args) < 2 {
-			go func() { c.code <- c.Default() }()
+			c.start((*Cmd).Default)
 			return
 		}
 		c.Args = c.Args[1:]
@@ -81,7 +164,7 @@ func (c *Cmd) Start() {
 		cmd = filepath.Base(c.Path)
 	}
 	if fn, ok := Bees[cmd]; ok {
-		go func() { c.code <- fn(c) }()
+		c.start(fn)
 		return
 	} else if c.Fallback {
 		path, err := exec.LookPath(c.Path)
@@ -89,38 +172,155 @@ func (c *Cmd) Start() {
 			goto badcmd
 		}
 		c.Path = path
+		// os/exec copies output from its own goroutine, which must not panic.
+		if pw, ok := c.Stdout.(*pipeWriter); ok {
+			c.Stdout = pw.PipeWriter
+		}
 		if err = c.Cmd.Start(); err != nil {
 			goto badcmd
 		}
+		procs.add(c)
+		c.done = make(chan struct{})
+		go func() {
+			select {
+			case <-c.ctx.Done():
+				_ = c.Process.Kill()
+			case <-c.done:
+			}
+		}()
 		return
 	}
 badcmd:
-	go func() { c.code <- c.BadCmd() }()
+	c.start((*Cmd).BadCmd)
+}
+
+// start runs fn in a new goroutine, listing c in the process table until fn
+// returns.
+func (c *Cmd) start(fn CmdFunc) {
+	procs.add(c)
+	go func() {
+		code := c.run(fn)
+		procs.remove(c)
+		c.code <- code
+	}()
+}
+
+func (c *Cmd) run(fn CmdFunc) (code int) {
+	defer c.closeio()
+	defer func() {
+		if r := recover(); r == errBrokenPipe {
+			code = ExitBrokenPipe
+		} else if r != nil {
+			panic(r)
+		}
+	}()
+	return fn(c)
+}
+
+func (c *Cmd) closeio() {
+	for _, cl := range c.closers {
+		_ = cl.Close()
+	}
+	c.closers = nil
+}
+
+func (c *Cmd) external() bool {
+	if c.fn != nil {
+		return false
+	}
+	cmd := filepath.Base(c.Path)
+	if name, _, _ := strings.Cut(cmd, "."); name == "buzzybox" {
+		return false
+	}
+	_, ok := Bees[cmd]
+	return !ok && c.Fallback
 }
 
 func (c *Cmd) Wait() error {
+	defer c.cancel(nil)
 	if c.Process == nil {
 		c.ExitCode = <-c.code
+		if err := context.Cause(c.ctx); err != nil && c.ExitCode != 0 {
+			c.ExitCode = ctxcode(err)
+		}
 		return nil
 	}
-	if err := c.Cmd.Wait(); err != nil {
+	err := c.Cmd.Wait()
+	procs.remove(c)
+	close(c.done)
+	c.closeio()
+	if ctxerr := context.Cause(c.ctx); ctxerr != nil && c.ProcessState != nil &&
+		!c.ProcessState.Success() {
+		c.ExitCode = ctxcode(ctxerr)
+		return nil
+	} else if errors.Is(err, io.ErrClosedPipe) ||
+		c.ProcessState != nil && brokenpipe(c.ProcessState) {
+		// Output to a closed pipe, whether copied by os/exec or written
+		// directly by the process.
+		c.ExitCode = ExitBrokenPipe
+		return nil
+	} else if err != nil {
 		return err
 	}
 	c.ExitCode = c.ProcessState.ExitCode()
 	return nil
 }
 
+// wait waits for c to exit and reports a non-zero exit status as an error.
+func (c *Cmd) wait() error {
+	if err := c.Wait(); err != nil {
+		return err
+	} else if c.ExitCode != 0 {
+		return &ExitError{c.ExitCode}
+	}
+	return nil
+}
+
+// An ExitError reports that a command exited with a non-zero status.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return "exit status " + strconv.Itoa(e.Code)
+}
+
+func ctxcode(err error) int {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ExitTimeout
+	} else if errors.Is(err, errKilled) {
+		return ExitKilled
+	}
+	return ExitCanceled
+}
+
+func (c *Cmd) now() time.Time {
+	if c.Clock != nil {
+		return c.Clock()
+	}
+	return time.Now()
+}
+
 func (c *Cmd) spawn(argv ...string) *Cmd {
-	cmd := Command(argv...)
+	cmd := CommandContext(c.ctx, argv...)
 	cmd.Fallback = true
 	cmd.Stdin = c.Stdin
 	cmd.Stdout = c.Stdout
 	cmd.Stderr = c.Stderr
+	cmd.Dir = c.Dir
+	cmd.FS = c.FS
+	cmd.Clock = c.Clock
 	cmd.Parent = c
 	return cmd
 }
 
 func (c *Cmd) StdinCloser() (io.WriteCloser, error) {
+	if !c.external() {
+		pr, pw := io.Pipe()
+		c.Stdin = pr
+		c.closers = append(c.closers, pr)
+		return &CmdWriteCloser{c, pw}, nil
+	}
 	c.Stdin = nil
 	wc, err := c.StdinPipe()
 	if err != nil {
@@ -138,14 +338,22 @@ func (cwc *CmdWriteCloser) Write(p []byte) (int, error) {
 	return cwc.wc.Write(p)
 }
 
+// Close closes the command's stdin and waits for it to exit.
+// If the command exits with a non-zero status, Close returns an *ExitError.
 func (cwc *CmdWriteCloser) Close() error {
 	if err := cwc.wc.Close(); err != nil {
 		return err
 	}
-	return cwc.cmd.Wait()
+	return cwc.cmd.wait()
 }
 
 func (c *Cmd) StdoutCloser() (io.ReadCloser, error) {
+	if !c.external() {
+		pr, pw := io.Pipe()
+		c.Stdout = &pipeWriter{pw}
+		c.closers = append(c.closers, pw)
+		return &cmdReadCloser{c, pr}, nil
+	}
 	c.Stdout = nil
 	rc, err := c.StdoutPipe()
 	if err != nil {
@@ -167,5 +375,5 @@ func (crc *cmdReadCloser) Close() error {
 	if err := crc.rc.Close(); err != nil {
 		return err
 	}
-	return crc.cmd.Wait()
+	return crc.cmd.wait()
 }
@@ -1,8 +1,12 @@
 package hive_test
 
 import (
+	"context"
+	"io"
+	"os/exec"
 	"strings"
 	"testing"
+	"time"
 
 	"lesiw.io/buzzybox/hive"
 )
@@ -42,3 +46,183 @@ func fail(t *testing.T, argv ...string) string {
 func failN(t *testing.T, argv ...string) []string {
 	return strings.Split(fail(t, argv...), "\n")
 }
+
+func TestCommandContext(t *testing.T) {
+	tests := []struct {
+		name  string
+		argv  []string
+		stdin io.Reader
+		code  int
+	}{{
+		name: "awk loop",
+		argv: []string{"awk", "BEGIN { while (1) {} }"},
+		code: hive.ExitTimeout,
+	}, {
+		name:  "awk records",
+		argv:  []string{"awk", "{ print }"},
+		stdin: infiniteReader{},
+		code:  hive.ExitTimeout,
+	}, {
+		name:  "cat",
+		argv:  []string{"cat"},
+		stdin: infiniteReader{},
+		code:  hive.ExitTimeout,
+	}, {
+		name:  "awk blocked read",
+		argv:  []string{"awk", "{ print }"},
+		stdin: blockingReader(),
+		code:  hive.ExitTimeout,
+	}, {
+		name:  "cat blocked read",
+		argv:  []string{"cat"},
+		stdin: blockingReader(),
+		code:  hive.ExitTimeout,
+	}, {
+		name:  "base64 blocked read",
+		argv:  []string{"base64"},
+		stdin: blockingReader(),
+		code:  hive.ExitTimeout,
+	}, {
+		name: "cat file",
+		argv: []string{"cat", "/dev/zero"},
+		code: hive.ExitTimeout,
+	}, {
+		name: "base64 file",
+		argv: []string{"base64", "/dev/zero"},
+		code: hive.ExitTimeout,
+	}, {
+		name: "awk file",
+		argv: []string{"awk", "{ print }", "/dev/zero"},
+		code: hive.ExitTimeout,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(),
+				50*time.Millisecond)
+			defer cancel()
+			cmd := hive.CommandContext(ctx, tt.argv...)
+			cmd.Stdin = tt.stdin
+			cmd.Stdout = io.Discard
+			cmd.Stderr = new(strings.Builder)
+			if code := cmd.Run(); code != tt.code {
+				t.Errorf("code: got %d, want %d", code, tt.code)
+			}
+			if stderr := cmd.Stderr.(*strings.Builder).String(); stderr != "" {
+				t.Errorf("stderr: got %q, want empty", stderr)
+			}
+		})
+	}
+}
+
+func TestCommandContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cmd := hive.CommandContext(ctx, "true")
+	if code := cmd.Run(); code != hive.ExitCanceled {
+		t.Errorf("code: got %d, want %d", code, hive.ExitCanceled)
+	}
+}
+
+func TestCommandContextFallback(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip("sleep not found")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := hive.CommandContext(ctx, "sleep", "10")
+	cmd.Fallback = true
+	cmd.Start()
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	if err := cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if cmd.ExitCode != hive.ExitCanceled {
+		t.Errorf("code: got %d, want %d", cmd.ExitCode, hive.ExitCanceled)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("process not killed after %v", d)
+	}
+}
+
+func TestProcesses(t *testing.T) {
+	cmd := hive.Command("sh", "-c", `awk 'BEGIN { while (1) {} }'`)
+	cmd.Stdout = io.Discard
+	cmd.Start()
+	var child *hive.Cmd
+	for deadline := time.Now().Add(5 * time.Second); child == nil; {
+		if time.Now().After(deadline) {
+			t.Fatal("awk not found in process table")
+		}
+		for _, c := range hive.Processes() {
+			if c.Parent == cmd && c.Args[0] == "awk" {
+				child = c
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := hive.FindProcess(cmd.Id); got != cmd {
+		t.Errorf("FindProcess(%d): got %v, want %v", cmd.Id, got, cmd)
+	}
+	cmd.Kill()
+	if err := cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if cmd.ExitCode != hive.ExitKilled {
+		t.Errorf("code: got %d, want %d", cmd.ExitCode, hive.ExitKilled)
+	}
+	for _, id := range []int{cmd.Id, child.Id} {
+		for deadline := time.Now().Add(5 * time.Second); hive.FindProcess(id) != nil; {
+			if time.Now().After(deadline) {
+				t.Fatalf("process %d still in process table", id)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+func TestProcessesNotWaited(t *testing.T) {
+	done := make(chan struct{})
+	go func() {
+		// Read Args while commands start, for the race detector.
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			for _, c := range hive.Processes() {
+				_ = c.Args[0]
+			}
+		}
+	}()
+	defer close(done)
+	var cmds []*hive.Cmd
+	for i := 0; i < 10; i++ {
+		cmd := hive.Command("buzzybox", "true")
+		cmd.Start()
+		cmds = append(cmds, cmd)
+	}
+	for _, cmd := range cmds {
+		for deadline := time.Now().Add(5 * time.Second); hive.FindProcess(cmd.Id) != nil; {
+			if time.Now().After(deadline) {
+				t.Fatalf("process %d still in process table", cmd.Id)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+type infiniteReader struct{}
+
+func (infiniteReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = "y\n"[i%2]
+	}
+	return len(p), nil
+}
+
+// blockingReader returns a reader whose reads block forever.
+func blockingReader() io.Reader {
+	pr, _ := io.Pipe()
+	return pr
+}
@@ -2,7 +2,9 @@ package hive
 
 import (
 	"bufio"
+	"context"
 	"io"
+	"os"
 	"strings"
 )
 
@@ -66,6 +68,86 @@ func (brc *bufferedReadCloser) Close() error {
 	return brc.closer.Close()
 }
 
+type bufferedWriteCloser struct {
+	*bufio.Writer
+	closer io.Closer
+}
+
+func newBufferedWriteCloser(wc io.WriteCloser) *bufferedWriteCloser {
+	return &bufferedWriteCloser{
+		Writer: bufio.NewWriter(wc),
+		closer: wc,
+	}
+}
+
+func (bwc *bufferedWriteCloser) Close() error {
+	ferr := bwc.Flush()
+	if err := bwc.closer.Close(); err != nil {
+		return err
+	}
+	return ferr
+}
+
+// isterminal reports whether w is a character device, such as a terminal.
+func isterminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// A ctxReader reads from r until ctx is done. Each Read runs in its own
+// goroutine, so that a Read blocked on r returns as soon as ctx is done; the
+// abandoned Read may still consume data from r.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+	buf []byte
+}
+
+type readResult struct {
+	n   int
+	err error
+}
+
+func (cr *ctxReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if len(cr.buf) < len(p) {
+		cr.buf = make([]byte, len(p))
+	}
+	buf := cr.buf[:len(p)]
+	res := make(chan readResult, 1)
+	go func() {
+		n, err := cr.r.Read(buf)
+		res <- readResult{n, err}
+	}()
+	select {
+	case <-cr.ctx.Done():
+		cr.buf = nil // Still in use by the abandoned Read.
+		return 0, cr.ctx.Err()
+	case r := <-res:
+		return copy(p, buf[:r.n]), r.err
+	}
+}
+
+// A ctxFileReader reads from r until ctx is done. Reads from files do not
+// block, so ctx need only be checked between them.
+type ctxFileReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *ctxFileReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
+
 func skiprune(reader io.RuneScanner, s rune) error {
 	for {
 		if r, _, err := reader.ReadRune(); err != nil {
@@ -0,0 +1,348 @@
+package hive
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type FS interface {
+	Open(name string) (File, error)
+	Create(name string) (File, error)
+	Append(name string) (File, error)
+	ReadWrite(name string) (File, error) // Creates name if it does not exist.
+	Stat(name string) (fs.FileInfo, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+	Remove(name string) error
+}
+
+type File interface {
+	io.ReadWriteCloser
+	Stat() (fs.FileInfo, error)
+}
+
+func readfile(fsys FS, name string) ([]byte, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// A dirFS resolves relative names against dir.
+type dirFS struct {
+	FS
+	dir string
+}
+
+func (d *dirFS) path(name string) string {
+	if filepath.IsAbs(name) || path.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(d.dir, name)
+}
+
+func (d *dirFS) Open(name string) (File, error)   { return d.FS.Open(d.path(name)) }
+func (d *dirFS) Create(name string) (File, error) { return d.FS.Create(d.path(name)) }
+func (d *dirFS) Append(name string) (File, error) { return d.FS.Append(d.path(name)) }
+func (d *dirFS) Remove(name string) error         { return d.FS.Remove(d.path(name)) }
+
+func (d *dirFS) ReadWrite(name string) (File, error) {
+	return d.FS.ReadWrite(d.path(name))
+}
+
+func (d *dirFS) Stat(name string) (fs.FileInfo, error) {
+	return d.FS.Stat(d.path(name))
+}
+
+func (d *dirFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return d.FS.ReadDir(d.path(name))
+}
+
+type OSFS struct{}
+
+func (OSFS) Open(name string) (File, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (OSFS) Create(name string) (File, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (OSFS) Append(name string) (File, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (OSFS) ReadWrite(name string) (File, error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (OSFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (OSFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (OSFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// MemFS is an in-memory FS. The zero value is an empty filesystem.
+type MemFS struct {
+	mu    sync.Mutex
+	files map[string]*memfile
+}
+
+type memfile struct {
+	name    string
+	data    []byte
+	modtime time.Time
+}
+
+type memhandle struct {
+	fs     *MemFS
+	file   *memfile
+	off    int
+	read   bool
+	write  bool
+	closed bool
+}
+
+type meminfo struct {
+	name    string
+	size    int64
+	dir     bool
+	modtime time.Time
+}
+
+func (m *MemFS) clean(name string) string {
+	return path.Clean("/" + filepath.ToSlash(name))
+}
+
+func (m *MemFS) open(op string, name string, create bool, trunc bool) (*memfile, error) {
+	name = m.clean(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.files == nil {
+		m.files = make(map[string]*memfile)
+	}
+	f := m.files[name]
+	if f == nil && m.isdir(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	} else if f == nil && !create {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	} else if f == nil {
+		f = &memfile{name: name}
+		m.files[name] = f
+	}
+	if trunc {
+		f.data = nil
+	}
+	if create {
+		f.modtime = time.Now()
+	}
+	return f, nil
+}
+
+func (m *MemFS) isdir(name string) bool {
+	if name == "/" {
+		return true
+	}
+	for k := range m.files {
+		if strings.HasPrefix(k, name+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MemFS) Open(name string) (File, error) {
+	f, err := m.open("open", name, false, false)
+	if err != nil {
+		return nil, err
+	}
+	return &memhandle{fs: m, file: f, read: true}, nil
+}
+
+func (m *MemFS) Create(name string) (File, error) {
+	f, err := m.open("create", name, true, true)
+	if err != nil {
+		return nil, err
+	}
+	return &memhandle{fs: m, file: f, write: true}, nil
+}
+
+func (m *MemFS) Append(name string) (File, error) {
+	f, err := m.open("append", name, true, false)
+	if err != nil {
+		return nil, err
+	}
+	return &memhandle{fs: m, file: f, off: -1, write: true}, nil
+}
+
+func (m *MemFS) ReadWrite(name string) (File, error) {
+	f, err := m.open("open", name, true, false)
+	if err != nil {
+		return nil, err
+	}
+	return &memhandle{fs: m, file: f, read: true, write: true}, nil
+}
+
+func (m *MemFS) Stat(name string) (fs.FileInfo, error) {
+	name = m.clean(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if f := m.files[name]; f != nil {
+		return f.info(), nil
+	} else if m.isdir(name) {
+		return &meminfo{name: path.Base(name), dir: true}, nil
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (m *MemFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	name = m.clean(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.files[name] != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	} else if !m.isdir(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	prefix := strings.TrimSuffix(name, "/") + "/"
+	seen := make(map[string]bool)
+	var entries []fs.DirEntry
+	for k, f := range m.files {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		child, _, isdir := strings.Cut(k[len(prefix):], "/")
+		if seen[child] {
+			continue
+		}
+		seen[child] = true
+		if isdir {
+			entries = append(entries, &meminfo{name: child, dir: true})
+		} else {
+			entries = append(entries, f.info())
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+func (m *MemFS) Remove(name string) error {
+	name = m.clean(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.files[name] == nil {
+		if m.isdir(name) {
+			return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrExist}
+		}
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(m.files, name)
+	return nil
+}
+
+func (f *memfile) info() *meminfo {
+	return &meminfo{
+		name:    path.Base(f.name),
+		size:    int64(len(f.data)),
+		modtime: f.modtime,
+	}
+}
+
+func (h *memhandle) Read(p []byte) (int, error) {
+	h.fs.mu.Lock()
+	defer h.fs.mu.Unlock()
+	if h.closed {
+		return 0, fs.ErrClosed
+	} else if !h.read {
+		return 0, &fs.PathError{Op: "read", Path: h.file.name, Err: fs.ErrPermission}
+	} else if h.off >= len(h.file.data) {
+		return 0, io.EOF
+	}
+	n := copy(p, h.file.data[h.off:])
+	h.off += n
+	return n, nil
+}
+
+func (h *memhandle) Write(p []byte) (int, error) {
+	h.fs.mu.Lock()
+	defer h.fs.mu.Unlock()
+	if h.closed {
+		return 0, fs.ErrClosed
+	} else if !h.write {
+		return 0, &fs.PathError{Op: "write", Path: h.file.name, Err: fs.ErrPermission}
+	}
+	if h.off < 0 {
+		h.file.data = append(h.file.data, p...)
+	} else {
+		if end := h.off + len(p); end > len(h.file.data) {
+			h.file.data = append(h.file.data, make([]byte, end-len(h.file.data))...)
+		}
+		copy(h.file.data[h.off:], p)
+		h.off += len(p)
+	}
+	h.file.modtime = time.Now()
+	return len(p), nil
+}
+
+func (h *memhandle) Close() error {
+	h.fs.mu.Lock()
+	defer h.fs.mu.Unlock()
+	if h.closed {
+		return fs.ErrClosed
+	}
+	h.closed = true
+	return nil
+}
+
+func (h *memhandle) Stat() (fs.FileInfo, error) {
+	h.fs.mu.Lock()
+	defer h.fs.mu.Unlock()
+	return h.file.info(), nil
+}
+
+func (i *meminfo) Name() string               { return i.name }
+func (i *meminfo) Size() int64                { return i.size }
+func (i *meminfo) ModTime() time.Time         { return i.modtime }
+func (i *meminfo) IsDir() bool                { return i.dir }
+func (i *meminfo) Sys() any                   { return nil }
+func (i *meminfo) Type() fs.FileMode          { return i.Mode().Type() }
+func (i *meminfo) Info() (fs.FileInfo, error) { return i, nil }
+
+func (i *meminfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
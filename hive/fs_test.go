@@ -0,0 +1,148 @@
+package hive_test
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"lesiw.io/buzzybox/hive"
+)
+
+func memfs(t *testing.T, files map[string]string) *hive.MemFS {
+	fsys := new(hive.MemFS)
+	for name, contents := range files {
+		f, err := fsys.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(f, contents); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return fsys
+}
+
+func memread(t *testing.T, fsys hive.FS, name string) string {
+	f, err := fsys.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	buf, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf)
+}
+
+func TestMemFS(t *testing.T) {
+	fsys := memfs(t, map[string]string{
+		"a.txt":     "foo\n",
+		"dir/b.txt": "bar\n",
+		"dir/c/d":   "baz\n",
+	})
+	if got, want := memread(t, fsys, "/a.txt"), "foo\n"; got != want {
+		t.Errorf("read a.txt: got %q, want %q", got, want)
+	}
+	f, err := fsys.Append("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.WriteString(f, "qux\n")
+	_ = f.Close()
+	if got, want := memread(t, fsys, "a.txt"), "foo\nqux\n"; got != want {
+		t.Errorf("append a.txt: got %q, want %q", got, want)
+	}
+	f, err = fsys.ReadWrite("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.WriteString(f, "F")
+	buf, _ := io.ReadAll(f)
+	_ = f.Close()
+	if got, want := string(buf)+memread(t, fsys, "a.txt"), "oo\nqux\nFoo\nqux\n"; got != want {
+		t.Errorf("read write a.txt: got %q, want %q", got, want)
+	}
+	info, err := fsys.Stat("dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.IsDir() {
+		t.Errorf("stat dir: want directory")
+	}
+	entries, err := fsys.ReadDir("dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if got, want := strings.Join(names, ","), "b.txt,c"; got != want {
+		t.Errorf("readdir dir: got %s, want %s", got, want)
+	}
+	if err := fsys.Remove("dir/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsys.Open("dir/b.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("open removed file: got %v, want %v", err, fs.ErrNotExist)
+	}
+}
+
+func TestMemFSBees(t *testing.T) {
+	tests := []struct {
+		args  []string
+		files map[string]string
+		out   string
+		check map[string]string
+	}{{
+		args:  []string{"cat", "f0", "f1"},
+		files: map[string]string{"f0": "foo\n", "f1": "bar\n"},
+		out:   "foo\nbar\n",
+	}, {
+		args:  []string{"base64", "f0"},
+		files: map[string]string{"f0": "hello world\n"},
+		out:   "aGVsbG8gd29ybGQK\n",
+	}, {
+		args:  []string{"awk", "-f", "prog", "f0"},
+		files: map[string]string{"prog": "{ print $2 }", "f0": "a b\nc d\n"},
+		out:   "b\nd\n",
+	}, {
+		args:  []string{"awk", `BEGIN { print "x" > "out"; print "y" >> "out" }`},
+		check: map[string]string{"out": "x\ny\n"},
+	}, {
+		args:  []string{"awk", `BEGIN { while ((getline l < "f0") > 0) print l }`},
+		files: map[string]string{"f0": "one\ntwo\n"},
+		out:   "one\ntwo\n",
+	}, {
+		args:  []string{"awk", `BEGIN { print "x" > "out"; system("cat out f0") }`},
+		files: map[string]string{"f0": "y\n"},
+		out:   "x\ny\n",
+	}}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			fsys := memfs(t, tt.files)
+			cmd := hive.Command(tt.args...)
+			cmd.FS = fsys
+			cmd.Stdout = new(strings.Builder)
+			cmd.Stderr = new(strings.Builder)
+			if code := cmd.Run(); code != 0 {
+				t.Fatalf("exit status %d, want 0\nstderr\n---\n%s", code,
+					cmd.Stderr.(*strings.Builder).String())
+			}
+			if got := cmd.Stdout.(*strings.Builder).String(); got != tt.out {
+				t.Errorf("got %q, want %q", got, tt.out)
+			}
+			for name, want := range tt.check {
+				if got := memread(t, fsys, name); got != want {
+					t.Errorf("%s: got %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
@@ -205,17 +205,17 @@ func (e *tokenError) Error() string {
 }
 
 func (e *tokenError) Reason() string {
-	if e.reason == "" {
-		switch e.token.kind {
-		case "":
-			e.reason = "bad EOF"
-		case "\n":
-			e.reason = "bad newline"
-		default:
-			e.reason = fmt.Sprintf("bad %s", e.token.kind)
-		}
+	if e.reason != "" {
+		return e.reason
+	}
+	switch e.token.kind {
+	case "":
+		return "bad EOF"
+	case "\n":
+		return "bad newline"
+	default:
+		return fmt.Sprintf("bad %s", e.token.kind)
 	}
-	return e.reason
 }
 
 func (e *tokenError) Pretty() string {
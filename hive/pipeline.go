@@ -0,0 +1,74 @@
+package hive
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+var errBrokenPipe = errors.New("broken pipe")
+
+type Pipeline struct {
+	Cmds     []*Cmd
+	Codes    []int
+	ExitCode int
+	Pipefail bool
+	wg       sync.WaitGroup
+}
+
+func NewPipeline(cmds ...*Cmd) *Pipeline {
+	return &Pipeline{Cmds: cmds}
+}
+
+func (p *Pipeline) Run() int {
+	p.Start()
+	p.Wait()
+	return p.ExitCode
+}
+
+func (p *Pipeline) Start() {
+	p.Codes = make([]int, len(p.Cmds))
+	for i := 0; i < len(p.Cmds)-1; i++ {
+		pr, pw := io.Pipe()
+		p.Cmds[i].Stdout = &pipeWriter{pw}
+		p.Cmds[i].closers = append(p.Cmds[i].closers, pw)
+		p.Cmds[i+1].Stdin = pr
+		p.Cmds[i+1].closers = append(p.Cmds[i+1].closers, pr)
+	}
+	for i, c := range p.Cmds {
+		c.Start()
+		p.wg.Add(1)
+		go func(i int, c *Cmd) {
+			defer p.wg.Done()
+			if err := c.Wait(); err != nil {
+				c.ExitCode = 1
+			}
+			p.Codes[i] = c.ExitCode
+		}(i, c)
+	}
+}
+
+func (p *Pipeline) Wait() {
+	p.wg.Wait()
+	p.ExitCode = 0
+	for i := len(p.Codes) - 1; i >= 0; i-- {
+		if p.Codes[i] != 0 || !p.Pipefail {
+			p.ExitCode = p.Codes[i]
+			break
+		}
+	}
+}
+
+// pipeWriter terminates an in-process bee when the read end of its pipe is
+// closed, like SIGPIPE does for a process.
+type pipeWriter struct {
+	*io.PipeWriter
+}
+
+func (pw *pipeWriter) Write(p []byte) (int, error) {
+	n, err := pw.PipeWriter.Write(p)
+	if errors.Is(err, io.ErrClosedPipe) {
+		panic(errBrokenPipe)
+	}
+	return n, err
+}
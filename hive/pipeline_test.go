@@ -0,0 +1,147 @@
+package hive_test
+
+import (
+	"errors"
+	"io"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+
+	"lesiw.io/buzzybox/hive"
+)
+
+func TestPipeline(t *testing.T) {
+	cat := hive.Command("cat")
+	cat.Stdin = strings.NewReader("a b\nc d\n")
+	awk := hive.Command("awk", "{ print $2 }")
+	out := new(strings.Builder)
+	awk.Stdout = out
+	p := hive.NewPipeline(cat, awk)
+	if code := p.Run(); code != 0 {
+		t.Errorf("code: got %d, want 0", code)
+	}
+	if got, want := out.String(), "b\nd\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestPipelineBrokenPipe(t *testing.T) {
+	tests := []struct {
+		name string
+		head *hive.Cmd
+	}{
+		{"cat", hive.Command("cat")},
+		{"awk", hive.Command("awk", "BEGIN { while (1) print \"y\" }")},
+		{"external", external("yes")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := exec.LookPath(tt.head.Args[0]); tt.head.Fallback && err != nil {
+				t.Skipf("%s not found", tt.head.Args[0])
+			}
+			tt.head.Stdin = infiniteReader{}
+			tt.head.Stderr = new(strings.Builder)
+			tail := hive.Command("awk", "{ print } NR == 3 { exit 2 }")
+			out := new(strings.Builder)
+			tail.Stdout = out
+			p := hive.NewPipeline(tt.head, tail)
+			if code := p.Run(); code != 2 {
+				t.Errorf("code: got %d, want 2", code)
+			}
+			if got, want := p.Codes, []int{hive.ExitBrokenPipe, 2}; !slices.Equal(got, want) {
+				t.Errorf("codes: got %v, want %v", got, want)
+			}
+			if got, want := out.String(), "y\ny\ny\n"; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			if stderr := tt.head.Stderr.(*strings.Builder).String(); stderr != "" {
+				t.Errorf("stderr: got %q, want empty", stderr)
+			}
+		})
+	}
+}
+
+func TestPipelinePipefail(t *testing.T) {
+	tests := []struct {
+		argv     [][]string
+		pipefail bool
+		code     int
+	}{
+		{[][]string{{"true"}, {"false"}, {"true"}}, false, 0},
+		{[][]string{{"true"}, {"false"}, {"true"}}, true, 1},
+		{[][]string{{"false"}, {"true"}, {"false"}}, false, 1},
+		{[][]string{{"true"}, {"true"}}, true, 0},
+	}
+	for _, tt := range tests {
+		var cmds []*hive.Cmd
+		for _, argv := range tt.argv {
+			cmds = append(cmds, hive.Command(argv...))
+		}
+		p := hive.NewPipeline(cmds...)
+		p.Pipefail = tt.pipefail
+		if code := p.Run(); code != tt.code {
+			t.Errorf("%v (pipefail=%v): got %d, want %d", tt.argv, tt.pipefail,
+				code, tt.code)
+		}
+	}
+}
+
+func TestStdoutCloser(t *testing.T) {
+	cmd := hive.Command("awk", `BEGIN { print "hello"; print "world" }`)
+	r, err := cmd.StdoutCloser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.Start()
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(buf), "hello\nworld\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestStdinCloser(t *testing.T) {
+	cmd := hive.Command("awk", "{ print NR, $0 }")
+	out := new(strings.Builder)
+	cmd.Stdout = out
+	w, err := cmd.StdinCloser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.Start()
+	_, _ = io.WriteString(w, "foo\nbar\n")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "1 foo\n2 bar\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCloserExitStatus(t *testing.T) {
+	cmd := hive.Command("sh", "-c", "read x; echo $x; exit 3")
+	w, err := cmd.StdinCloser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.Stdout = io.Discard
+	cmd.Start()
+	_, _ = io.WriteString(w, "a\n")
+	var exit *hive.ExitError
+	if err := w.Close(); !errors.As(err, &exit) || exit.Code != 3 {
+		t.Errorf("got %v, want exit status 3", err)
+	}
+}
+
+// external returns a command that runs the named program instead of a bee.
+func external(argv ...string) *hive.Cmd {
+	cmd := hive.Command(argv...)
+	cmd.Fallback = true
+	return cmd
+}
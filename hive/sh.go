@@ -0,0 +1,2118 @@
+package hive
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"lesiw.io/buzzybox/internal/flag"
+)
+
+const shUsage = `usage: sh [-e] [-c COMMAND_STRING [NAME [ARG...]] | FILE [ARG...]]
+
+Shell command language interpreter. Commands are dispatched to built-in
+bees before falling back to the host system.`
+
+func init() {
+	Bees["sh"] = Sh
+}
+
+func Sh(cmd *Cmd) int {
+	flags := flag.NewFlagSet(cmd.Stderr, "sh")
+	command := flags.Bool("c", "Read commands from the first operand")
+	errexit := flags.Bool("e", "Exit when a command fails")
+	flags.Usage = shUsage
+	if err := flags.Parse(cmd.Args[1:]...); err != nil {
+		return 2
+	}
+	s := newshell(cmd)
+	s.errexit = *errexit
+	var src string
+	switch {
+	case *command && len(flags.Args) == 0:
+		flags.PrintError("bad argc: -c needs a command string")
+		return 2
+	case *command:
+		src = flags.Args[0]
+		if len(flags.Args) > 1 {
+			s.arg0 = flags.Args[1]
+			s.args = flags.Args[2:]
+		}
+	case len(flags.Args) > 0:
+		buf, err := readfile(s.fsys, flags.Args[0])
+		if err != nil {
+			fmt.Fprintf(cmd.Stderr, "bad file: %s\n", err)
+			return 127
+		}
+		src = string(buf)
+		s.arg0 = flags.Args[0]
+		s.args = flags.Args[1:]
+	default:
+		buf, err := io.ReadAll(cmd.Stdin)
+		if err != nil {
+			fmt.Fprintf(cmd.Stderr, "bad read: %s\n", err)
+			return 1
+		}
+		src = string(buf)
+	}
+	return s.source(src, s.stdio())
+}
+
+type shell struct {
+	cmd      *Cmd
+	vars     map[string]*shvar
+	funcs    map[string]*shcompound
+	arg0     string
+	args     []string
+	status   int
+	errexit  bool
+	pipefail bool
+	noerr    int
+	jobs     *sync.WaitGroup
+	bg       map[int]*shjob
+	lastjob  int
+	subs     int
+	dir      string // Working directory; "" if unchanged.
+	fsys     FS     // cmd.FS, relative to dir.
+	traps    map[string]string
+}
+
+type shvar struct {
+	val    string
+	export bool
+}
+
+type shjob struct {
+	done chan struct{}
+	code int
+}
+
+type shio struct {
+	in  io.Reader
+	out io.Writer
+	err io.Writer
+}
+
+type shjump struct {
+	kind string
+	n    int
+}
+
+type shbuiltin func(s *shell, sio shio, args []string) (int, error)
+
+var shspecial map[string]shbuiltin
+var shbuiltins map[string]shbuiltin
+
+func init() {
+	shspecial = map[string]shbuiltin{
+		":":        func(*shell, shio, []string) (int, error) { return 0, nil },
+		".":        (*shell).dot,
+		"break":    (*shell).jump,
+		"continue": (*shell).jump,
+		"eval":     (*shell).eval,
+		"exec":     (*shell).exec,
+		"exit":     (*shell).exit,
+		"export":   (*shell).export,
+		"return":   (*shell).jump,
+		"set":      (*shell).set,
+		"shift":    (*shell).shift,
+		"trap":     (*shell).trap,
+		"unset":    (*shell).unset,
+	}
+	shbuiltins = map[string]shbuiltin{
+		"[":    (*shell).test,
+		"cd":   (*shell).cd,
+		"echo": (*shell).echo,
+		"pwd":  (*shell).pwd,
+		"read": (*shell).read,
+		"test": (*shell).test,
+		"wait": (*shell).wait,
+	}
+}
+
+func newshell(cmd *Cmd) *shell {
+	s := &shell{
+		cmd:   cmd,
+		vars:  make(map[string]*shvar),
+		funcs: make(map[string]*shcompound),
+		arg0:  "sh",
+		jobs:  new(sync.WaitGroup),
+		bg:    make(map[int]*shjob),
+		traps: make(map[string]string),
+	}
+	for _, kv := range cmd.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok && shname(k) {
+			s.vars[k] = &shvar{val: v, export: true}
+		}
+	}
+	dir := cmd.Dir
+	if d, ok := cmd.FS.(*dirFS); ok && dir == "" {
+		dir = d.dir
+	}
+	s.chdir(dir)
+	return s
+}
+
+// chdir sets the working directory used for files and commands.
+func (s *shell) chdir(dir string) {
+	fsys := s.cmd.FS
+	if d, ok := fsys.(*dirFS); ok {
+		fsys = d.FS
+	}
+	s.dir, s.fsys = dir, fsys
+	if dir != "" {
+		s.fsys = &dirFS{fsys, dir}
+	}
+}
+
+func (s *shell) stdio() shio {
+	return shio{s.cmd.Stdin, s.cmd.Stdout, s.cmd.Stderr}
+}
+
+func (s *shell) subshell() *shell {
+	sub := &shell{
+		cmd:      s.cmd,
+		vars:     make(map[string]*shvar, len(s.vars)),
+		funcs:    make(map[string]*shcompound, len(s.funcs)),
+		arg0:     s.arg0,
+		args:     append([]string(nil), s.args...),
+		status:   s.status,
+		errexit:  s.errexit,
+		pipefail: s.pipefail,
+		jobs:     new(sync.WaitGroup),
+		bg:       make(map[int]*shjob),
+		dir:      s.dir,
+		fsys:     s.fsys,
+		traps:    make(map[string]string),
+	}
+	for k, v := range s.vars {
+		sub.vars[k] = &shvar{v.val, v.export}
+	}
+	for k, v := range s.traps {
+		if v == "" {
+			sub.traps[k] = v // Ignored conditions stay ignored.
+		}
+	}
+	for k, v := range s.funcs {
+		sub.funcs[k] = v
+	}
+	return sub
+}
+
+func (s *shell) source(src string, sio shio) int {
+	l, err := shparse(src)
+	if err != nil {
+		fmt.Fprintf(sio.err, "sh: %s\n", err)
+		return 2
+	}
+	code, err := s.runlist(l, sio)
+	return s.leave(code, err, sio)
+}
+
+// leave waits for the shell's jobs and runs its EXIT trap, returning the
+// shell's exit status.
+func (s *shell) leave(code int, err error, sio shio) int {
+	s.jobs.Wait()
+	var jump *shjump
+	if errors.As(err, &jump) && jump.kind == "exit" {
+		code = jump.n
+	}
+	action := s.traps["EXIT"]
+	if action == "" || s.cmd.ctx.Err() != nil {
+		return code
+	}
+	delete(s.traps, "EXIT")
+	l, err := shparse(action)
+	if err != nil {
+		fmt.Fprintf(sio.err, "sh: trap: %s\n", err)
+		return code
+	}
+	s.status = code
+	if _, err = s.runlist(l, sio); errors.As(err, &jump) && jump.kind == "exit" {
+		code = jump.n
+	}
+	s.jobs.Wait()
+	return code
+}
+
+func (j *shjump) Error() string {
+	return "bad " + j.kind
+}
+
+func (s *shell) get(name string) (string, bool) {
+	switch name {
+	case "?":
+		return strconv.Itoa(s.status), true
+	case "#":
+		return strconv.Itoa(len(s.args)), true
+	case "$":
+		return strconv.Itoa(s.cmd.Id), true
+	case "!":
+		return strconv.Itoa(s.lastjob), s.lastjob > 0
+	case "-":
+		if s.errexit {
+			return "e", true
+		}
+		return "", true
+	case "@", "*":
+		return strings.Join(s.args, s.ifsjoin(name)), len(s.args) > 0
+	case "0":
+		return s.arg0, true
+	}
+	if n, err := strconv.Atoi(name); err == nil {
+		if n < 1 || n > len(s.args) {
+			return "", false
+		}
+		return s.args[n-1], true
+	}
+	if v, ok := s.vars[name]; ok {
+		return v.val, true
+	}
+	return "", false
+}
+
+func (s *shell) setvar(name string, val string) {
+	if v, ok := s.vars[name]; ok {
+		v.val = val
+	} else {
+		s.vars[name] = &shvar{val: val}
+	}
+}
+
+func (s *shell) ifs() string {
+	if v, ok := s.vars["IFS"]; ok {
+		return v.val
+	}
+	return " \t\n"
+}
+
+func (s *shell) ifsjoin(name string) string {
+	if name == "@" {
+		return " "
+	} else if ifs := s.ifs(); ifs != "" {
+		return ifs[:1]
+	}
+	return ""
+}
+
+func (s *shell) environ(assigns map[string]string) []string {
+	env := make(map[string]string)
+	for k, v := range s.vars {
+		if v.export {
+			env[k] = v.val
+		}
+	}
+	for k, v := range assigns {
+		env[k] = v
+	}
+	var kvs []string
+	for k, v := range env {
+		kvs = append(kvs, k+"="+v)
+	}
+	sort.Strings(kvs)
+	return kvs
+}
+
+func (s *shell) runlist(l *shlist, sio shio) (code int, err error) {
+	code = s.status
+	for i, ao := range l.items {
+		if err = s.cmd.ctx.Err(); err != nil {
+			return 1, &shjump{"exit", 1}
+		}
+		if l.async[i] {
+			s.background(ao, sio)
+			code = 0
+			continue
+		}
+		if code, err = s.runandor(ao, sio); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (s *shell) background(ao *shandor, sio shio) {
+	sub := s.subshell()
+	c := CommandContext(s.cmd.ctx, "sh")
+	c.Stdin, c.Stdout, c.Stderr = sio.in, sio.out, sio.err
+	c.Parent = s.cmd
+	c.fn = func(*Cmd) int {
+		code, err := sub.runandor(ao, sio)
+		return sub.leave(code, err, sio)
+	}
+	c.Start()
+	job := &shjob{done: make(chan struct{})}
+	s.bg[c.Id] = job
+	s.lastjob = c.Id
+	s.jobs.Add(1)
+	go func() {
+		defer s.jobs.Done()
+		_ = c.Wait()
+		job.code = c.ExitCode
+		close(job.done)
+	}()
+}
+
+func (s *shell) runandor(ao *shandor, sio shio) (code int, err error) {
+	for i, pipe := range ao.pipes {
+		if i > 0 {
+			if op := ao.ops[i-1]; op == "&&" && code != 0 || op == "||" && code == 0 {
+				continue
+			}
+		}
+		if i < len(ao.pipes)-1 {
+			s.noerr++
+		}
+		code, err = s.runpipe(pipe, sio)
+		if i < len(ao.pipes)-1 {
+			s.noerr--
+		}
+		if err != nil {
+			return
+		}
+		// Only the last command of an AND-OR list is subject to set -e.
+		if i == len(ao.pipes)-1 && !pipe.bang && s.errexit && s.noerr == 0 && code != 0 {
+			return code, &shjump{"exit", code}
+		}
+	}
+	return
+}
+
+func (s *shell) runpipe(pipe *shpipe, sio shio) (code int, err error) {
+	if pipe.bang {
+		s.noerr++
+		defer func() { s.noerr-- }()
+	}
+	if len(pipe.cmds) == 1 {
+		code, err = s.runcmd(pipe.cmds[0], sio)
+	} else {
+		cmds := make([]*Cmd, len(pipe.cmds))
+		for i, node := range pipe.cmds {
+			sub := s.subshell()
+			cmds[i] = CommandContext(s.cmd.ctx, "sh")
+			cmds[i].Stderr = sio.err
+			cmds[i].Parent = s.cmd
+			cmds[i].fn = func(node shnode) CmdFunc {
+				return func(c *Cmd) int {
+					sio := shio{c.Stdin, c.Stdout, c.Stderr}
+					code, err := sub.runcmd(node, sio)
+					return sub.leave(code, err, sio)
+				}
+			}(node)
+		}
+		cmds[0].Stdin = sio.in
+		cmds[len(cmds)-1].Stdout = sio.out
+		p := NewPipeline(cmds...)
+		p.Pipefail = s.pipefail
+		code = p.Run()
+	}
+	if pipe.bang {
+		code = shbool(code != 0)
+	}
+	s.status = code
+	return
+}
+
+func (s *shell) runcmd(node shnode, sio shio) (code int, err error) {
+	switch n := node.(type) {
+	case *shsimple:
+		code, err = s.runsimple(n, sio)
+	case *shcompound:
+		code, err = s.runcompound(n, sio)
+	case *shfuncdef:
+		s.funcs[n.name] = n.body
+	}
+	var jump *shjump
+	if errors.As(err, &jump) && jump.kind == "exit" {
+		code = jump.n
+	}
+	s.status = code
+	return
+}
+
+func (s *shell) runcompound(n *shcompound, sio shio) (code int, err error) {
+	var closers []io.Closer
+	sio, closers, err = s.redirect(n.redirs, sio)
+	defer closeall(closers)
+	if err != nil {
+		fmt.Fprintf(sio.err, "sh: %s\n", err)
+		return 1, nil
+	}
+	switch body := n.body.(type) {
+	case *shbrace:
+		return s.runlist(body.body, sio)
+	case *shsubshell:
+		sub := s.subshell()
+		code, err = sub.runlist(body.body, sio)
+		return sub.leave(code, err, sio), nil
+	case *shif:
+		return s.runif(body, sio)
+	case *shloop:
+		return s.runloop(body, sio)
+	case *shfor:
+		return s.runfor(body, sio)
+	case *shcase:
+		return s.runcase(body, sio)
+	}
+	return 0, nil
+}
+
+func (s *shell) runcond(l *shlist, sio shio) (bool, error) {
+	s.noerr++
+	defer func() { s.noerr-- }()
+	code, err := s.runlist(l, sio)
+	return code == 0, err
+}
+
+func (s *shell) runif(n *shif, sio shio) (int, error) {
+	for i, cond := range n.conds {
+		if ok, err := s.runcond(cond, sio); err != nil {
+			return 1, err
+		} else if ok {
+			return s.runlist(n.bodies[i], sio)
+		}
+	}
+	if n.els != nil {
+		return s.runlist(n.els, sio)
+	}
+	return 0, nil
+}
+
+func (s *shell) runloop(n *shloop, sio shio) (code int, err error) {
+	for {
+		ok, err := s.runcond(n.cond, sio)
+		if err != nil {
+			return 1, err
+		} else if ok == n.until {
+			return code, nil
+		}
+		var brk bool
+		if code, brk, err = s.runbody(n.body, sio); err != nil || brk {
+			return code, err
+		}
+	}
+}
+
+func (s *shell) runfor(n *shfor, sio shio) (code int, err error) {
+	items := s.args
+	if n.in {
+		items = nil
+		for _, w := range n.words {
+			fields, err := s.fields(w, sio)
+			if err != nil {
+				return 1, err
+			}
+			items = append(items, fields...)
+		}
+	}
+	for _, item := range items {
+		s.setvar(n.name, item)
+		var brk bool
+		if code, brk, err = s.runbody(n.body, sio); err != nil || brk {
+			return code, err
+		}
+	}
+	return
+}
+
+// runbody runs a loop body, reporting whether the loop should stop.
+func (s *shell) runbody(body *shlist, sio shio) (int, bool, error) {
+	code, err := s.runlist(body, sio)
+	var jump *shjump
+	if !errors.As(err, &jump) {
+		return code, false, err
+	} else if jump.kind != "break" && jump.kind != "continue" {
+		return code, true, err
+	} else if jump.n > 1 {
+		jump.n--
+		return code, true, jump
+	}
+	return code, jump.kind == "break", nil
+}
+
+func (s *shell) runcase(n *shcase, sio shio) (int, error) {
+	word, err := s.expand(s.tilde(n.word, false), sio)
+	if err != nil {
+		return 1, err
+	}
+	for _, item := range n.items {
+		for _, pw := range item.patterns {
+			pat, err := s.pattern(pw, sio)
+			if err != nil {
+				return 1, err
+			}
+			if shmatch(pat, word) {
+				return s.runlist(item.body, sio)
+			}
+		}
+	}
+	return 0, nil
+}
+
+func (s *shell) runsimple(n *shsimple, sio shio) (code int, err error) {
+	subs := s.subs
+	var argv []string
+	for _, w := range n.words {
+		fields, err := s.fields(w, sio)
+		if err != nil {
+			return 1, err
+		}
+		argv = append(argv, fields...)
+	}
+	assigns := make(map[string]string)
+	for _, a := range n.assigns {
+		if assigns[a.name], err = s.expand(s.tilde(a.value, true), sio); err != nil {
+			return 1, err
+		}
+		if len(argv) == 0 {
+			// Later assignments may refer to earlier ones.
+			s.setvar(a.name, assigns[a.name])
+		}
+	}
+	var closers []io.Closer
+	sio, closers, err = s.redirect(n.redirs, sio)
+	defer closeall(closers)
+	if err != nil {
+		fmt.Fprintf(sio.err, "sh: %s\n", err)
+		return 1, nil
+	}
+	if len(argv) == 0 {
+		if s.subs == subs {
+			return 0, nil
+		}
+		return s.status, nil
+	}
+	if fn, ok := shspecial[argv[0]]; ok {
+		for k, v := range assigns {
+			s.setvar(k, v)
+		}
+		return fn(s, sio, argv)
+	} else if body, ok := s.funcs[argv[0]]; ok {
+		defer s.scope(assigns)()
+		return s.call(body, argv, sio)
+	} else if fn, ok := shbuiltins[argv[0]]; ok {
+		defer s.scope(assigns)()
+		return fn(s, sio, argv)
+	}
+	return s.spawn(argv, assigns, sio), nil
+}
+
+// scope sets assigns as exported variables until the returned function
+// restores their previous values.
+func (s *shell) scope(assigns map[string]string) func() {
+	saved := make(map[string]*shvar, len(assigns))
+	for k, v := range assigns {
+		saved[k] = s.vars[k]
+		s.vars[k] = &shvar{val: v, export: true}
+	}
+	return func() {
+		for k, v := range saved {
+			if v == nil {
+				delete(s.vars, k)
+			} else {
+				s.vars[k] = v
+			}
+		}
+	}
+}
+
+func (s *shell) spawn(argv []string, assigns map[string]string, sio shio) int {
+	c := CommandContext(s.cmd.ctx, argv...)
+	if _, ok := Bees[path.Base(argv[0])]; !ok {
+		pathvar, ok := assigns["PATH"]
+		if !ok {
+			pathvar, _ = s.get("PATH")
+		}
+		file, err := s.lookpath(argv[0], pathvar)
+		if err != nil {
+			fmt.Fprintf(sio.err, "sh: %s: not found\n", argv[0])
+			return 127
+		}
+		c.Path = file
+	}
+	c.Fallback = true
+	c.Stdin = sio.in
+	c.Stdout = sio.out
+	c.Stderr = sio.err
+	c.Env = s.environ(assigns)
+	c.Dir = s.dir
+	c.FS = s.fsys
+	c.Clock = s.cmd.Clock
+	c.Parent = s.cmd
+	return c.Run()
+}
+
+// lookpath finds the executable for name in the directories of pathvar,
+// resolving relative paths against the shell's working directory.
+func (s *shell) lookpath(name string, pathvar string) (string, error) {
+	files := []string{name}
+	if !strings.ContainsRune(name, '/') && !strings.ContainsRune(name, filepath.Separator) {
+		files = nil
+		for _, dir := range filepath.SplitList(pathvar) {
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+	for _, file := range files {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(s.dir, file)
+		}
+		file, err := filepath.Abs(file)
+		if err != nil {
+			continue
+		}
+		if file, err = exec.LookPath(file); err == nil {
+			return file, nil
+		}
+	}
+	return "", exec.ErrNotFound
+}
+
+func (s *shell) call(body *shcompound, argv []string, sio shio) (int, error) {
+	args := s.args
+	s.args = argv[1:]
+	defer func() { s.args = args }()
+	code, err := s.runcompound(body, sio)
+	var jump *shjump
+	if errors.As(err, &jump) && jump.kind == "return" {
+		return jump.n, nil
+	}
+	return code, err
+}
+
+func (s *shell) redirect(redirs []*shredir, sio shio) (shio, []io.Closer, error) {
+	var closers []io.Closer
+	for _, r := range redirs {
+		if r.fd > 2 {
+			return sio, closers, fmt.Errorf("unsupported file descriptor: %d", r.fd)
+		}
+		var target string
+		var err error
+		if r.target != nil {
+			if target, err = s.expand(s.tilde(r.target, false), sio); err != nil {
+				return sio, closers, err
+			}
+		}
+		var rd io.Reader
+		var wr io.Writer
+		switch r.op {
+		case "<<", "<<-":
+			var body string
+			if body, err = s.expand(r.body, sio); err != nil {
+				return sio, closers, err
+			}
+			rd = strings.NewReader(body)
+		case "<":
+			if target == "/dev/null" {
+				rd = strings.NewReader("")
+				break
+			}
+			f, err := s.fsys.Open(target)
+			if err != nil {
+				return sio, closers, err
+			}
+			closers = append(closers, f)
+			rd = f
+		case "<>":
+			if target == "/dev/null" {
+				rd, wr = strings.NewReader(""), io.Discard
+				break
+			}
+			f, err := s.fsys.ReadWrite(target)
+			if err != nil {
+				return sio, closers, err
+			}
+			closers = append(closers, f)
+			rd, wr = f, f
+		case ">", ">|", ">>":
+			if target == "/dev/null" {
+				wr = io.Discard
+				break
+			}
+			var f File
+			if r.op == ">>" {
+				f, err = s.fsys.Append(target)
+			} else {
+				f, err = s.fsys.Create(target)
+			}
+			if err != nil {
+				return sio, closers, err
+			}
+			closers = append(closers, f)
+			wr = f
+		case "<&", ">&":
+			switch target {
+			case "-":
+				rd, wr = strings.NewReader(""), io.Discard
+			case "0":
+				rd = sio.in
+			case "1":
+				wr = sio.out
+			case "2":
+				wr = sio.err
+			default:
+				if _, err := strconv.Atoi(target); err == nil {
+					return sio, closers, fmt.Errorf("unsupported file descriptor: %s", target)
+				}
+				return sio, closers, fmt.Errorf("bad file descriptor: %s", target)
+			}
+		}
+		switch {
+		case r.fd == 0 && rd != nil:
+			sio.in = rd
+		case r.fd == 1 && wr != nil:
+			sio.out = wr
+		case r.fd == 2 && wr != nil:
+			sio.err = wr
+		default:
+			return sio, closers, fmt.Errorf("bad redirection: %d%s", r.fd, r.op)
+		}
+	}
+	return sio, closers, nil
+}
+
+func closeall(closers []io.Closer) {
+	for _, c := range closers {
+		_ = c.Close()
+	}
+}
+
+type shfield struct {
+	s    strings.Builder
+	pat  strings.Builder
+	glob bool
+	keep bool
+}
+
+type shexpander struct {
+	s      *shell
+	sio    shio
+	fields []*shfield
+	cur    *shfield
+}
+
+func (e *shexpander) add(text string, quoted bool) {
+	if text == "" && !quoted {
+		return
+	}
+	if e.cur == nil {
+		e.cur = &shfield{}
+	}
+	e.cur.s.WriteString(text)
+	if quoted {
+		e.cur.pat.WriteString(shescape(text))
+		e.cur.keep = true
+	} else {
+		e.cur.pat.WriteString(text)
+		e.cur.glob = e.cur.glob || strings.ContainsAny(text, "*?[")
+	}
+}
+
+func (e *shexpander) end() {
+	if e.cur != nil {
+		e.fields = append(e.fields, e.cur)
+		e.cur = nil
+	}
+}
+
+func (e *shexpander) split(text string) {
+	ifs := e.s.ifs()
+	for _, r := range text {
+		if !strings.ContainsRune(ifs, r) {
+			e.add(string(r), false)
+		} else if strings.ContainsRune(" \t\n", r) {
+			e.end()
+		} else {
+			if e.cur == nil {
+				e.cur = &shfield{keep: true}
+			}
+			e.cur.keep = true
+			e.end()
+		}
+	}
+}
+
+func (e *shexpander) word(w *shword, quoted bool) error {
+	for _, part := range w.parts {
+		switch part.kind {
+		case shlit:
+			e.add(part.text, quoted)
+		case shsq:
+			e.add(part.text, true)
+		case shdq:
+			if !part.word.hasat() {
+				e.add("", true)
+			}
+			if err := e.word(part.word, true); err != nil {
+				return err
+			}
+		case shparam:
+			if part.op == "" && part.text == "@" || !quoted && part.text == "*" {
+				for i, arg := range e.s.args {
+					if i > 0 && quoted {
+						e.end()
+					} else if i > 0 {
+						e.split(e.s.ifsjoin("@"))
+					}
+					if quoted {
+						e.add(arg, true)
+					} else {
+						e.split(arg)
+					}
+				}
+				continue
+			}
+			val, err := e.s.param(part, e.sio)
+			if err != nil {
+				return err
+			}
+			e.value(val, quoted)
+		case shcmdsub:
+			val, err := e.s.cmdsub(part.list, e.sio)
+			if err != nil {
+				return err
+			}
+			e.value(val, quoted)
+		case sharith:
+			val, err := e.s.arith(part.word, e.sio)
+			if err != nil {
+				return err
+			}
+			e.value(val, quoted)
+		}
+	}
+	return nil
+}
+
+func (e *shexpander) value(val string, quoted bool) {
+	if quoted {
+		e.add(val, true)
+	} else {
+		e.split(val)
+	}
+}
+
+func (w *shword) hasat() bool {
+	for _, part := range w.parts {
+		if part.kind == shparam && part.op == "" && part.text == "@" {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *shell) fields(w *shword, sio shio) ([]string, error) {
+	e := &shexpander{s: s, sio: sio}
+	if err := e.word(s.tilde(w, false), false); err != nil {
+		return nil, err
+	}
+	e.end()
+	var fields []string
+	for _, f := range e.fields {
+		if f.glob {
+			if matches := s.glob(f.pat.String()); len(matches) > 0 {
+				fields = append(fields, matches...)
+				continue
+			}
+		}
+		if f.s.Len() > 0 || f.keep {
+			fields = append(fields, f.s.String())
+		}
+	}
+	return fields, nil
+}
+
+// tilde returns w with its unquoted leading ~ replaced by $HOME, as well as
+// each ~ following a colon in an assignment.
+func (s *shell) tilde(w *shword, assign bool) *shword {
+	home, ok := s.get("HOME")
+	if !ok || w == nil {
+		return w
+	}
+	var parts []*shpart
+	start := true
+	for i, part := range w.parts {
+		if part.kind != shlit {
+			parts = append(parts, part)
+			start = false
+			continue
+		}
+		var b strings.Builder
+		text := part.text
+		for j := 0; j < len(text); j++ {
+			c := text[j]
+			if start && c == '~' && (j+1 == len(text) && i == len(w.parts)-1 ||
+				j+1 < len(text) && (text[j+1] == '/' || assign && text[j+1] == ':')) {
+				parts = append(parts, &shpart{kind: shlit, text: b.String()},
+					&shpart{kind: shsq, text: home})
+				b.Reset()
+			} else {
+				b.WriteByte(c)
+			}
+			start = assign && c == ':'
+		}
+		parts = append(parts, &shpart{kind: shlit, text: b.String()})
+	}
+	return &shword{parts: parts}
+}
+
+func (s *shell) expand(w *shword, sio shio) (string, error) {
+	if w == nil {
+		return "", nil
+	}
+	var b strings.Builder
+	for _, part := range w.parts {
+		switch part.kind {
+		case shlit, shsq:
+			b.WriteString(part.text)
+		case shdq:
+			v, err := s.expand(part.word, sio)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(v)
+		case shparam:
+			v, err := s.param(part, sio)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(v)
+		case shcmdsub:
+			v, err := s.cmdsub(part.list, sio)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(v)
+		case sharith:
+			v, err := s.arith(part.word, sio)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(v)
+		}
+	}
+	return b.String(), nil
+}
+
+func (s *shell) pattern(w *shword, sio shio) (string, error) {
+	var b strings.Builder
+	for _, part := range w.parts {
+		if part.kind == shlit {
+			b.WriteString(part.text)
+			continue
+		}
+		v, err := s.expand(&shword{parts: []*shpart{part}}, sio)
+		if err != nil {
+			return "", err
+		}
+		if part.kind == shparam || part.kind == shcmdsub || part.kind == sharith {
+			b.WriteString(v)
+		} else {
+			b.WriteString(shescape(v))
+		}
+	}
+	return b.String(), nil
+}
+
+func (s *shell) param(part *shpart, sio shio) (string, error) {
+	val, set := s.get(part.text)
+	switch part.op {
+	case "":
+		return val, nil
+	case "len":
+		return strconv.Itoa(len([]rune(val))), nil
+	case "-", ":-":
+		if !set || part.op == ":-" && val == "" {
+			return s.expand(part.word, sio)
+		}
+	case "=", ":=":
+		if !set || part.op == ":=" && val == "" {
+			if !shname(part.text) {
+				return "", s.fatal(sio, "%s: bad assignment", part.text)
+			}
+			v, err := s.expand(part.word, sio)
+			if err != nil {
+				return "", err
+			}
+			s.setvar(part.text, v)
+			return v, nil
+		}
+	case "?", ":?":
+		if !set || part.op == ":?" && val == "" {
+			msg, err := s.expand(part.word, sio)
+			if err != nil {
+				return "", err
+			} else if msg == "" {
+				msg = "parameter not set"
+			}
+			return "", s.fatal(sio, "%s: %s", part.text, msg)
+		}
+	case "+", ":+":
+		if set && (part.op == "+" || val != "") {
+			return s.expand(part.word, sio)
+		}
+		return "", nil
+	case "#", "##", "%", "%%":
+		pat, err := s.pattern(part.word, sio)
+		if err != nil {
+			return "", err
+		}
+		return shtrim(val, pat, part.op), nil
+	}
+	return val, nil
+}
+
+func (s *shell) fatal(sio shio, format string, a ...any) error {
+	fmt.Fprintf(sio.err, "sh: "+format+"\n", a...)
+	return &shjump{"exit", 1}
+}
+
+func shtrim(val string, pat string, op string) string {
+	r := []rune(val)
+	switch op {
+	case "#":
+		for i := 0; i <= len(r); i++ {
+			if shmatch(pat, string(r[:i])) {
+				return string(r[i:])
+			}
+		}
+	case "##":
+		for i := len(r); i >= 0; i-- {
+			if shmatch(pat, string(r[:i])) {
+				return string(r[i:])
+			}
+		}
+	case "%":
+		for i := len(r); i >= 0; i-- {
+			if shmatch(pat, string(r[i:])) {
+				return string(r[:i])
+			}
+		}
+	case "%%":
+		for i := 0; i <= len(r); i++ {
+			if shmatch(pat, string(r[i:])) {
+				return string(r[:i])
+			}
+		}
+	}
+	return val
+}
+
+func (s *shell) cmdsub(l *shlist, sio shio) (string, error) {
+	var out strings.Builder
+	sub := s.subshell()
+	code, err := sub.runlist(l, shio{sio.in, &out, sio.err})
+	s.status = sub.leave(code, err, shio{sio.in, &out, sio.err})
+	s.subs++
+	return strings.TrimRight(out.String(), "\n"), nil
+}
+
+func (s *shell) arith(w *shword, sio shio) (string, error) {
+	src, err := s.expand(w, sio)
+	if err != nil {
+		return "", err
+	}
+	a := &sharithp{s: s, src: src}
+	n, err := a.parse()
+	if err != nil {
+		return "", s.fatal(sio, "bad arithmetic expression: %s", err)
+	}
+	return strconv.FormatInt(n, 10), nil
+}
+
+func shescape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func shunescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func (s *shell) glob(pat string) []string {
+	var dirs []string
+	segments := strings.Split(pat, "/")
+	if segments[0] == "" {
+		dirs = []string{"/"}
+		segments = segments[1:]
+	} else {
+		dirs = []string{""}
+	}
+	for _, seg := range segments {
+		var next []string
+		if seg == "" {
+			continue
+		}
+		for _, dir := range dirs {
+			if !shglobbable(seg) {
+				next = append(next, path.Join(dir, shunescape(seg)))
+				continue
+			}
+			readdir := dir
+			if readdir == "" {
+				readdir = "."
+			}
+			entries, err := s.fsys.ReadDir(readdir)
+			if err != nil {
+				continue
+			}
+			for _, e := range entries {
+				name := e.Name()
+				if name[0] == '.' && seg[0] != '.' {
+					continue
+				}
+				if shmatch(seg, name) {
+					next = append(next, path.Join(dir, name))
+				}
+			}
+		}
+		dirs = next
+	}
+	var matches []string
+	for _, m := range dirs {
+		if _, err := s.fsys.Stat(m); err == nil {
+			matches = append(matches, m)
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+func shglobbable(pat string) bool {
+	for i := 0; i < len(pat); i++ {
+		if pat[i] == '\\' {
+			i++
+		} else if strings.IndexByte("*?[", pat[i]) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func shmatch(pat string, s string) bool {
+	p, r := []rune(pat), []rune(s)
+	px, rx := 0, 0
+	starpx, starrx := -1, -1
+	for px < len(p) || rx < len(r) {
+		if px < len(p) {
+			switch c := p[px]; c {
+			case '*':
+				starpx, starrx = px, rx
+				px++
+				continue
+			case '?':
+				if rx < len(r) {
+					px++
+					rx++
+					continue
+				}
+			case '[':
+				if rx < len(r) {
+					if ok, n := shclass(p[px:], r[rx]); n == 0 && r[rx] == '[' {
+						px++
+						rx++
+						continue
+					} else if n > 0 && ok {
+						px += n
+						rx++
+						continue
+					}
+				}
+			case '\\':
+				if px+1 < len(p) {
+					if rx < len(r) && p[px+1] == r[rx] {
+						px += 2
+						rx++
+						continue
+					}
+				} else if rx < len(r) && r[rx] == '\\' {
+					px++
+					rx++
+					continue
+				}
+			default:
+				if rx < len(r) && r[rx] == c {
+					px++
+					rx++
+					continue
+				}
+			}
+		}
+		if starpx >= 0 && starrx < len(r) {
+			starrx++
+			px, rx = starpx+1, starrx
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// shclass matches r against the bracket expression at the start of p,
+// returning the length of the expression or 0 if it is malformed.
+func shclass(p []rune, r rune) (bool, int) {
+	i := 1
+	negate := false
+	if i < len(p) && (p[i] == '!' || p[i] == '^') {
+		negate = true
+		i++
+	}
+	var match bool
+	for first := true; i < len(p); first = false {
+		c := p[i]
+		if c == ']' && !first {
+			return match != negate, i + 1
+		}
+		if c == '[' && i+1 < len(p) && p[i+1] == ':' {
+			end := strings.Index(string(p[i+2:]), ":]")
+			if end >= 0 {
+				name := string(p[i+2 : i+2+len([]rune(string(p[i+2:])[:end]))])
+				match = match || shctype(name, r)
+				i += 2 + len([]rune(name)) + 2
+				continue
+			}
+		}
+		if c == '\\' && i+1 < len(p) {
+			i++
+			c = p[i]
+		}
+		if i+2 < len(p) && p[i+1] == '-' && p[i+2] != ']' {
+			if c <= r && r <= p[i+2] {
+				match = true
+			}
+			i += 3
+			continue
+		}
+		if c == r {
+			match = true
+		}
+		i++
+	}
+	return false, 0
+}
+
+func shctype(class string, r rune) bool {
+	switch class {
+	case "alpha":
+		return runealpha(r)
+	case "digit":
+		return '0' <= r && r <= '9'
+	case "alnum":
+		return runealpha(r) || '0' <= r && r <= '9'
+	case "upper":
+		return 'A' <= r && r <= 'Z'
+	case "lower":
+		return 'a' <= r && r <= 'z'
+	case "space":
+		return strings.ContainsRune(" \t\n\r\f\v", r)
+	case "blank":
+		return r == ' ' || r == '\t'
+	case "punct":
+		return r > ' ' && r < 0x7f && !runealpha(r) && !('0' <= r && r <= '9')
+	case "xdigit":
+		return '0' <= r && r <= '9' || 'a' <= r && r <= 'f' || 'A' <= r && r <= 'F'
+	}
+	return false
+}
+
+func shbool(b bool) int {
+	if b {
+		return 0
+	}
+	return 1
+}
+
+func (s *shell) dot(sio shio, args []string) (int, error) {
+	if len(args) < 2 {
+		fmt.Fprintln(sio.err, "sh: .: bad argc: want 1")
+		return 2, nil
+	}
+	buf, err := readfile(s.fsys, args[1])
+	if err != nil {
+		return 1, s.fatal(sio, ".: %s", err)
+	}
+	l, err := shparse(string(buf))
+	if err != nil {
+		return 2, s.fatal(sio, "%s: %s", args[1], err)
+	}
+	return s.runlist(l, sio)
+}
+
+func (s *shell) jump(sio shio, args []string) (int, error) {
+	n := 1
+	if args[0] == "return" {
+		n = s.status
+	}
+	if len(args) > 1 {
+		var err error
+		if n, err = strconv.Atoi(args[1]); err != nil {
+			fmt.Fprintf(sio.err, "sh: %s: bad number: %s\n", args[0], args[1])
+			return 2, nil
+		}
+	}
+	if args[0] != "return" {
+		return 0, &shjump{args[0], n}
+	}
+	return n, &shjump{args[0], n}
+}
+
+func (s *shell) eval(sio shio, args []string) (int, error) {
+	l, err := shparse(strings.Join(args[1:], " "))
+	if err != nil {
+		fmt.Fprintf(sio.err, "sh: eval: %s\n", err)
+		return 2, nil
+	}
+	return s.runlist(l, sio)
+}
+
+func (s *shell) exec(sio shio, args []string) (int, error) {
+	if len(args) < 2 {
+		return 0, nil
+	}
+	code := s.spawn(args[1:], nil, sio)
+	return code, &shjump{"exit", code}
+}
+
+func (s *shell) exit(sio shio, args []string) (int, error) {
+	code := s.status
+	if len(args) > 1 {
+		var err error
+		if code, err = strconv.Atoi(args[1]); err != nil {
+			fmt.Fprintf(sio.err, "sh: exit: bad number: %s\n", args[1])
+			code = 2
+		}
+	}
+	return code, &shjump{"exit", code}
+}
+
+func (s *shell) export(sio shio, args []string) (int, error) {
+	if len(args) < 2 || args[1] == "-p" {
+		for _, kv := range s.environ(nil) {
+			k, v, _ := strings.Cut(kv, "=")
+			fmt.Fprintf(sio.out, "export %s=%s\n", k, shquote(v))
+		}
+		return 0, nil
+	}
+	for _, arg := range args[1:] {
+		name, val, ok := strings.Cut(arg, "=")
+		if !shname(name) {
+			fmt.Fprintf(sio.err, "sh: export: bad variable name: %s\n", name)
+			return 1, nil
+		}
+		if ok {
+			s.setvar(name, val)
+		} else if _, set := s.vars[name]; !set {
+			s.vars[name] = &shvar{}
+		}
+		s.vars[name].export = true
+	}
+	return 0, nil
+}
+
+func (s *shell) set(sio shio, args []string) (int, error) {
+	if len(args) < 2 {
+		var names []string
+		for k := range s.vars {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			fmt.Fprintf(sio.out, "%s=%s\n", k, shquote(s.vars[k].val))
+		}
+		return 0, nil
+	}
+	args = args[1:]
+	for len(args) > 0 {
+		arg := args[0]
+		if arg == "--" {
+			s.args = append([]string(nil), args[1:]...)
+			return 0, nil
+		} else if len(arg) < 2 || arg[0] != '-' && arg[0] != '+' {
+			break
+		}
+		on := arg[0] == '-'
+		args = args[1:]
+		for _, opt := range arg[1:] {
+			switch opt {
+			case 'e':
+				s.errexit = on
+			case 'o':
+				if len(args) == 0 || args[0] != "pipefail" {
+					fmt.Fprintln(sio.err, "sh: set: bad option: -o")
+					return 2, nil
+				}
+				s.pipefail = on
+				args = args[1:]
+			default:
+				fmt.Fprintf(sio.err, "sh: set: bad option: %c%c\n", arg[0], opt)
+				return 2, nil
+			}
+		}
+	}
+	if len(args) > 0 {
+		s.args = append([]string(nil), args...)
+	}
+	return 0, nil
+}
+
+func (s *shell) shift(sio shio, args []string) (int, error) {
+	n := 1
+	if len(args) > 1 {
+		var err error
+		if n, err = strconv.Atoi(args[1]); err != nil || n < 0 {
+			fmt.Fprintf(sio.err, "sh: shift: bad number: %s\n", args[1])
+			return 2, nil
+		}
+	}
+	if n > len(s.args) {
+		fmt.Fprintf(sio.err, "sh: shift: can't shift that many\n")
+		return 1, nil
+	}
+	s.args = s.args[n:]
+	return 0, nil
+}
+
+func (s *shell) unset(_ shio, args []string) (int, error) {
+	funcs := false
+	for _, arg := range args[1:] {
+		switch arg {
+		case "-f":
+			funcs = true
+		case "-v":
+			funcs = false
+		default:
+			if funcs {
+				delete(s.funcs, arg)
+			} else {
+				delete(s.vars, arg)
+			}
+		}
+	}
+	return 0, nil
+}
+
+func (s *shell) echo(sio shio, args []string) (int, error) {
+	args = args[1:]
+	newline := true
+	if len(args) > 0 && args[0] == "-n" {
+		newline = false
+		args = args[1:]
+	}
+	out := strings.Join(args, " ")
+	if newline {
+		out += "\n"
+	}
+	_, err := fmt.Fprint(sio.out, out)
+	return shbool(err == nil), nil
+}
+
+func (s *shell) read(sio shio, args []string) (int, error) {
+	args = args[1:]
+	raw := false
+	if len(args) > 0 && args[0] == "-r" {
+		raw = true
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		args = []string{"REPLY"}
+	}
+	var line strings.Builder
+	var err error
+	buf := make([]byte, 1)
+	for {
+		var n int
+		if n, err = sio.in.Read(buf); n == 0 && err != nil {
+			break
+		} else if n == 0 {
+			continue
+		}
+		if buf[0] == '\\' && !raw {
+			if n, _ = sio.in.Read(buf); n == 1 && buf[0] != '\n' {
+				line.WriteByte(buf[0])
+			}
+			continue
+		} else if buf[0] == '\n' {
+			break
+		}
+		line.WriteByte(buf[0])
+	}
+	ifs := s.ifs()
+	rest := strings.TrimLeft(line.String(), ifs)
+	for i, name := range args {
+		if i == len(args)-1 {
+			s.setvar(name, strings.TrimRight(rest, ifs))
+			break
+		}
+		end := strings.IndexAny(rest, ifs)
+		if end < 0 {
+			s.setvar(name, rest)
+			rest = ""
+			continue
+		}
+		s.setvar(name, rest[:end])
+		rest = strings.TrimLeft(rest[end:], ifs)
+	}
+	if err != nil && line.Len() == 0 {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func (s *shell) wait(sio shio, args []string) (int, error) {
+	if len(args) < 2 {
+		s.jobs.Wait()
+		clear(s.bg)
+		return 0, nil
+	}
+	code := 0
+	for _, arg := range args[1:] {
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			fmt.Fprintf(sio.err, "sh: wait: bad number: %s\n", arg)
+			return 2, nil
+		}
+		job, ok := s.bg[id]
+		if !ok {
+			code = 127
+			continue
+		}
+		<-job.done
+		delete(s.bg, id)
+		code = job.code
+	}
+	return code, nil
+}
+
+func (s *shell) cd(sio shio, args []string) (int, error) {
+	args = args[1:]
+	if len(args) > 0 && (args[0] == "-L" || args[0] == "-P") {
+		args = args[1:]
+	}
+	var dir string
+	switch {
+	case len(args) > 1:
+		fmt.Fprintln(sio.err, "sh: cd: bad argc: want 0 or 1")
+		return 2, nil
+	case len(args) == 0:
+		dir, _ = s.get("HOME")
+	case args[0] == "-":
+		dir, _ = s.get("OLDPWD")
+	default:
+		dir = args[0]
+	}
+	if dir == "" {
+		fmt.Fprintln(sio.err, "sh: cd: bad directory")
+		return 1, nil
+	}
+	if info, err := s.fsys.Stat(dir); err != nil || !info.IsDir() {
+		fmt.Fprintf(sio.err, "sh: cd: bad directory: %s\n", dir)
+		return 1, nil
+	}
+	target := dir
+	if !filepath.IsAbs(dir) && !path.IsAbs(dir) {
+		target = filepath.Join(s.dir, dir)
+	}
+	pwd, _ := s.get("PWD")
+	newpwd := target
+	if !filepath.IsAbs(target) && !path.IsAbs(target) {
+		if filepath.IsAbs(pwd) || path.IsAbs(pwd) {
+			newpwd = filepath.Join(pwd, dir)
+		} else if abs, err := filepath.Abs(target); err == nil {
+			newpwd = abs
+		}
+	}
+	s.setvar("OLDPWD", pwd)
+	s.setvar("PWD", newpwd)
+	s.chdir(target)
+	if len(args) > 0 && args[0] == "-" {
+		fmt.Fprintln(sio.out, newpwd)
+	}
+	return 0, nil
+}
+
+func (s *shell) pwd(sio shio, args []string) (int, error) {
+	dir, _ := s.get("PWD")
+	if !filepath.IsAbs(dir) && !path.IsAbs(dir) {
+		var err error
+		if dir, err = filepath.Abs(s.dir); err != nil {
+			fmt.Fprintf(sio.err, "sh: pwd: %s\n", err)
+			return 1, nil
+		}
+	}
+	_, err := fmt.Fprintln(sio.out, dir)
+	return shbool(err == nil), nil
+}
+
+var shsignals = map[string]string{
+	"0": "EXIT", "1": "HUP", "2": "INT", "3": "QUIT", "6": "ABRT",
+	"14": "ALRM", "15": "TERM",
+	"EXIT": "EXIT", "HUP": "HUP", "INT": "INT", "QUIT": "QUIT", "ABRT": "ABRT",
+	"ALRM": "ALRM", "TERM": "TERM", "USR1": "USR1", "USR2": "USR2",
+	"PIPE": "PIPE", "CHLD": "CHLD",
+}
+
+// trap sets actions for trap conditions. Only the EXIT condition fires, since
+// bees do not receive signals.
+func (s *shell) trap(sio shio, args []string) (int, error) {
+	args = args[1:]
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		var conds []string
+		for k := range s.traps {
+			conds = append(conds, k)
+		}
+		sort.Strings(conds)
+		for _, k := range conds {
+			fmt.Fprintf(sio.out, "trap -- %s %s\n", shquote(s.traps[k]), k)
+		}
+		return 0, nil
+	}
+	action, conds := args[0], args[1:]
+	if _, err := strconv.Atoi(action); err == nil {
+		action, conds = "-", args
+	}
+	code := 0
+	for _, cond := range conds {
+		name, ok := shsignals[strings.TrimPrefix(cond, "SIG")]
+		if !ok {
+			fmt.Fprintf(sio.err, "sh: trap: bad condition: %s\n", cond)
+			code = 1
+		} else if action == "-" {
+			delete(s.traps, name)
+		} else {
+			s.traps[name] = action
+		}
+	}
+	return code, nil
+}
+
+func (s *shell) test(sio shio, args []string) (int, error) {
+	if args[0] == "[" {
+		if args[len(args)-1] != "]" {
+			fmt.Fprintln(sio.err, "sh: [: missing ]")
+			return 2, nil
+		}
+		args = args[:len(args)-1]
+	}
+	ok, err := s.testexpr(args[1:])
+	if err != nil {
+		fmt.Fprintf(sio.err, "sh: %s: %s\n", args[0], err)
+		return 2, nil
+	}
+	return shbool(ok), nil
+}
+
+func (s *shell) testexpr(args []string) (bool, error) {
+	switch len(args) {
+	case 0:
+		return false, nil
+	case 1:
+		return args[0] != "", nil
+	case 2:
+		if args[0] == "!" {
+			ok, err := s.testexpr(args[1:])
+			return !ok, err
+		}
+		return s.testunary(args[0], args[1])
+	case 3:
+		if shtestbinary[args[1]] {
+			return s.testbinary(args[0], args[1], args[2])
+		} else if args[0] == "!" {
+			ok, err := s.testexpr(args[1:])
+			return !ok, err
+		} else if args[0] == "(" && args[2] == ")" {
+			return s.testexpr(args[1:2])
+		}
+	case 4:
+		if args[0] == "!" {
+			ok, err := s.testexpr(args[1:])
+			return !ok, err
+		} else if args[0] == "(" && args[3] == ")" {
+			return s.testexpr(args[1:3])
+		}
+	}
+	for i, arg := range args {
+		if arg == "-o" || arg == "-a" {
+			l, err := s.testexpr(args[:i])
+			if err != nil {
+				return false, err
+			}
+			r, err := s.testexpr(args[i+1:])
+			if err != nil {
+				return false, err
+			}
+			if arg == "-o" {
+				return l || r, nil
+			}
+			return l && r, nil
+		}
+	}
+	return false, fmt.Errorf("bad expression")
+}
+
+var shtestbinary = stringset("=", "!=", "-eq", "-ne", "-gt", "-ge", "-lt", "-le")
+
+func (s *shell) testunary(op string, arg string) (bool, error) {
+	switch op {
+	case "-n":
+		return arg != "", nil
+	case "-z":
+		return arg == "", nil
+	case "-t":
+		return false, nil
+	case "-e", "-f", "-d", "-s", "-r", "-w", "-x":
+		info, err := s.fsys.Stat(arg)
+		if err != nil {
+			return false, nil
+		}
+		switch op {
+		case "-f":
+			return info.Mode().IsRegular(), nil
+		case "-d":
+			return info.IsDir(), nil
+		case "-s":
+			return info.Size() > 0, nil
+		case "-x":
+			return info.IsDir() || info.Mode()&0111 != 0, nil
+		}
+		return true, nil
+	}
+	return false, fmt.Errorf("bad operator: %s", op)
+}
+
+func (s *shell) testbinary(l string, op string, r string) (bool, error) {
+	switch op {
+	case "=":
+		return l == r, nil
+	case "!=":
+		return l != r, nil
+	}
+	ln, err := strconv.ParseInt(strings.TrimSpace(l), 10, 64)
+	if err != nil {
+		return false, fmt.Errorf("bad number: %s", l)
+	}
+	rn, err := strconv.ParseInt(strings.TrimSpace(r), 10, 64)
+	if err != nil {
+		return false, fmt.Errorf("bad number: %s", r)
+	}
+	switch op {
+	case "-eq":
+		return ln == rn, nil
+	case "-ne":
+		return ln != rn, nil
+	case "-gt":
+		return ln > rn, nil
+	case "-ge":
+		return ln >= rn, nil
+	case "-lt":
+		return ln < rn, nil
+	default:
+		return ln <= rn, nil
+	}
+}
+
+func shquote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+type sharithp struct {
+	s    *shell
+	src  string
+	pos  int
+	skip int
+}
+
+var sharithbinary = [][]string{
+	{"||"}, {"&&"}, {"|"}, {"^"}, {"&"}, {"==", "!="}, {"<=", ">=", "<", ">"},
+	{"<<", ">>"}, {"+", "-"}, {"*", "/", "%"},
+}
+
+func (a *sharithp) parse() (int64, error) {
+	n, err := a.assign()
+	if err != nil {
+		return 0, err
+	}
+	if a.space(); a.pos < len(a.src) {
+		return 0, fmt.Errorf("bad token: %s", a.src[a.pos:])
+	}
+	return n, nil
+}
+
+func (a *sharithp) space() {
+	for a.pos < len(a.src) && strings.ContainsRune(" \t\n", rune(a.src[a.pos])) {
+		a.pos++
+	}
+}
+
+func (a *sharithp) match(op string) bool {
+	a.space()
+	if !strings.HasPrefix(a.src[a.pos:], op) {
+		return false
+	}
+	// Don't mistake the start of a longer operator for a shorter one.
+	rest := a.src[a.pos+len(op):]
+	switch op {
+	case "|", "&", "<", ">", "=", "!", "+", "-", "*", "/", "%", "^":
+		if strings.HasPrefix(rest, "=") || len(op) == 1 && strings.HasPrefix(rest, op) &&
+			strings.Contains("|&<>", op) {
+			return false
+		}
+	case "<<", ">>":
+		if strings.HasPrefix(rest, "=") {
+			return false
+		}
+	}
+	a.pos += len(op)
+	return true
+}
+
+func (a *sharithp) assign() (int64, error) {
+	start := a.pos
+	a.space()
+	name := a.name()
+	if name != "" {
+		for _, op := range []string{"=", "+=", "-=", "*=", "/=", "%=", "<<=", ">>=",
+			"&=", "^=", "|="} {
+			a.space()
+			if !strings.HasPrefix(a.src[a.pos:], op) ||
+				op == "=" && strings.HasPrefix(a.src[a.pos:], "==") {
+				continue
+			}
+			a.pos += len(op)
+			r, err := a.assign()
+			if err != nil {
+				return 0, err
+			}
+			l, err := a.value(name)
+			if err != nil {
+				return 0, err
+			}
+			n := r
+			if op != "=" {
+				if n, err = a.binary(op[:len(op)-1], l, r); err != nil {
+					return 0, err
+				}
+			}
+			if a.skip == 0 {
+				a.s.setvar(name, strconv.FormatInt(n, 10))
+			}
+			return n, nil
+		}
+	}
+	a.pos = start
+	return a.cond()
+}
+
+func (a *sharithp) cond() (int64, error) {
+	c, err := a.binaryexpr(0)
+	if err != nil || !a.match("?") {
+		return c, err
+	}
+	if c == 0 {
+		a.skip++
+	}
+	t, err := a.assign()
+	if c == 0 {
+		a.skip--
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !a.match(":") {
+		return 0, fmt.Errorf("bad conditional: missing :")
+	}
+	if c != 0 {
+		a.skip++
+	}
+	f, err := a.cond()
+	if c != 0 {
+		a.skip--
+	}
+	if c != 0 {
+		return t, err
+	}
+	return f, err
+}
+
+func (a *sharithp) binaryexpr(level int) (int64, error) {
+	if level >= len(sharithbinary) {
+		return a.unary()
+	}
+	l, err := a.binaryexpr(level + 1)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		var op string
+		for _, o := range sharithbinary[level] {
+			if a.match(o) {
+				op = o
+				break
+			}
+		}
+		if op == "" {
+			return l, nil
+		}
+		short := op == "||" && l != 0 || op == "&&" && l == 0
+		if short {
+			a.skip++
+		}
+		r, err := a.binaryexpr(level + 1)
+		if short {
+			a.skip--
+		}
+		if err != nil {
+			return 0, err
+		}
+		if l, err = a.binary(op, l, r); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (a *sharithp) binary(op string, l int64, r int64) (int64, error) {
+	b := func(v bool) int64 {
+		if v {
+			return 1
+		}
+		return 0
+	}
+	switch op {
+	case "||":
+		return b(l != 0 || r != 0), nil
+	case "&&":
+		return b(l != 0 && r != 0), nil
+	case "|":
+		return l | r, nil
+	case "^":
+		return l ^ r, nil
+	case "&":
+		return l & r, nil
+	case "==":
+		return b(l == r), nil
+	case "!=":
+		return b(l != r), nil
+	case "<":
+		return b(l < r), nil
+	case "<=":
+		return b(l <= r), nil
+	case ">":
+		return b(l > r), nil
+	case ">=":
+		return b(l >= r), nil
+	case "<<":
+		return l << uint64(r), nil
+	case ">>":
+		return l >> uint64(r), nil
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/", "%":
+		if r == 0 && a.skip > 0 {
+			return 0, nil
+		} else if r == 0 {
+			return 0, fmt.Errorf("bad divisor: 0")
+		} else if op == "/" {
+			return l / r, nil
+		}
+		return l % r, nil
+	}
+	return 0, fmt.Errorf("bad operator: %s", op)
+}
+
+func (a *sharithp) unary() (int64, error) {
+	switch {
+	case a.match("!"):
+		n, err := a.unary()
+		if n == 0 {
+			return 1, err
+		}
+		return 0, err
+	case a.match("~"):
+		n, err := a.unary()
+		return ^n, err
+	case a.match("-"):
+		n, err := a.unary()
+		return -n, err
+	case a.match("+"):
+		return a.unary()
+	}
+	return a.primary()
+}
+
+func (a *sharithp) primary() (int64, error) {
+	a.space()
+	if a.match("(") {
+		n, err := a.assign()
+		if err != nil {
+			return 0, err
+		} else if !a.match(")") {
+			return 0, fmt.Errorf("bad group: missing )")
+		}
+		return n, nil
+	}
+	if name := a.name(); name != "" {
+		return a.value(name)
+	}
+	start := a.pos
+	for a.pos < len(a.src) && (shnamerune(rune(a.src[a.pos]))) {
+		a.pos++
+	}
+	if start == a.pos {
+		if a.pos >= len(a.src) {
+			return 0, fmt.Errorf("bad EOF")
+		}
+		return 0, fmt.Errorf("bad token: %s", a.src[a.pos:])
+	}
+	return shatoi(a.src[start:a.pos])
+}
+
+func (a *sharithp) name() string {
+	start := a.pos
+	if a.pos < len(a.src) && (a.src[a.pos] == '_' || runealpha(rune(a.src[a.pos]))) {
+		for a.pos < len(a.src) && shnamerune(rune(a.src[a.pos])) {
+			a.pos++
+		}
+	}
+	return a.src[start:a.pos]
+}
+
+func (a *sharithp) value(name string) (int64, error) {
+	v, _ := a.s.get(name)
+	if v = strings.TrimSpace(v); v == "" {
+		return 0, nil
+	}
+	return shatoi(v)
+}
+
+func shatoi(s string) (int64, error) {
+	n, err := strconv.ParseInt(s, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad number: %s", s)
+	}
+	return n, nil
+}
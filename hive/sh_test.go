@@ -0,0 +1,347 @@
+package hive_test
+
+import (
+	"strings"
+	"testing"
+
+	"lesiw.io/buzzybox/hive"
+)
+
+func TestSh(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		args   []string
+		stdin  string
+		files  map[string]string
+		out    string
+		stderr string
+		code   int
+		check  map[string]string
+	}{{
+		name:   "echo",
+		script: "echo hello world",
+		out:    "hello world\n",
+	}, {
+		name:   "quoting",
+		script: `x=1; echo 'a  $x' "b  $x" c\ \ $x "\$x \"q\""`,
+		out:    "a  $x b  1 c  1 $x \"q\"\n",
+	}, {
+		name:   "field splitting",
+		script: `x="a  b"; for i in $x "$x"; do echo "<$i>"; done`,
+		out:    "<a>\n<b>\n<a  b>\n",
+	}, {
+		name:   "positional",
+		script: `echo $# "$1"; for a in "$@"; do echo "<$a>"; done; shift; echo $*`,
+		args:   []string{"a b", "c"},
+		out:    "2 a b\n<a b>\n<c>\nc\n",
+	}, {
+		name:   "parameter expansion",
+		script: `v=foo.tar.gz; echo ${v%%.*} ${v%.*} ${v#*.} ${v##*.} ${#v} ${u:-def} ${v:+set}`,
+		out:    "foo foo.tar tar.gz gz 10 def set\n",
+	}, {
+		name:   "assign default",
+		script: `echo ${x:=1}; echo $x`,
+		out:    "1\n1\n",
+	}, {
+		name:   "unset error",
+		script: `echo ${x:?not set}; echo unreachable`,
+		code:   1,
+	}, {
+		name:   "command substitution",
+		script: "echo $(echo a; echo b) \"$(echo c)\" `echo d`",
+		out:    "a b c d\n",
+	}, {
+		name:   "arithmetic",
+		script: `i=2; echo $((i * 3 + 1)) $((7 / 2)) $((7 % 2)) $((i < 3 ? 10 : 20)); : $((i += 5)); echo $i`,
+		out:    "7 3 1 10\n7\n",
+	}, {
+		name:   "pipeline",
+		script: `echo a b c | awk '{ print $2 }' | cat`,
+		out:    "b\n",
+	}, {
+		name:   "pipeline status",
+		script: `true | false; echo $?; false | true; echo $?; set -o pipefail; false | true; echo $?`,
+		out:    "1\n0\n1\n",
+	}, {
+		name:   "and or",
+		script: `false && echo no || echo yes; true && echo yes2`,
+		out:    "yes\nyes2\n",
+	}, {
+		name:   "bang",
+		script: `! false; echo $?`,
+		out:    "0\n",
+	}, {
+		name:   "if",
+		script: `if [ 1 -gt 2 ]; then echo a; elif test x = x; then echo b; else echo c; fi`,
+		out:    "b\n",
+	}, {
+		name:   "while",
+		script: `i=0; while [ $i -lt 3 ]; do i=$((i + 1)); echo $i; done`,
+		out:    "1\n2\n3\n",
+	}, {
+		name:   "until break continue",
+		script: `i=0; until false; do i=$((i+1)); [ $i = 2 ] && continue; [ $i = 4 ] && break; echo $i; done`,
+		out:    "1\n3\n",
+	}, {
+		name:   "nested break",
+		script: `for i in 1 2; do for j in a b; do echo $i$j; break 2; done; done`,
+		out:    "1a\n",
+	}, {
+		name: "case",
+		script: `for f in a.go b.txt c; do
+			case $f in
+			*.go) echo go;;
+			*.txt|*.md) echo text;;
+			*) echo other;;
+			esac
+		done`,
+		out: "go\ntext\nother\n",
+	}, {
+		name:   "function",
+		script: `f() { echo "$#: $1"; return 3; }; f x y; echo $?; echo $#`,
+		out:    "2: x\n3\n0\n",
+	}, {
+		name:   "subshell",
+		script: `x=1; (x=2; echo $x; exit 5); echo $? $x`,
+		out:    "2\n5 1\n",
+	}, {
+		name:   "brace group",
+		script: `{ echo a; echo b; } | awk '{ print NR ": " $0 }'`,
+		out:    "1: a\n2: b\n",
+	}, {
+		name:   "heredoc",
+		script: "x=world\ncat <<EOF\nhello $x\nEOF\ncat <<'EOF'\nhello $x\nEOF\n",
+		out:    "hello world\nhello $x\n",
+	}, {
+		name:   "heredoc tabs",
+		script: "cat <<-EOF\n\tindented\n\tEOF\n",
+		out:    "indented\n",
+	}, {
+		name:   "stdin",
+		stdin:  "one two\nthree four\n",
+		script: `while read a b; do echo "$b $a"; done`,
+		out:    "two one\nfour three\n",
+	}, {
+		name:   "redirection",
+		script: `echo a > out; echo b >> out; cat < out; echo err 2>&1 >&2`,
+		out:    "a\nb\nerr\n",
+		check:  map[string]string{"out": "a\nb\n"},
+	}, {
+		name:   "glob",
+		script: `echo *.txt dir/? "*.txt" nomatch*`,
+		files:  map[string]string{"a.txt": "", "b.txt": "", "c.go": "", "dir/x": ""},
+		out:    "a.txt b.txt dir/x *.txt nomatch*\n",
+	}, {
+		name:   "test files",
+		script: `[ -f a ] && echo file; [ -d dir ] && echo dir; [ -e nope ] || echo none`,
+		files:  map[string]string{"a": "x", "dir/b": "y"},
+		out:    "file\ndir\nnone\n",
+	}, {
+		name:   "dot",
+		script: `. ./lib; greet`,
+		files:  map[string]string{"lib": "greet() { echo hi; }"},
+		out:    "hi\n",
+	}, {
+		name:   "eval",
+		script: `cmd='echo $((1 + 1))'; eval "$cmd"`,
+		out:    "2\n",
+	}, {
+		name:   "nested sh",
+		script: `export X=1; Y=2 sh -c 'echo $X $Y'; echo ${Y-unset}`,
+		out:    "1 2\nunset\n",
+	}, {
+		name:   "exit",
+		script: `echo a; exit 3; echo b`,
+		out:    "a\n",
+		code:   3,
+	}, {
+		name:   "errexit",
+		script: `set -e; false || true; if false; then :; fi; echo ok; false; echo no`,
+		out:    "ok\n",
+		code:   1,
+	}, {
+		name:   "not found",
+		script: `nosuchcommand-buzzybox`,
+		code:   127,
+	}, {
+		name:   "cd",
+		script: `cd dir; cat a; echo b > b; echo *; cd ..; cat dir/b; cd nope || echo fail`,
+		files:  map[string]string{"dir/a": "a\n"},
+		out:    "a\na b\nb\nfail\n",
+	}, {
+		name:   "cd pwd",
+		script: `cd /dir; pwd; (cd /; pwd; awk 'BEGIN { system("pwd") }'); pwd; sh -c 'cat a'`,
+		files:  map[string]string{"dir/a": "a\n"},
+		out:    "/dir\n/\n/\n/dir\na\n",
+	}, {
+		name:   "trap",
+		script: `trap 'echo bye' EXIT; (trap 'echo sub $?' 0; exit 4); echo $?; trap 'echo int' INT; trap`,
+		out:    "sub 4\n4\ntrap -- 'echo bye' EXIT\ntrap -- 'echo int' INT\nbye\n",
+	}, {
+		name:   "trap exit status",
+		script: `trap 'echo $?; trap - INT' EXIT; exit 3`,
+		out:    "3\n",
+		code:   3,
+	}, {
+		name:   "background",
+		script: `sh -c 'exit 3' & wait $!; echo $?; [ "$!" != "$$" ] && echo job; wait $!; echo $?`,
+		out:    "3\njob\n127\n",
+	}, {
+		name: "prefix assignments",
+		script: "f() { echo $X; sh -c 'echo $X'; }; X=1 f; echo \"[$X]\"\n" +
+			"IFS=: read a b <<EOF\nx:y\nEOF\necho $a $b \"[$IFS]\"\n",
+		out: "1\n1\n[]\nx y []\n",
+	}, {
+		name:   "path",
+		script: `PATH=/nonexistent env; echo $?; PATH=/nonexistent; env; echo $?`,
+		out:    "127\n127\n",
+	}, {
+		name:   "syntax error",
+		script: `if true; then echo`,
+		code:   2,
+	}, {
+		name:   "simple command",
+		script: `x=1 y=$x; echo $x$y; x=2 sh -c 'echo $x'; echo $x`,
+		out:    "11\n2\n1\n",
+	}, {
+		name:   "read write redirection",
+		script: `echo abc > f; echo Z 1<>f; cat f; read l <>f; echo $l; echo new 1<>g; cat g`,
+		out:    "Z\nc\nZ\nnew\n",
+		check:  map[string]string{"f": "Z\nc\n", "g": "new\n"},
+	}, {
+		name:   "redirection forms",
+		script: `echo a >| f; cat 0<f; echo err 2>e 1>&2; cat e; echo gone >/dev/null; cat </dev/null; cat <&0`,
+		stdin:  "in\n",
+		out:    "a\nerr\nin\n",
+	}, {
+		name:   "compound redirection",
+		script: `{ echo a; echo b; } > out; while read l; do echo "<$l>"; done < out; if true; then echo c; fi >> out; cat out`,
+		out:    "<a>\n<b>\na\nb\nc\n",
+	}, {
+		name:   "tilde",
+		script: `HOME=/home/u; x=~/a:~/b; echo ~ ~/c "~" \~ a~ ~x $x; case ~ in /home/u) echo case;; esac`,
+		out:    "/home/u /home/u/c ~ ~ a~ ~x /home/u/a:/home/u/b\ncase\n",
+	}, {
+		name:   "heredoc strip tabs",
+		script: "cat <<-EOF\n\tone\n\t\ttwo\n  three\n\tEOF\necho after\n",
+		out:    "one\ntwo\n  three\nafter\n",
+	}, {
+		name:   "heredoc quoted",
+		script: "x=1\ncat <<\\EOF\n$x `y`\nEOF\ncat <<E\"O\"F\n$x\nEOF\n",
+		out:    "$x `y`\n$x\n",
+	}, {
+		name:   "heredoc multiple",
+		script: "cat <<A; cat <<B\na\nA\nb\nB\n",
+		out:    "a\nb\n",
+	}, {
+		name:   "heredoc pipeline",
+		script: "cat <<EOF | awk '{ print toupper($0) }'\nhi $((1 + 1))\nEOF\n",
+		out:    "HI 2\n",
+	}, {
+		name:   "case patterns",
+		script: `for w in abc A1 '*' '' x-y '?'; do case $w in [a-c]*) echo lower;; [[:upper:]][0-9]) echo upper;; '*') echo star;; "") echo empty;; x\-y) echo dash;; [!a-z]) echo class;; esac; done`,
+		out:    "lower\nupper\nstar\nempty\ndash\nclass\n",
+	}, {
+		name:   "case edges",
+		script: `case a in (a) echo paren;; esac; case b in a) ;; esac; echo $?; p='b*'; case bc in $p) echo var;; esac; case 'b*' in "$p") echo quoted;; esac; case x in x) echo last; esac`,
+		out:    "paren\n0\nvar\nquoted\nlast\n",
+	}, {
+		name:   "errexit and or",
+		script: `set -e; false && true; echo a; true && false || echo b; ! true; echo c; f() { false; echo in; }; f || echo d; while false; do :; done; echo e; false; echo no`,
+		out:    "a\nb\nc\nin\ne\n",
+		code:   1,
+	}, {
+		name:   "errexit if",
+		script: `set -e; if false; then :; elif false; then :; fi; until true; do :; done; echo ok; false | true; echo pipe; set +e; false; echo off`,
+		out:    "ok\npipe\noff\n",
+	}, {
+		name:   "errexit subshell",
+		script: `set -e; (false; echo no); echo no2`,
+		code:   1,
+	}, {
+		name:   "for without in",
+		script: `for x; do echo $x; done`,
+		args:   []string{"a", "b c"},
+		out:    "a\nb c\n",
+	}, {
+		name:   "function body redirection",
+		script: `f() { echo in; } > out; f; cat out`,
+		out:    "in\n",
+	}, {
+		name:   "nested quotes",
+		script: `echo "$(echo "a  b")" '"' "'" "${x:-"d  e"}"`,
+		out:    "a  b \" ' d  e\n",
+	}, {
+		name:   "continuation and comments",
+		script: "echo a \\\nb # comment\necho c#d '#'\n",
+		out:    "a b\nc#d #\n",
+	}, {
+		name:   "compound newlines",
+		script: "if true\nthen\n\techo a\nfi\nfor i in 1 2\ndo\n\techo $i\ndone\nf()\n{\n\techo f\n}\nf\n",
+		out:    "a\n1\n2\nf\n",
+	}, {
+		name:   "parse error quote",
+		script: `echo 'unterminated`,
+		code:   2,
+	}, {
+		name:   "parse error keyword",
+		script: `echo a; done`,
+		code:   2,
+	}, {
+		name:   "parse error case",
+		script: `case x in x) echo`,
+		code:   2,
+	}, {
+		name:   "parse error operator",
+		script: `echo a &&`,
+		code:   2,
+	}, {
+		name:   "parse error paren",
+		script: `echo )`,
+		code:   2,
+	}, {
+		name:   "unsupported fd",
+		script: `echo a 3>f; echo $?; echo b >&3; echo $?`,
+		out:    "1\n1\n",
+		stderr: "unsupported file descriptor: 3",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := hive.Command(append([]string{"sh", "-c", tt.script, "sh"},
+				tt.args...)...)
+			fsys := memfs(t, tt.files)
+			cmd.FS = fsys
+			cmd.Stdin = strings.NewReader(tt.stdin)
+			cmd.Stdout = new(strings.Builder)
+			cmd.Stderr = new(strings.Builder)
+			if code := cmd.Run(); code != tt.code {
+				t.Errorf("exit status %d, want %d\nstderr\n---\n%s", code, tt.code,
+					cmd.Stderr.(*strings.Builder).String())
+			}
+			if got := cmd.Stdout.(*strings.Builder).String(); got != tt.out {
+				t.Errorf("got %q, want %q", got, tt.out)
+			}
+			if got := cmd.Stderr.(*strings.Builder).String(); !strings.Contains(got, tt.stderr) {
+				t.Errorf("stderr: got %q, want %q", got, tt.stderr)
+			}
+			for name, want := range tt.check {
+				if got := memread(t, fsys, name); got != want {
+					t.Errorf("%s: got %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestShFile(t *testing.T) {
+	cmd := hive.Command("sh", "script", "a", "b")
+	cmd.FS = memfs(t, map[string]string{"script": "echo $0 $2 $1\n"})
+	cmd.Stdout = new(strings.Builder)
+	if code := cmd.Run(); code != 0 {
+		t.Fatalf("exit status %d, want 0", code)
+	}
+	if got, want := cmd.Stdout.(*strings.Builder).String(), "script b a\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
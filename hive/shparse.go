@@ -0,0 +1,1050 @@
+package hive
+
+import (
+	"fmt"
+	"strings"
+)
+
+type (
+	shparser struct {
+		src      []rune
+		pos      int
+		heredocs []*shredir
+	}
+	shsyntaxError struct {
+		reason string
+		line   int
+	}
+	shlist struct {
+		items []*shandor
+		async []bool
+	}
+	shandor struct {
+		pipes []*shpipe
+		ops   []string
+	}
+	shpipe struct {
+		bang bool
+		cmds []shnode
+	}
+	shnode   interface{}
+	shsimple struct {
+		assigns []*shassign
+		words   []*shword
+		redirs  []*shredir
+	}
+	shcompound struct {
+		body   shnode
+		redirs []*shredir
+	}
+	shbrace struct {
+		body *shlist
+	}
+	shsubshell struct {
+		body *shlist
+	}
+	shif struct {
+		conds  []*shlist
+		bodies []*shlist
+		els    *shlist
+	}
+	shloop struct {
+		until bool
+		cond  *shlist
+		body  *shlist
+	}
+	shfor struct {
+		name  string
+		words []*shword
+		in    bool
+		body  *shlist
+	}
+	shcase struct {
+		word  *shword
+		items []*shcaseitem
+	}
+	shcaseitem struct {
+		patterns []*shword
+		body     *shlist
+	}
+	shfuncdef struct {
+		name string
+		body *shcompound
+	}
+	shassign struct {
+		name  string
+		value *shword
+	}
+	shredir struct {
+		fd     int
+		op     string
+		target *shword
+		delim  string
+		quoted bool
+		body   *shword
+	}
+	shword struct {
+		parts []*shpart
+	}
+	shpart struct {
+		kind int
+		text string
+		op   string
+		word *shword
+		list *shlist
+	}
+)
+
+const (
+	shlit = iota
+	shsq
+	shdq
+	shparam
+	shcmdsub
+	sharith
+)
+
+var shops = []string{
+	"&&", "||", ";;", "<<-", "<<", ">>", "<&", ">&", "<>", ">|",
+	"&", "|", ";", "<", ">", "(", ")", "\n",
+}
+
+var shreserved = stringset(
+	"!", "{", "}", "case", "do", "done", "elif", "else", "esac", "fi", "for",
+	"if", "in", "then", "until", "while",
+)
+
+var shstop = stringset("}", "do", "done", "elif", "else", "esac", "fi", "then")
+
+func shparse(src string) (*shlist, error) {
+	p := &shparser{src: []rune(src)}
+	l, err := p.list()
+	if err != nil {
+		return nil, err
+	}
+	if p.blank(); p.pos < len(p.src) {
+		return nil, p.unexpected()
+	}
+	if len(p.heredocs) > 0 {
+		return nil, p.errorf("bad here-document: missing %s", p.heredocs[0].delim)
+	}
+	return l, nil
+}
+
+func (e *shsyntaxError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.line, e.reason)
+}
+
+func (p *shparser) errorf(format string, a ...any) error {
+	line := 1
+	for i := 0; i < p.pos && i < len(p.src); i++ {
+		if p.src[i] == '\n' {
+			line++
+		}
+	}
+	return &shsyntaxError{reason: fmt.Sprintf(format, a...), line: line}
+}
+
+func (p *shparser) unexpected() error {
+	if p.pos >= len(p.src) {
+		return p.errorf("bad EOF")
+	} else if op := p.op(); op == "\n" {
+		return p.errorf("bad newline")
+	} else if op != "" {
+		return p.errorf("bad token: %s", op)
+	} else if w := p.peekword(); w != "" {
+		return p.errorf("bad token: %s", w)
+	}
+	return p.errorf("bad token: %c", p.src[p.pos])
+}
+
+func (p *shparser) peek(n int) rune {
+	if p.pos+n < 0 || p.pos+n >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos+n]
+}
+
+func shmeta(r rune) bool {
+	return strings.ContainsRune(" \t\n;&|<>()", r)
+}
+
+func (p *shparser) blank() {
+	for p.pos < len(p.src) {
+		switch {
+		case p.peek(0) == ' ' || p.peek(0) == '\t':
+			p.pos++
+		case p.peek(0) == '\\' && p.peek(1) == '\n':
+			p.pos += 2
+		case p.peek(0) == '#':
+			for p.pos < len(p.src) && p.peek(0) != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *shparser) op() string {
+	p.blank()
+	for _, op := range shops {
+		if p.pos+len(op) <= len(p.src) && string(p.src[p.pos:p.pos+len(op)]) == op {
+			return op
+		}
+	}
+	return ""
+}
+
+func (p *shparser) eat(op string) (bool, error) {
+	if p.op() != op {
+		return false, nil
+	}
+	p.pos += len(op)
+	if op == "\n" {
+		return true, p.readheredocs()
+	}
+	return true, nil
+}
+
+func (p *shparser) linebreak() error {
+	for {
+		if ok, err := p.eat("\n"); err != nil {
+			return err
+		} else if !ok {
+			return nil
+		}
+	}
+}
+
+func (p *shparser) peekword() string {
+	p.blank()
+	i := p.pos
+	for i < len(p.src) && !shmeta(p.src[i]) {
+		if strings.ContainsRune("'\"\\$`", p.src[i]) {
+			return ""
+		}
+		i++
+	}
+	return string(p.src[p.pos:i])
+}
+
+func (p *shparser) reserved() string {
+	if w := p.peekword(); shreserved[w] {
+		return w
+	}
+	return ""
+}
+
+func (p *shparser) eatword(w string) bool {
+	if p.reserved() != w {
+		return false
+	}
+	p.pos += len([]rune(w))
+	return true
+}
+
+func (p *shparser) mustword(w string) error {
+	if !p.eatword(w) {
+		return p.errorf("want %s, got %s", w, p.describe())
+	}
+	return nil
+}
+
+func (p *shparser) describe() string {
+	if p.blank(); p.pos >= len(p.src) {
+		return "EOF"
+	} else if op := p.op(); op == "\n" {
+		return "newline"
+	} else if op != "" {
+		return op
+	} else if w := p.peekword(); w != "" {
+		return w
+	}
+	return "word"
+}
+
+func (p *shparser) atstop() bool {
+	if p.blank(); p.pos >= len(p.src) {
+		return true
+	} else if op := p.op(); op == ")" || op == ";;" {
+		return true
+	}
+	return shstop[p.reserved()]
+}
+
+func (p *shparser) list() (*shlist, error) {
+	l := &shlist{}
+	for {
+		if err := p.linebreak(); err != nil {
+			return nil, err
+		}
+		if p.atstop() {
+			return l, nil
+		}
+		ao, err := p.andor()
+		if err != nil {
+			return nil, err
+		}
+		l.items = append(l.items, ao)
+		l.async = append(l.async, false)
+		switch p.op() {
+		case "&":
+			l.async[len(l.async)-1] = true
+			p.pos++
+		case ";":
+			p.pos++
+		case "\n":
+		default:
+			if !p.atstop() {
+				return nil, p.unexpected()
+			}
+		}
+	}
+}
+
+func (p *shparser) andor() (*shandor, error) {
+	ao := &shandor{}
+	for {
+		pipe, err := p.pipeline()
+		if err != nil {
+			return nil, err
+		}
+		ao.pipes = append(ao.pipes, pipe)
+		op := p.op()
+		if op != "&&" && op != "||" {
+			return ao, nil
+		}
+		p.pos += 2
+		ao.ops = append(ao.ops, op)
+		if err := p.linebreak(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *shparser) pipeline() (*shpipe, error) {
+	pipe := &shpipe{}
+	if p.eatword("!") {
+		pipe.bang = true
+	}
+	for {
+		cmd, err := p.command()
+		if err != nil {
+			return nil, err
+		}
+		pipe.cmds = append(pipe.cmds, cmd)
+		if p.op() != "|" {
+			return pipe, nil
+		}
+		p.pos++
+		if err := p.linebreak(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *shparser) command() (shnode, error) {
+	var body shnode
+	var err error
+	switch p.reserved() {
+	case "{":
+		body, err = p.brace()
+	case "if":
+		body, err = p.ifclause()
+	case "while", "until":
+		body, err = p.loop()
+	case "for":
+		body, err = p.forclause()
+	case "case":
+		body, err = p.caseclause()
+	case "":
+		if p.op() == "(" {
+			body, err = p.subshell()
+		} else if name, ok := p.funcname(); ok {
+			return p.funcdef(name)
+		} else {
+			return p.simple()
+		}
+	default:
+		return nil, p.unexpected()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p.compound(body)
+}
+
+func (p *shparser) compound(body shnode) (*shcompound, error) {
+	c := &shcompound{body: body}
+	for {
+		r, ok, err := p.redir()
+		if err != nil {
+			return nil, err
+		} else if !ok {
+			return c, nil
+		}
+		c.redirs = append(c.redirs, r)
+	}
+}
+
+func (p *shparser) brace() (*shbrace, error) {
+	p.eatword("{")
+	body, err := p.list()
+	if err != nil {
+		return nil, err
+	}
+	return &shbrace{body}, p.mustword("}")
+}
+
+func (p *shparser) subshell() (*shsubshell, error) {
+	p.pos++
+	body, err := p.list()
+	if err != nil {
+		return nil, err
+	}
+	if p.op() != ")" {
+		return nil, p.errorf("want ), got %s", p.describe())
+	}
+	p.pos++
+	return &shsubshell{body}, nil
+}
+
+func (p *shparser) ifclause() (*shif, error) {
+	n := &shif{}
+	p.eatword("if")
+	for {
+		cond, err := p.list()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.mustword("then"); err != nil {
+			return nil, err
+		}
+		body, err := p.list()
+		if err != nil {
+			return nil, err
+		}
+		n.conds = append(n.conds, cond)
+		n.bodies = append(n.bodies, body)
+		if !p.eatword("elif") {
+			break
+		}
+	}
+	if p.eatword("else") {
+		els, err := p.list()
+		if err != nil {
+			return nil, err
+		}
+		n.els = els
+	}
+	return n, p.mustword("fi")
+}
+
+func (p *shparser) loop() (*shloop, error) {
+	n := &shloop{until: p.reserved() == "until"}
+	p.eatword(p.reserved())
+	var err error
+	if n.cond, err = p.list(); err != nil {
+		return nil, err
+	}
+	if n.body, err = p.dogroup(); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func (p *shparser) dogroup() (*shlist, error) {
+	if err := p.mustword("do"); err != nil {
+		return nil, err
+	}
+	body, err := p.list()
+	if err != nil {
+		return nil, err
+	}
+	return body, p.mustword("done")
+}
+
+func (p *shparser) forclause() (*shfor, error) {
+	n := &shfor{}
+	p.eatword("for")
+	name := p.peekword()
+	if !shname(name) {
+		return nil, p.errorf("bad for loop variable: %s", p.describe())
+	}
+	p.pos += len([]rune(name))
+	n.name = name
+	if err := p.linebreak(); err != nil {
+		return nil, err
+	}
+	if p.eatword("in") {
+		n.in = true
+		for {
+			if op := p.op(); op == ";" || op == "\n" {
+				p.pos++
+				if op == "\n" {
+					if err := p.readheredocs(); err != nil {
+						return nil, err
+					}
+				}
+				break
+			} else if op != "" || p.pos >= len(p.src) {
+				return nil, p.unexpected()
+			}
+			w, err := p.word(shmeta)
+			if err != nil {
+				return nil, err
+			}
+			n.words = append(n.words, w)
+		}
+	} else if p.op() == ";" {
+		p.pos++
+	}
+	if err := p.linebreak(); err != nil {
+		return nil, err
+	}
+	var err error
+	n.body, err = p.dogroup()
+	return n, err
+}
+
+func (p *shparser) caseclause() (*shcase, error) {
+	n := &shcase{}
+	p.eatword("case")
+	p.blank()
+	var err error
+	if n.word, err = p.word(shmeta); err != nil {
+		return nil, err
+	}
+	if err := p.linebreak(); err != nil {
+		return nil, err
+	}
+	if err := p.mustword("in"); err != nil {
+		return nil, err
+	}
+	for {
+		if err := p.linebreak(); err != nil {
+			return nil, err
+		}
+		if p.eatword("esac") {
+			return n, nil
+		}
+		item := &shcaseitem{}
+		if p.op() == "(" {
+			p.pos++
+		}
+		for {
+			p.blank()
+			w, err := p.word(func(r rune) bool { return shmeta(r) })
+			if err != nil {
+				return nil, err
+			} else if len(w.parts) == 0 {
+				return nil, p.unexpected()
+			}
+			item.patterns = append(item.patterns, w)
+			if op := p.op(); op == "|" {
+				p.pos++
+				continue
+			} else if op == ")" {
+				p.pos++
+				break
+			}
+			return nil, p.unexpected()
+		}
+		if item.body, err = p.list(); err != nil {
+			return nil, err
+		}
+		n.items = append(n.items, item)
+		if p.op() == ";;" {
+			p.pos += 2
+		} else if p.reserved() != "esac" {
+			return nil, p.errorf("want esac, got %s", p.describe())
+		}
+	}
+}
+
+func (p *shparser) funcname() (string, bool) {
+	name := p.peekword()
+	if !shname(name) || shreserved[name] {
+		return "", false
+	}
+	i := p.pos + len([]rune(name))
+	for i < len(p.src) && (p.src[i] == ' ' || p.src[i] == '\t') {
+		i++
+	}
+	if i >= len(p.src) || p.src[i] != '(' {
+		return "", false
+	}
+	return name, true
+}
+
+func (p *shparser) funcdef(name string) (*shfuncdef, error) {
+	p.pos += len([]rune(name))
+	p.blank()
+	p.pos++ // (
+	if p.op() != ")" {
+		return nil, p.errorf("want ), got %s", p.describe())
+	}
+	p.pos++
+	if err := p.linebreak(); err != nil {
+		return nil, err
+	}
+	body, err := p.command()
+	if err != nil {
+		return nil, err
+	}
+	c, ok := body.(*shcompound)
+	if !ok {
+		return nil, p.errorf("bad function body: %s", name)
+	}
+	return &shfuncdef{name: name, body: c}, nil
+}
+
+func (p *shparser) simple() (*shsimple, error) {
+	n := &shsimple{}
+	for {
+		r, ok, err := p.redir()
+		if err != nil {
+			return nil, err
+		} else if ok {
+			n.redirs = append(n.redirs, r)
+			continue
+		}
+		if p.blank(); p.pos >= len(p.src) || p.op() != "" {
+			break
+		}
+		w, err := p.word(shmeta)
+		if err != nil {
+			return nil, err
+		}
+		if a := w.assignment(); a != nil && len(n.words) == 0 {
+			n.assigns = append(n.assigns, a)
+		} else {
+			n.words = append(n.words, w)
+		}
+	}
+	if len(n.words) == 0 && len(n.assigns) == 0 && len(n.redirs) == 0 {
+		return nil, p.unexpected()
+	}
+	return n, nil
+}
+
+func (p *shparser) redir() (*shredir, bool, error) {
+	p.blank()
+	start := p.pos
+	fd := -1
+	if i := p.pos; i < len(p.src) && '0' <= p.src[i] && p.src[i] <= '9' {
+		for i < len(p.src) && '0' <= p.src[i] && p.src[i] <= '9' {
+			i++
+		}
+		if i < len(p.src) && (p.src[i] == '<' || p.src[i] == '>') {
+			fmt.Sscan(string(p.src[p.pos:i]), &fd)
+			p.pos = i
+		}
+	}
+	op := p.op()
+	switch op {
+	case "<", ">", ">>", "<&", ">&", "<>", ">|", "<<", "<<-":
+	default:
+		p.pos = start
+		return nil, false, nil
+	}
+	p.pos += len(op)
+	if fd < 0 && strings.HasPrefix(op, "<") {
+		fd = 0
+	} else if fd < 0 {
+		fd = 1
+	}
+	r := &shredir{fd: fd, op: op}
+	p.blank()
+	if p.pos >= len(p.src) || shmeta(p.peek(0)) {
+		return nil, false, p.errorf("bad redirection: missing target")
+	}
+	if op == "<<" || op == "<<-" {
+		delim, quoted := p.delimiter()
+		r.delim = delim
+		r.quoted = quoted
+		p.heredocs = append(p.heredocs, r)
+		return r, true, nil
+	}
+	w, err := p.word(shmeta)
+	if err != nil {
+		return nil, false, err
+	}
+	r.target = w
+	return r, true, nil
+}
+
+func (p *shparser) delimiter() (string, bool) {
+	var delim strings.Builder
+	var quoted bool
+	for p.pos < len(p.src) && !shmeta(p.peek(0)) {
+		switch c := p.peek(0); c {
+		case '\'', '"':
+			quoted = true
+			p.pos++
+			for p.pos < len(p.src) && p.peek(0) != c {
+				delim.WriteRune(p.peek(0))
+				p.pos++
+			}
+			p.pos++
+		case '\\':
+			quoted = true
+			p.pos++
+			if p.pos < len(p.src) {
+				delim.WriteRune(p.peek(0))
+				p.pos++
+			}
+		default:
+			delim.WriteRune(c)
+			p.pos++
+		}
+	}
+	return delim.String(), quoted
+}
+
+func (p *shparser) readheredocs() error {
+	docs := p.heredocs
+	p.heredocs = nil
+	for _, r := range docs {
+		var body strings.Builder
+		for {
+			if p.pos >= len(p.src) {
+				return p.errorf("bad here-document: missing %s", r.delim)
+			}
+			end := p.pos
+			for end < len(p.src) && p.src[end] != '\n' {
+				end++
+			}
+			line := string(p.src[p.pos:end])
+			p.pos = min(end+1, len(p.src))
+			if r.op == "<<-" {
+				line = strings.TrimLeft(line, "\t")
+			}
+			if line == r.delim {
+				break
+			}
+			body.WriteString(line)
+			body.WriteByte('\n')
+		}
+		if r.quoted {
+			r.body = &shword{parts: []*shpart{{kind: shsq, text: body.String()}}}
+			continue
+		}
+		sub := &shparser{src: []rune(body.String())}
+		w, err := sub.dqword(0)
+		if err != nil {
+			return err
+		}
+		r.body = w
+	}
+	return nil
+}
+
+func (p *shparser) word(stop func(rune) bool) (*shword, error) {
+	w := &shword{}
+	var lit strings.Builder
+	flush := func() {
+		if lit.Len() > 0 {
+			w.parts = append(w.parts, &shpart{kind: shlit, text: lit.String()})
+			lit.Reset()
+		}
+	}
+	for p.pos < len(p.src) && !stop(p.peek(0)) {
+		switch c := p.peek(0); c {
+		case '\\':
+			if p.peek(1) == '\n' {
+				p.pos += 2
+				continue
+			}
+			flush()
+			p.pos++
+			if p.pos < len(p.src) {
+				w.parts = append(w.parts, &shpart{kind: shsq, text: string(p.peek(0))})
+				p.pos++
+			}
+		case '\'':
+			flush()
+			end := p.pos + 1
+			for end < len(p.src) && p.src[end] != '\'' {
+				end++
+			}
+			if end >= len(p.src) {
+				return nil, p.errorf("bad string: missing '")
+			}
+			w.parts = append(w.parts, &shpart{kind: shsq, text: string(p.src[p.pos+1 : end])})
+			p.pos = end + 1
+		case '"':
+			flush()
+			p.pos++
+			inner, err := p.dqword('"')
+			if err != nil {
+				return nil, err
+			}
+			w.parts = append(w.parts, &shpart{kind: shdq, word: inner})
+		case '$', '`':
+			part, err := p.expansion()
+			if err != nil {
+				return nil, err
+			} else if part == nil {
+				lit.WriteRune(c)
+				p.pos++
+				continue
+			}
+			flush()
+			w.parts = append(w.parts, part)
+		default:
+			lit.WriteRune(c)
+			p.pos++
+		}
+	}
+	flush()
+	return w, nil
+}
+
+// dqword parses the contents of a double-quoted string up to end, or the rest
+// of the input if end is 0.
+func (p *shparser) dqword(end rune) (*shword, error) {
+	w := &shword{}
+	var lit strings.Builder
+	flush := func() {
+		if lit.Len() > 0 {
+			w.parts = append(w.parts, &shpart{kind: shlit, text: lit.String()})
+			lit.Reset()
+		}
+	}
+	for {
+		if p.pos >= len(p.src) {
+			if end != 0 {
+				return nil, p.errorf("bad string: missing %c", end)
+			}
+			break
+		}
+		c := p.peek(0)
+		if c == end {
+			p.pos++
+			break
+		}
+		switch c {
+		case '\\':
+			n := p.peek(1)
+			if n == '\n' {
+				p.pos += 2
+			} else if n == '$' || n == '`' || n == '\\' || (n == '"' && end == '"') {
+				lit.WriteRune(n)
+				p.pos += 2
+			} else {
+				lit.WriteRune(c)
+				p.pos++
+			}
+		case '$', '`':
+			part, err := p.expansion()
+			if err != nil {
+				return nil, err
+			} else if part == nil {
+				lit.WriteRune(c)
+				p.pos++
+				continue
+			}
+			flush()
+			w.parts = append(w.parts, part)
+		default:
+			lit.WriteRune(c)
+			p.pos++
+		}
+	}
+	flush()
+	return w, nil
+}
+
+func (p *shparser) expansion() (*shpart, error) {
+	if p.peek(0) == '`' {
+		return p.backquote()
+	}
+	switch n := p.peek(1); {
+	case n == '(' && p.peek(2) == '(':
+		return p.arith()
+	case n == '(':
+		p.pos += 2
+		l, err := p.list()
+		if err != nil {
+			return nil, err
+		}
+		if p.op() != ")" {
+			return nil, p.errorf("bad command substitution: missing )")
+		}
+		p.pos++
+		return &shpart{kind: shcmdsub, list: l}, nil
+	case n == '{':
+		return p.braceparam()
+	case strings.ContainsRune("@*#?-$!", n) || '0' <= n && n <= '9':
+		p.pos += 2
+		return &shpart{kind: shparam, text: string(n)}, nil
+	case n == '_' || runealpha(n):
+		p.pos++
+		start := p.pos
+		for p.pos < len(p.src) && shnamerune(p.peek(0)) {
+			p.pos++
+		}
+		return &shpart{kind: shparam, text: string(p.src[start:p.pos])}, nil
+	}
+	return nil, nil
+}
+
+func (p *shparser) backquote() (*shpart, error) {
+	var inner strings.Builder
+	p.pos++
+	for {
+		if p.pos >= len(p.src) {
+			return nil, p.errorf("bad command substitution: missing `")
+		}
+		c := p.peek(0)
+		if c == '`' {
+			p.pos++
+			break
+		} else if c == '\\' && strings.ContainsRune("$`\\", p.peek(1)) {
+			inner.WriteRune(p.peek(1))
+			p.pos += 2
+			continue
+		}
+		inner.WriteRune(c)
+		p.pos++
+	}
+	l, err := shparse(inner.String())
+	if err != nil {
+		return nil, err
+	}
+	return &shpart{kind: shcmdsub, list: l}, nil
+}
+
+func (p *shparser) arith() (*shpart, error) {
+	start := p.pos
+	p.pos += 3
+	depth := 0
+	for ; p.pos < len(p.src); p.pos++ {
+		switch p.peek(0) {
+		case '(':
+			depth++
+		case ')':
+			if depth > 0 {
+				depth--
+				continue
+			} else if p.peek(1) != ')' {
+				return nil, p.errorf("bad arithmetic expansion: missing ))")
+			}
+			sub := &shparser{src: p.src[start+3 : p.pos]}
+			w, err := sub.dqword(0)
+			if err != nil {
+				return nil, err
+			}
+			p.pos += 2
+			return &shpart{kind: sharith, word: w}, nil
+		}
+	}
+	return nil, p.errorf("bad arithmetic expansion: missing ))")
+}
+
+func (p *shparser) braceparam() (*shpart, error) {
+	p.pos += 2
+	part := &shpart{kind: shparam}
+	if p.peek(0) == '#' && p.peek(1) != '}' && (shnamerune(p.peek(1)) ||
+		strings.ContainsRune("@*#?-$!", p.peek(1))) {
+		part.op = "len"
+		p.pos++
+	}
+	start := p.pos
+	if c := p.peek(0); strings.ContainsRune("@*#?-$!", c) {
+		p.pos++
+	} else if '0' <= c && c <= '9' {
+		for '0' <= p.peek(0) && p.peek(0) <= '9' {
+			p.pos++
+		}
+	} else {
+		for p.pos < len(p.src) && shnamerune(p.peek(0)) {
+			p.pos++
+		}
+	}
+	part.text = string(p.src[start:p.pos])
+	if part.text == "" {
+		return nil, p.errorf("bad substitution")
+	}
+	if p.peek(0) == '}' {
+		p.pos++
+		return part, nil
+	} else if part.op == "len" {
+		return nil, p.errorf("bad substitution")
+	}
+	for _, op := range []string{":-", ":=", ":?", ":+", "-", "=", "?", "+", "%%", "%", "##", "#"} {
+		if p.pos+len(op) <= len(p.src) && string(p.src[p.pos:p.pos+len(op)]) == op {
+			part.op = op
+			p.pos += len(op)
+			break
+		}
+	}
+	if part.op == "" {
+		return nil, p.errorf("bad substitution")
+	}
+	depth := 0
+	w, err := p.word(func(r rune) bool {
+		if r == '{' {
+			depth++
+		} else if r == '}' && depth > 0 {
+			depth--
+		} else if r == '}' {
+			return true
+		}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	if p.peek(0) != '}' {
+		return nil, p.errorf("bad substitution: missing }")
+	}
+	p.pos++
+	part.word = w
+	return part, nil
+}
+
+func (w *shword) assignment() *shassign {
+	if len(w.parts) == 0 || w.parts[0].kind != shlit {
+		return nil
+	}
+	name, val, ok := strings.Cut(w.parts[0].text, "=")
+	if !ok || !shname(name) {
+		return nil
+	}
+	value := &shword{}
+	if val != "" {
+		value.parts = append(value.parts, &shpart{kind: shlit, text: val})
+	}
+	value.parts = append(value.parts, w.parts[1:]...)
+	return &shassign{name: name, value: value}
+}
+
+func (w *shword) literal() (string, bool) {
+	var s strings.Builder
+	for _, part := range w.parts {
+		if part.kind != shlit {
+			return "", false
+		}
+		s.WriteString(part.text)
+	}
+	return s.String(), true
+}
+
+func shname(s string) bool {
+	if s == "" || '0' <= s[0] && s[0] <= '9' {
+		return false
+	}
+	for _, r := range s {
+		if !shnamerune(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func shnamerune(r rune) bool {
+	return r == '_' || runealpha(r) || '0' <= r && r <= '9'
+}
@@ -0,0 +1,2 @@
+BEGIN { RS = "\r\n" }
+{ printf "%d [%s] %d\n", NR, $0, RT == "\r\n" }
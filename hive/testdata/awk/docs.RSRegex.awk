@@ -0,0 +1,5 @@
+BEGIN { RS = "\n-+\n" }
+{
+    gsub(/\n/, " ")
+    printf "%d: %s (%s)\n", NR, $0, RT == "" ? "EOF" : length(RT)
+}
@@ -0,0 +1,8 @@
+BEGIN {
+    print "before"
+    status = system("echo hello; exit 3")
+    print "status", status
+    print "piped" | "cat"
+    close("cat")
+    print system("true"), system("false")
+}
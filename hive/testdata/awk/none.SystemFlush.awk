@@ -0,0 +1,4 @@
+BEGIN {
+    print "data" > "f"
+    system("cat f")
+}
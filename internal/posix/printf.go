@@ -0,0 +1,338 @@
+package posix
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// An Arg is an argument to Sprintf.
+// Sprintf calls whichever method suits the conversion being formatted.
+type Arg interface {
+	Float() float64 // For numeric conversions and * widths.
+	String() string // For %s.
+	Char() string   // For %c.
+}
+
+// A BigArg is an Arg that may have an exact value, which Sprintf prefers
+// over Float for numeric conversions. Big returns an integer or a float,
+// or neither if the argument has no exact value.
+type BigArg interface {
+	Arg
+	Big() (*big.Int, *big.Float)
+}
+
+var errArgs = errors.New("bad argc: not enough arguments")
+
+// Sprintf formats args like printf(3). Each conversion, and each * width or
+// precision, takes the next argument. Length modifiers are accepted and
+// ignored. A % at the end of format is copied to the output unchanged.
+func Sprintf(format string, args []Arg) (string, error) {
+	var b strings.Builder
+	next := func() (Arg, error) {
+		if len(args) == 0 {
+			return nil, errArgs
+		}
+		a := args[0]
+		args = args[1:]
+		return a, nil
+	}
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			b.WriteByte(format[i])
+			continue
+		}
+		start := i
+		c := conv{prec: -1}
+	flags:
+		for i++; i < len(format); i++ {
+			switch format[i] {
+			case '-':
+				c.minus = true
+			case '+':
+				c.plus = true
+			case ' ':
+				c.space = true
+			case '#':
+				c.sharp = true
+			case '0':
+				c.zero = true
+			case '\'':
+			default:
+				break flags
+			}
+		}
+		if i < len(format) && format[i] == '*' {
+			a, err := next()
+			if err != nil {
+				return "", err
+			}
+			if c.width = int(a.Float()); c.width < 0 {
+				c.minus, c.width = true, -c.width
+			}
+			i++
+		} else {
+			c.width, i = atoi(format, i)
+		}
+		if i < len(format) && format[i] == '.' {
+			if i++; i < len(format) && format[i] == '*' {
+				a, err := next()
+				if err != nil {
+					return "", err
+				}
+				if c.prec = int(a.Float()); c.prec < 0 {
+					c.prec = -1 // A negative precision is taken as omitted.
+				}
+				i++
+			} else {
+				c.prec, i = atoi(format, i)
+			}
+		}
+		for i < len(format) && strings.IndexByte("hlLqjzt", format[i]) >= 0 {
+			i++
+		}
+		if i == len(format) {
+			b.WriteString(format[start:])
+			break
+		}
+		c.verb = format[i]
+		if c.verb == '%' {
+			b.WriteByte('%') // Like gawk, ignore any width.
+			continue
+		} else if strings.IndexByte("diouxXeEfFgGaAcs", c.verb) < 0 {
+			return "", fmt.Errorf("bad verb: %s", format[start:i+1])
+		}
+		a, err := next()
+		if err != nil {
+			return "", err
+		}
+		c.format(&b, a)
+	}
+	return b.String(), nil
+}
+
+func atoi(s string, i int) (n, end int) {
+	for ; i < len(s) && s[i] >= '0' && s[i] <= '9'; i++ {
+		n = n*10 + int(s[i]-'0')
+	}
+	return n, i
+}
+
+// A conv is a conversion specification.
+type conv struct {
+	minus, plus, space, sharp, zero bool
+
+	width int
+	prec  int // -1 if omitted.
+	verb  byte
+}
+
+func (c *conv) format(b *strings.Builder, a Arg) {
+	switch c.verb {
+	case 'd', 'i', 'o', 'u', 'x', 'X':
+		c.integer(b, a)
+	case 'c':
+		c.pad(b, "", a.Char(), false)
+	case 's':
+		s := a.String()
+		if c.prec >= 0 && utf8.RuneCountInString(s) > c.prec {
+			s = string([]rune(s)[:c.prec])
+		}
+		c.pad(b, "", s, false)
+	default:
+		c.float(b, a)
+	}
+}
+
+// pad writes prefix and s padded to the field width. If zero is true and the
+// 0 flag was given, the padding is zeros between prefix and s.
+func (c *conv) pad(b *strings.Builder, prefix, s string, zero bool) {
+	n := c.width - utf8.RuneCountInString(prefix) - utf8.RuneCountInString(s)
+	switch {
+	case n <= 0:
+		b.WriteString(prefix)
+		b.WriteString(s)
+	case c.minus:
+		b.WriteString(prefix)
+		b.WriteString(s)
+		b.WriteString(strings.Repeat(" ", n))
+	case zero && c.zero:
+		b.WriteString(prefix)
+		b.WriteString(strings.Repeat("0", n))
+		b.WriteString(s)
+	default:
+		b.WriteString(strings.Repeat(" ", n))
+		b.WriteString(prefix)
+		b.WriteString(s)
+	}
+}
+
+// sign returns the sign to print before a number.
+func (c *conv) sign(neg bool) string {
+	switch {
+	case neg:
+		return "-"
+	case c.plus:
+		return "+"
+	case c.space:
+		return " "
+	}
+	return ""
+}
+
+func (c *conv) integer(b *strings.Builder, a Arg) {
+	base := 10
+	switch c.verb {
+	case 'o':
+		base = 8
+	case 'x', 'X':
+		base = 16
+	}
+	var neg bool
+	var digits string
+	if i, f := bigval(a); i != nil || f != nil {
+		if i == nil && f.IsInf() {
+			c.special(b, f.Signbit(), "inf")
+			return
+		} else if i == nil {
+			i, _ = f.Int(nil)
+		}
+		neg, digits = i.Sign() < 0, new(big.Int).Abs(i).Text(base)
+	} else if n := math.Trunc(a.Float()); math.IsNaN(n) {
+		c.special(b, false, "nan")
+		return
+	} else if math.IsInf(n, 0) {
+		c.special(b, n < 0, "inf")
+		return
+	} else if n <= -(1<<63) || n >= 1<<63 {
+		i, _ := new(big.Float).SetFloat64(n).Int(nil)
+		neg, digits = i.Sign() < 0, new(big.Int).Abs(i).Text(base)
+	} else if c.verb == 'd' || c.verb == 'i' {
+		neg, digits = n < 0, strconv.FormatUint(uint64(math.Abs(n)), base)
+	} else {
+		digits = strconv.FormatUint(uint64(int64(n)), base) // Two's complement, as in C.
+	}
+	if c.verb != 'd' && c.verb != 'i' {
+		c.plus, c.space = false, false
+	}
+	if c.prec == 0 && digits == "0" {
+		digits = ""
+	}
+	if len(digits) < c.prec {
+		digits = strings.Repeat("0", c.prec-len(digits)) + digits
+	}
+	prefix := c.sign(neg)
+	switch {
+	case c.verb == 'o' && c.sharp && !strings.HasPrefix(digits, "0"):
+		digits = "0" + digits
+	case c.verb == 'x' && c.sharp && strings.Trim(digits, "0") != "":
+		prefix += "0x"
+	case c.verb == 'X' && c.sharp && strings.Trim(digits, "0") != "":
+		prefix += "0X"
+	}
+	if c.verb == 'X' {
+		digits = strings.ToUpper(digits)
+	}
+	c.pad(b, prefix, digits, c.prec < 0)
+}
+
+func (c *conv) float(b *strings.Builder, a Arg) {
+	verb := c.verb | 0x20 // Lower case.
+	prec := c.prec
+	if prec < 0 && verb != 'a' {
+		prec = 6
+	}
+	var neg bool
+	var s string
+	if i, f := bigval(a); (i != nil || f != nil) && verb != 'a' {
+		if f == nil {
+			f = new(big.Float).SetInt(i)
+		}
+		if f.IsInf() {
+			c.special(b, f.Signbit(), "inf")
+			return
+		}
+		neg, f = f.Signbit(), new(big.Float).Abs(f)
+		s = c.floattext(verb, prec, func(verb byte, prec int) string {
+			return f.Text(verb, prec)
+		})
+	} else {
+		n := a.Float()
+		if math.IsNaN(n) {
+			c.special(b, false, "nan")
+			return
+		} else if math.IsInf(n, 0) {
+			c.special(b, n < 0, "inf")
+			return
+		}
+		neg, n = math.Signbit(n), math.Abs(n)
+		if verb == 'a' {
+			verb = 'x'
+		}
+		s = c.floattext(verb, prec, func(verb byte, prec int) string {
+			return strconv.FormatFloat(n, verb, prec, 64)
+		})
+	}
+	if c.verb >= 'A' && c.verb <= 'Z' {
+		s = strings.ToUpper(s)
+	}
+	c.pad(b, c.sign(neg), s, true)
+}
+
+// floattext formats a non-negative number with text, adjusting its result
+// to match C for the # flag and the exponents of %a.
+func (c *conv) floattext(verb byte, prec int, text func(verb byte, prec int) string) string {
+	switch verb {
+	case 'g':
+		if prec == 0 {
+			prec = 1
+		}
+		if !c.sharp {
+			return text('g', prec)
+		}
+		// Keep trailing zeros by choosing between %e and %f as C does.
+		e := text('e', prec-1)
+		exp, _ := strconv.Atoi(e[strings.IndexByte(e, 'e')+1:])
+		if exp < -4 || exp >= prec {
+			verb, prec = 'e', prec-1
+		} else {
+			verb, prec = 'f', prec-1-exp
+		}
+	case 'x':
+		s := text('x', prec)
+		// C does not pad the binary exponent.
+		if p := strings.IndexByte(s, 'p'); p >= 0 && p+2 < len(s)-1 && s[p+2] == '0' {
+			s = s[:p+2] + s[p+3:]
+		}
+		return s
+	}
+	s := text(verb, prec)
+	if c.sharp && prec == 0 {
+		// The # flag always prints a decimal point.
+		if e := strings.IndexByte(s, 'e'); e >= 0 {
+			s = s[:e] + "." + s[e:]
+		} else {
+			s += "."
+		}
+	}
+	return s
+}
+
+// special writes infinity or NaN, which are never padded with zeros.
+func (c *conv) special(b *strings.Builder, neg bool, s string) {
+	if c.verb >= 'A' && c.verb <= 'Z' {
+		s = strings.ToUpper(s)
+	}
+	c.pad(b, c.sign(neg), s, false)
+}
+
+func bigval(a Arg) (*big.Int, *big.Float) {
+	if ba, ok := a.(BigArg); ok {
+		return ba.Big()
+	}
+	return nil, nil
+}
@@ -1,39 +1,40 @@
 package posix
 
-var rngidx int
-var rngvec [32]int
-var rngseed int
+// Rand is a random number generator compatible with random(3).
+// The zero value is ready to use and behaves as if seeded with 1.
+// A Rand is not safe for concurrent use.
+type Rand struct {
+	idx  int
+	vec  [32]int
+	seed int
+}
 
-func Srandom(seed int) {
-	rngseed = seed
-	if rngseed == 0 {
-		rngseed = 1
+func (r *Rand) Srandom(seed int) {
+	r.idx = 0
+	r.seed = seed
+	if r.seed == 0 {
+		r.seed = 1
 	}
-	rngvec[0] = rngseed
+	r.vec[0] = r.seed
 	for i := 1; i < 31; i++ {
-		rngvec[i] = (16807 * rngvec[i-1]) % 2147483647
-		if rngvec[i] < 0 {
-			rngvec[i] = rngvec[i] + 2147483647
+		r.vec[i] = (16807 * r.vec[i-1]) % 2147483647
+		if r.vec[i] < 0 {
+			r.vec[i] = r.vec[i] + 2147483647
 		}
 	}
 	for i := 31; i < 34; i++ {
-		rngvec[i%32] = rngvec[(i+1)%32]
+		r.vec[i%32] = r.vec[(i+1)%32]
 	}
 	for i := 34; i < 344; i++ {
-		rngvec[i%32] = rngvec[(i+1)%32] + rngvec[(i+29)%32]
+		r.vec[i%32] = r.vec[(i+1)%32] + r.vec[(i+29)%32]
 	}
 }
 
-func Random() uint32 {
-	if rngseed == 0 {
-		Srandom(1)
+func (r *Rand) Random() uint32 {
+	if r.seed == 0 {
+		r.Srandom(1)
 	}
-	rngvec[(rngidx+24)%32] = rngvec[(rngidx+25)%32] + rngvec[(rngidx+21)%32]
-	rngidx = (rngidx + 1) % 32
-	return uint32(rngvec[(rngidx+23)%32]) >> 1
-}
-
-func ResetRandom() {
-	rngidx = 0
-	Srandom(1)
+	r.vec[(r.idx+24)%32] = r.vec[(r.idx+25)%32] + r.vec[(r.idx+21)%32]
+	r.idx = (r.idx + 1) % 32
+	return uint32(r.vec[(r.idx+23)%32]) >> 1
 }
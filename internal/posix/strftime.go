@@ -0,0 +1,140 @@
+package posix
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Strftime formats t like strftime(3) in the C locale.
+// Unknown conversions are copied to the output unchanged.
+func Strftime(format string, t time.Time) string {
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			b.WriteByte(format[i])
+			continue
+		}
+		start := i
+		i++
+		if format[i] == 'E' || format[i] == 'O' {
+			if i+1 == len(format) {
+				b.WriteString(format[start:])
+				break
+			}
+			i++ // Alternative representations are the same in the C locale.
+		}
+		if !strftime(&b, format[i], t) {
+			b.WriteString(format[start : i+1])
+		}
+	}
+	return b.String()
+}
+
+func strftime(b *strings.Builder, c byte, t time.Time) bool {
+	pad := func(n, width int, fill byte) {
+		s := strconv.Itoa(n)
+		for i := len(s); i < width; i++ {
+			b.WriteByte(fill)
+		}
+		b.WriteString(s)
+	}
+	hour12 := t.Hour() % 12
+	if hour12 == 0 {
+		hour12 = 12
+	}
+	switch c {
+	case 'a':
+		b.WriteString(t.Weekday().String()[:3])
+	case 'A':
+		b.WriteString(t.Weekday().String())
+	case 'b', 'h':
+		b.WriteString(t.Month().String()[:3])
+	case 'B':
+		b.WriteString(t.Month().String())
+	case 'c':
+		b.WriteString(Strftime("%a %b %e %H:%M:%S %Y", t))
+	case 'C':
+		pad(t.Year()/100, 2, '0')
+	case 'd':
+		pad(t.Day(), 2, '0')
+	case 'D', 'x':
+		b.WriteString(Strftime("%m/%d/%y", t))
+	case 'e':
+		pad(t.Day(), 2, ' ')
+	case 'F':
+		b.WriteString(Strftime("%Y-%m-%d", t))
+	case 'g':
+		year, _ := t.ISOWeek()
+		pad(year%100, 2, '0')
+	case 'G':
+		year, _ := t.ISOWeek()
+		pad(year, 4, '0')
+	case 'H':
+		pad(t.Hour(), 2, '0')
+	case 'I':
+		pad(hour12, 2, '0')
+	case 'j':
+		pad(t.YearDay(), 3, '0')
+	case 'k':
+		pad(t.Hour(), 2, ' ')
+	case 'l':
+		pad(hour12, 2, ' ')
+	case 'm':
+		pad(int(t.Month()), 2, '0')
+	case 'M':
+		pad(t.Minute(), 2, '0')
+	case 'n':
+		b.WriteByte('\n')
+	case 'p':
+		if t.Hour() < 12 {
+			b.WriteString("AM")
+		} else {
+			b.WriteString("PM")
+		}
+	case 'r':
+		b.WriteString(Strftime("%I:%M:%S %p", t))
+	case 'R':
+		b.WriteString(Strftime("%H:%M", t))
+	case 's':
+		b.WriteString(strconv.FormatInt(t.Unix(), 10))
+	case 'S':
+		pad(t.Second(), 2, '0')
+	case 't':
+		b.WriteByte('\t')
+	case 'T', 'X':
+		b.WriteString(Strftime("%H:%M:%S", t))
+	case 'u':
+		pad((int(t.Weekday())+6)%7+1, 1, '0')
+	case 'U':
+		pad((t.YearDay()+6-int(t.Weekday()))/7, 2, '0')
+	case 'V':
+		_, week := t.ISOWeek()
+		pad(week, 2, '0')
+	case 'w':
+		pad(int(t.Weekday()), 1, '0')
+	case 'W':
+		pad((t.YearDay()+6-(int(t.Weekday())+6)%7)/7, 2, '0')
+	case 'y':
+		pad(t.Year()%100, 2, '0')
+	case 'Y':
+		pad(t.Year(), 1, '0')
+	case 'z':
+		_, offset := t.Zone()
+		sign := byte('+')
+		if offset < 0 {
+			sign, offset = '-', -offset
+		}
+		b.WriteByte(sign)
+		pad(offset/3600, 2, '0')
+		pad(offset%3600/60, 2, '0')
+	case 'Z':
+		name, _ := t.Zone()
+		b.WriteString(name)
+	case '%':
+		b.WriteByte('%')
+	default:
+		return false
+	}
+	return true
+}